@@ -0,0 +1,22 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+)
+
+// The default frontend, shipped within the binary. Files in the local
+// `res/` directory take precedence over the embedded ones.
+//
+//go:embed all:res
+var embeddedRes embed.FS
+
+// resFS retrieves the embedded frontend, rooted at its `res/` directory.
+func resFS() fs.FS {
+	fsys, err := fs.Sub(embeddedRes, "res")
+	if err != nil {
+		log.Fatalf("Failed to load the embedded resources: %+v", err)
+	}
+	return fsys
+}
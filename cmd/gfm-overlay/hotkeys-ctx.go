@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"net/http"
 	"sync"
@@ -16,8 +17,12 @@ const configEndpoint = "/ram_store/config"
 // Name of the token within the configuration.
 const tokenName = "run-token"
 
-// How long between the checks of the server's configuration.
-const refreshRate = 5 * time.Second
+// How long the server may hold a request for the configuration,
+// waiting for it to change.
+const watchTimeout = 60 * time.Second
+
+// How long to wait before retrying after failing to fetch the configuration.
+const retryDelay = 5 * time.Second
 
 type event struct {
 	// The key that received the event.
@@ -35,20 +40,42 @@ type ctx struct {
 	token string
 	// Synchronizes access to token.
 	tokenMutex sync.RWMutex
+	// ETag of the last fetched configuration.
+	configETag string
 	// Channel used to receive and handle events in hotkeys.
 	events chan event
 }
 
 // fetchToken fetches the configured token, if any.
+// If the configuration was already fetched,
+// this waits (for up to watchTimeout) until it changes.
 func (c *ctx) fetchToken() (string, error) {
+	url := fmt.Sprintf("%s%s?wait=%s", c.baseURL, configEndpoint, watchTimeout)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		logger.Errorf("hotkeys: failed to create the request: %+v", err)
+		return "", err
+	}
+	if c.configETag != "" {
+		req.Header.Set("If-None-Match", c.configETag)
+	}
+
 	// Fetch the config (which should be a multipart/form-data.
-	resp, err := c.client.Get(c.baseURL + configEndpoint)
+	resp, err := c.client.Do(req)
 	if err != nil {
 		logger.Errorf("hotkeys: failed to fetch the configurations: %+v", err)
 		return "", err
-	} else if resp.StatusCode == http.StatusNotFound {
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return c.getToken(), nil
+	case http.StatusNotFound:
+		c.configETag = ""
 		return "", nil
 	}
+	c.configETag = resp.Header.Get("ETag")
 
 	// Parse the data.
 	reader, err := MultipartReader(resp.Header, resp.Body, false)
@@ -110,15 +137,14 @@ func (c *ctx) swapToken(newToken string) {
 	}
 }
 
-// run updates the ctx with the current run/mode.
+// run updates the ctx with the current run/mode,
+// as soon as the configuration changes.
 func (c *ctx) run() {
-	tick := time.NewTicker(refreshRate)
-	defer tick.Stop()
-
-	for _ = range tick.C {
+	for {
 		token, err := c.fetchToken()
 		if err != nil {
-			// The error has already been logged, simply continue.
+			// The error has already been logged, simply retry later.
+			time.Sleep(retryDelay)
 			continue
 		}
 
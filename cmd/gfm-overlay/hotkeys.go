@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/SirGFM/gfm-speedrun-overlay/common"
 	key_events "github.com/SirGFM/gfm-speedrun-overlay/local/key-events"
 	"github.com/SirGFM/gfm-speedrun-overlay/logger"
 	key_logger "github.com/SirGFM/goLogKeys/logger"
@@ -143,7 +144,10 @@ func StartHotkeys(baseURL, configFilename string) io.Closer {
 		OnKeyRelease: make(map[key_logger.Key]key_events.Action),
 	}
 
-	config := parseINI(configFilename)
+	config, err := common.ParseINI(configFilename)
+	if err != nil {
+		logger.Fatalf("hotkeys: Failed to parse the configuration: %+v", err)
+	}
 
 	if data, ok := config["config"]; ok {
 		poolRate := data["pool-rate"]
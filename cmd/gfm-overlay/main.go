@@ -45,7 +45,9 @@ func main() {
 	/* === RES (DEFAULT) ========================================== */
 
 	resCfg := res.Config{
+		FS:               resFS(),
 		DefaultExtension: ".html",
+		NotFoundPage:     "404.html",
 	}
 
 	err := res.GetHandleFromConfig(srv, resCfg)
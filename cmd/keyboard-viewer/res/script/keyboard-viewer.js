@@ -7,6 +7,8 @@ let keyboard_viewer = function() {
 	let _map = null;
 	/** Interval's ID. */
 	let pollThID = null;
+	/** Stream of changes to the keyboard state. */
+	let _source = null;
 
 	/**
 	 * Send a GET HTTP message.
@@ -117,6 +119,65 @@ let keyboard_viewer = function() {
 		}
 	}
 
+	/**
+	 * Start keyboard viewer, updating the keys as soon as they change.
+	 *
+	 * Instead of polling the keyboard state, this listens for changes
+	 * streamed by 'ram_store' (on '/ram_store/_watch/...'). If the browser
+	 * doesn't support streams, this falls back to polling the state at
+	 * 'fallbackFps'.
+	 *
+	 * See 'start_watching' for a description of the keys dictionary.
+	 *
+     * @param{baseUrl} Base address of 'ram_store' server.
+     * @param{keys} Dictionary of keys.
+     * @param{fallbackFps} How many times per second the keyboard is polled, if streams aren't supported.
+	 */
+	let _start_stream = function(baseUrl, keys, fallbackFps) {
+		if (typeof(EventSource) === 'undefined') {
+			_start_interval(baseUrl, fallbackFps, keys);
+			return;
+		}
+
+		_keys = keys;
+
+		/* State received before the map, applied once it's loaded. */
+		let _pending = null;
+
+		if (_map == null) {
+			let cb = function (e) {
+                _map = JSON.parse(e);
+				console.log(_map);
+
+				if (_pending != null) {
+					_updateKeyboard(_pending);
+					_pending = null;
+				}
+			}
+
+			_get(baseUrl + '/map', cb, null);
+		}
+
+		if (_source == null) {
+			let url = baseUrl.replace(/\/ram_store(\/|$)/, '/ram_store/_watch$1') + '/data';
+
+			_source = new EventSource(url);
+			_source.addEventListener('change', function(e) {
+				let change = JSON.parse(e.data);
+
+				if (change.Deleted) {
+					return;
+				}
+				else if (_map == null) {
+					_pending = change.Body;
+				}
+				else {
+					_updateKeyboard(change.Body);
+				}
+			});
+		}
+	}
+
 	/**
 	 * Stop the keyboard viewer.
 	 */
@@ -125,11 +186,17 @@ let keyboard_viewer = function() {
 			clearInterval(pollThID);
 		}
 		pollThID = null;
+
+		if (_source != null) {
+			_source.close();
+		}
+		_source = null;
 	}
 
 	return {
 		'get': _get,
 		'start_watching': _start_interval,
+		'start_streaming': _start_stream,
 		'stop_watching': _stop_interval,
 	};
 }();
@@ -9,6 +9,14 @@ import (
 
 const Prefix = "/mfh-handler"
 
+// Stable, machine-readable codes for errors reported by the handler.
+const (
+    // The request used an unsupported method
+    CodeBadMethod = "bad-method"
+    // The request was sent to an unknown path
+    CodeInvalidPath = "invalid-path"
+)
+
 // Retrieve the path handled by `serverContext`.
 func (*serverContext) Prefix() string {
     return Prefix
@@ -31,7 +39,7 @@ func (ctx *serverContext) handlePopup(w http.ResponseWriter, req *http.Request,
         enc := json.NewEncoder(w)
         err := enc.Encode(&r)
         if err != nil {
-            logger.Errorf("mfh-handler: Failed to encode the response: %+v (payload: %+v)", Prefix, err, r)
+            logger.Errorf("mfh-handler: Failed to encode the response: %+v (payload: %+v)", err, r)
         }
         break;
     case http.MethodPost:
@@ -50,7 +58,7 @@ func (ctx *serverContext) handlePopup(w http.ResponseWriter, req *http.Request,
     default:
         res := "Invalid method for /mfh-handler/popup"
         status := http.StatusMethodNotAllowed
-        return srv_iface.NewHttpError(nil, "mfh-handler", res, status)
+        return srv_iface.NewCodedHttpError(nil, "mfh-handler", CodeBadMethod, res, status)
     }
 
     return nil
@@ -74,7 +82,7 @@ func (ctx *serverContext) handleOverlayExtras(w http.ResponseWriter, req *http.R
     default:
         res := "Invalid method for /mfh-handler/overlay-extra"
         status := http.StatusMethodNotAllowed
-        return srv_iface.NewHttpError(nil, "mfh-handler", res, status)
+        return srv_iface.NewCodedHttpError(nil, "mfh-handler", CodeBadMethod, res, status)
     }
 
     return nil
@@ -91,7 +99,7 @@ func (ctx *serverContext) Handle(w http.ResponseWriter, req *http.Request, urlPa
         enc := json.NewEncoder(w)
         err := enc.Encode(&r)
         if err != nil {
-            logger.Errorf("mfh-handler: Failed to encode the response: %+v (payload: %+v)", Prefix, err, r)
+            logger.Errorf("mfh-handler: Failed to encode the response: %+v (payload: %+v)", err, r)
         }
 
         return nil
@@ -102,6 +110,6 @@ func (ctx *serverContext) Handle(w http.ResponseWriter, req *http.Request, urlPa
     } else {
         res := "Invalid path"
         status := http.StatusBadRequest
-        return srv_iface.NewHttpError(nil, "mfh-handler", res, status)
+        return srv_iface.NewCodedHttpError(nil, "mfh-handler", CodeInvalidPath, res, status)
     }
 }
@@ -69,6 +69,38 @@ func (e errorCode) Error() string {
     }
 }
 
+// Retrieve the stable, machine-readable code for `errorCode`.
+func (e errorCode) Code() string {
+    switch e {
+    case BadJSONInput:
+        return "bad-json-input"
+    case ResourceNotFound:
+        return "resource-not-found"
+    case TmplCopyResource:
+        return "tmpl-copy-resource"
+    case TmplGetCopyResource:
+        return "tmpl-get-copy-resource"
+    case TmplResourceNotAMap:
+        return "tmpl-resource-not-a-map"
+    case TmplResourceNotStrKeys:
+        return "tmpl-resource-not-str-keys"
+    case TmplResourceNotInterfaceMap:
+        return "tmpl-resource-not-interface-map"
+    case ExtraDataNotAMap:
+        return "extra-data-not-a-map"
+    case ExtraDataNotStrKeys:
+        return "extra-data-not-str-keys"
+    case ExtraDataNotInterfaceMap:
+        return "extra-data-not-interface-map"
+    case ExtraBadTitleCardPage:
+        return "extra-bad-title-card-page"
+    case ExtraBadTitleCard:
+        return "extra-bad-title-card"
+    default:
+        return "unknown"
+    }
+}
+
 // Simple timer for tracking when the service was updated.
 type timer struct {
     // The stored time.
@@ -108,8 +140,8 @@ type extraData struct {
 
 // The context that store page's data.
 type serverContext struct {
-    // Data used to customize pages, keyed by the SHA-256 of the path.
-    data map[string]interface{}
+    // Data used to customize pages.
+    data *tmpl.FileStore
     // Last time the structure was updated.
     lastUpdate timer
     // List of elements that should be temporarily displayed.
@@ -167,6 +199,7 @@ func (ctx *serverContext) getPopupList() []popup {
 
 // Clean up the container, removing all associated resources.
 func (ctx *serverContext) Close() {
+    ctx.data.Close()
 }
 
 // `srv_iface.Server`, so it may be used as a server and for templating.
@@ -176,9 +209,26 @@ type Context interface {
     srv_iface.Handler
 }
 
-// Retrieve a new data server
+// Retrieve a new data server, which keeps every page's data in memory.
 func New() Context {
-    ctx := serverContext {}
-    ctx.data = make(map[string]interface{})
-    return &ctx
+    ctx, _ := NewFromDir("")
+    return ctx
+}
+
+// Retrieve a new data server, which stores every page's data in `dir`, so
+// it's kept across restarts. If `dir` is empty, the data is only kept in
+// memory.
+func NewFromDir(dir string) (Context, error) {
+    var ctx serverContext
+
+    store, err := tmpl.NewFileStore(tmpl.FileStoreConfig {
+        Dir: dir,
+        ReadHook: ctx.mergeExtraData,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    ctx.data = store
+    return &ctx, nil
 }
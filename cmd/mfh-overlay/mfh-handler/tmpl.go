@@ -1,42 +1,30 @@
 package mfh_handler
 
 import (
-    "crypto/sha256"
-    "encoding/base64"
     "encoding/json"
     "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
     "github.com/SirGFM/gfm-speedrun-overlay/web/tmpl"
     "reflect"
     "strings"
 )
 
-// Converts a resource's path into a unique name, from its SHA-256. To
-// convert it into a readable, slightly packed string, encode it as base64.
-func resource2name(resource []string) string {
-    var data []byte
-    dgst := sha256.New()
-
-    for i := range resource {
-        // Go is dumb and says hash.Hash.Write "never returns an error",
-        // so let's use it to our advantage...
-        dgst.Write([]byte(resource[i]))
-    }
-
-    data = dgst.Sum(data)
-    return base64.URLEncoding.EncodeToString(data)
+// Check whether `err` was reported by the `tmpl.FileStore` with `code`.
+func hasCode(err error, code string) bool {
+    herr, ok := err.(srv_iface.HttpError)
+    return ok && herr.GetCode() == code
 }
 
-// store the resource 'data' into the server at 'name'.
-func (ctx *serverContext) store(name string, data tmpl.DataReader) error {
-    var val interface{}
-
-    dec := json.NewDecoder(data)
-    err := dec.Decode(&val)
-    if err != nil {
+// store the resource 'data' into the server at 'resource'.
+func (ctx *serverContext) store(resource []string, data tmpl.DataReader) error {
+    err := ctx.data.Update(resource, data)
+    if hasCode(err, tmpl.CodeInvalidData) {
         logger.Errorf("mfh-handler: Failed to decode %+v's data: %+v", data.URLPath(), err)
         return BadJSONInput
+    } else if err != nil {
+        logger.Errorf("mfh-handler: Failed to store %+v's data: %+v", data.URLPath(), err)
+        return err
     }
-    ctx.data[name] = val
     ctx.update()
 
     return nil
@@ -44,7 +32,7 @@ func (ctx *serverContext) store(name string, data tmpl.DataReader) error {
 
 // Create a new resource. Identical to "Update".
 func (ctx *serverContext) Create(resource []string, data tmpl.DataReader) error {
-    return ctx.store(resource2name(resource), data)
+    return ctx.store(resource, data)
 }
 
 // A custom field that will be added to an existing resource.
@@ -160,18 +148,16 @@ func (ctx *serverContext) Read(resource []string) (interface{}, error) {
         }
     }
 
-    name := resource2name(resource)
-    if _, ok := ctx.data[name]; !ok {
+    // The extra data is merged by `mergeExtraData`
+    data, err := ctx.data.Read(resource)
+    if hasCode(err, tmpl.CodeDataNotFound) {
         logger.Errorf("mfh-handler: Couldn't find the resource associated with %+v", origRes)
         return nil, ResourceNotFound
+    } else if err != nil {
+        return nil, err
     }
 
-    // Set the win flag (and other static resources) from the extra data.
-    customFields = ctx.getExtraData(customFields)
-    defer ctx.unlockExtraData()
-
-    // Add custom fields to the retrieved data.
-    data := ctx.data[name]
+    // Add the layout, which depends on the requested page.
     if len(customFields) > 0 {
         newData, err := addCustomFields(data, customFields)
         if err != nil {
@@ -185,20 +171,40 @@ func (ctx *serverContext) Read(resource []string) (interface{}, error) {
     return data, nil
 }
 
+// Set the win flag (and other static resources) from the extra data in
+// the data retrieved for `resource`. Used as the `FileStore`'s `ReadHook`.
+func (ctx *serverContext) mergeExtraData(resource []string, data interface{}) (interface{}, error) {
+    customFields := ctx.getExtraData(nil)
+    defer ctx.unlockExtraData()
+
+    if len(customFields) == 0 {
+        return data, nil
+    }
+
+    newData, err := addCustomFields(data, customFields)
+    if err != nil {
+        logger.Errorf("mfh-handler: Couldn't add the extra data to the resource %+v", resource)
+        return nil, err
+    }
+    return newData, nil
+}
+
 // Update an already existing resource. Identical to "Create".
 func (ctx *serverContext) Update(resource []string, data tmpl.DataReader) error {
-    return ctx.store(resource2name(resource), data)
+    return ctx.store(resource, data)
 }
 
 // Remove the resource.
 func (ctx *serverContext) Delete(resource []string) error {
-    name := resource2name(resource)
-    if _, ok := ctx.data[name]; !ok {
+    err := ctx.data.Delete(resource)
+    if hasCode(err, tmpl.CodeDataNotFound) {
         logger.Errorf("mfh-handler: No resource associated with %+v", resource)
         return ResourceNotFound
+    } else if err != nil {
+        logger.Errorf("mfh-handler: Failed to remove %+v: %+v", resource, err)
+        return err
     }
 
-    delete(ctx.data, name)
     return nil
 }
 
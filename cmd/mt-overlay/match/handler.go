@@ -9,6 +9,9 @@ import (
 
 const Prefix = "/mt-server"
 
+// Stable, machine-readable code for requests to an unknown path.
+const CodeInvalidPath = "invalid-path"
+
 // Retrieve the path handled by `serverContext`.
 func (*serverContext) Prefix() string {
     return Prefix
@@ -31,7 +34,7 @@ func (ctx *serverContext) Handle(w http.ResponseWriter, req *http.Request, urlPa
         enc := json.NewEncoder(w)
         err := enc.Encode(&r)
         if err != nil {
-            logger.Errorf("mt-server: Failed to encode the response: %+v (payload: %+v)", Prefix, err, r)
+            logger.Errorf("mt-server: Failed to encode the response: %+v (payload: %+v)", err, r)
         }
 
         return nil
@@ -43,13 +46,13 @@ func (ctx *serverContext) Handle(w http.ResponseWriter, req *http.Request, urlPa
         enc := json.NewEncoder(w)
         err := enc.Encode(tp)
         if err != nil {
-            logger.Errorf("mt-server: Failed to encode the response: %+v (payload: %+v)", Prefix, err, tp)
+            logger.Errorf("mt-server: Failed to encode the response: %+v (payload: %+v)", err, tp)
         }
 
         return nil
     } else {
         res := "Invalid path"
         status := http.StatusBadRequest
-        return srv_iface.NewHttpError(nil, "mt-server", res, status)
+        return srv_iface.NewCodedHttpError(nil, "mt-server", CodeInvalidPath, res, status)
     }
 }
@@ -55,6 +55,30 @@ func (e errorCode) Error() string {
     }
 }
 
+// Retrieve the stable, machine-readable code for `errorCode`.
+func (e errorCode) Code() string {
+    switch e {
+    case NotImplemented:
+        return "not-implemented"
+    case WantJSONData:
+        return "want-json-data"
+    case BadJSONInput:
+        return "bad-json-input"
+    case InvalidUpdateURL:
+        return "invalid-update-url"
+    case InvalidGetURL:
+        return "invalid-get-url"
+    case InvalidWinner:
+        return "invalid-winner"
+    case InvalidMapURL:
+        return "invalid-map-url"
+    case InvalidTPPlayer:
+        return "invalid-tp-player"
+    default:
+        return "unknown"
+    }
+}
+
 // Describes player
 type player struct {
     // The player's name
@@ -121,10 +145,17 @@ type mtServerData struct {
     UseTangibleProgress bool
 }
 
+// Resource, in the `tmpl.FileStore`, where the race is saved. Since every
+// page shares the same race (which is partially updated by some requests),
+// it's saved as a single resource.
+var raceResource = []string { "mt-server", "race" }
+
 // The context that store page's data.
 type serverContext struct {
     // The on-going race
     data mtServerData
+    // Saves the race, so it's kept across restarts.
+    store *tmpl.FileStore
     // Last time the structure was updated.
     lastUpdate time.Time `json:"-"`
     // Whether the tangible progress should be shown for any player
@@ -309,6 +340,16 @@ func (ctx *serverContext) Update(resource []string, data tmpl.DataReader) error
 
     if err == nil {
         ctx.lastUpdate = time.Now()
+        err = ctx.save()
+    }
+    return err
+}
+
+// Save the race to the store.
+func (ctx *serverContext) save() error {
+    err := ctx.store.Save(raceResource, &ctx.data)
+    if err != nil {
+        logger.Errorf("mt-server: Failed to save the race: %+v", err)
     }
     return err
 }
@@ -320,6 +361,7 @@ func (ctx *serverContext) Delete(resource []string) error {
 
 // Clean up the container, removing all associated resources.
 func (ctx *serverContext) Close() {
+    ctx.store.Close()
 }
 
 // Map a given resource into another resource
@@ -345,8 +387,28 @@ type Context interface {
     srv_iface.Handler
 }
 
-// Retrieve a new data server
+// Retrieve a new data server, which keeps the race in memory.
 func New() Context {
-    ctx := serverContext {}
-    return &ctx
+    ctx, _ := NewFromDir("")
+    return ctx
+}
+
+// Retrieve a new data server, which saves the race in `dir`, so it's kept
+// across restarts. If `dir` is empty, the race is only kept in memory.
+func NewFromDir(dir string) (Context, error) {
+    store, err := tmpl.NewFileStore(tmpl.FileStoreConfig {
+        Dir: dir,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    ctx := serverContext {
+        store: store,
+    }
+    _, err = store.Load(raceResource, &ctx.data)
+    if err != nil {
+        return nil, err
+    }
+    return &ctx, nil
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/SirGFM/gfm-speedrun-overlay/common"
+	"github.com/SirGFM/gfm-speedrun-overlay/logger"
+)
+
+// Name of the section that configures the server itself.
+const serverSection = "server"
+
+// Default port used if the configuration doesn't specify any.
+const defaultPort = 8080
+
+// serverConfig configures the server itself.
+type serverConfig struct {
+	// The address where the server listens.
+	host string
+	// The port where the server listens.
+	port int
+	// Name (or prefix) of the handler used when a request doesn't match any other handler.
+	defaultHandler string
+	// Whether the working directory should be changed to the binary's directory.
+	chdir bool
+}
+
+// getBool retrieves a boolean attribute from a section,
+// defaulting to def if the attribute isn't set.
+func getBool(section common.INISection, name, key string, def bool) bool {
+	value, ok := section[key]
+	if !ok || value == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Fatalf("config: Invalid boolean for [%s].%s: %+v", name, key, err)
+	}
+	return b
+}
+
+// getInt retrieves an integer attribute from a section,
+// defaulting to def if the attribute isn't set.
+func getInt(section common.INISection, name, key string, def int) int {
+	value, ok := section[key]
+	if !ok || value == "" {
+		return def
+	}
+
+	num, err := strconv.ParseInt(value, 0, 32)
+	if err != nil {
+		logger.Fatalf("config: Invalid integer for [%s].%s: %+v", name, key, err)
+	}
+	return int(num)
+}
+
+// getList retrieves a comma-separated list of values from a section.
+func getList(section common.INISection, key string) []string {
+	var list []string
+
+	for _, value := range strings.Split(section[key], ",") {
+		value = strings.Trim(value, "\t ")
+		if value != "" {
+			list = append(list, value)
+		}
+	}
+
+	return list
+}
+
+// getMap retrieves every attribute starting with prefix from a section,
+// indexed by the rest of the attribute's name
+// (e.g., 'mime.woff2 = font/woff2' with prefix 'mime.').
+func getMap(section common.INISection, prefix string) map[string]string {
+	m := make(map[string]string)
+
+	for key, value := range section {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			m[key[len(prefix):]] = value
+		}
+	}
+
+	return m
+}
+
+// isEnabled checks whether a section is enabled.
+// Sections are enabled by simply being in the configuration,
+// but they may be explicitly disabled with 'enabled = false'.
+func isEnabled(cfg common.INIConfig, name string) bool {
+	section, ok := cfg[name]
+	return ok && getBool(section, name, "enabled", true)
+}
+
+// parseServerConfig retrieves the server's configuration.
+func parseServerConfig(cfg common.INIConfig) serverConfig {
+	section := cfg[serverSection]
+
+	return serverConfig{
+		host:           section["host"],
+		port:           getInt(section, serverSection, "port", defaultPort),
+		defaultHandler: section["default"],
+		chdir:          getBool(section, serverSection, "chdir", false),
+	}
+}
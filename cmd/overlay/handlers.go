@@ -0,0 +1,425 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/SirGFM/gfm-speedrun-overlay/cmd/mfh-overlay/mfh-handler"
+	"github.com/SirGFM/gfm-speedrun-overlay/cmd/mt-overlay/match"
+	"github.com/SirGFM/gfm-speedrun-overlay/common"
+	"github.com/SirGFM/gfm-speedrun-overlay/local/key-events"
+	"github.com/SirGFM/gfm-speedrun-overlay/local/obs-text"
+	"github.com/SirGFM/gfm-speedrun-overlay/logger"
+	"github.com/SirGFM/gfm-speedrun-overlay/web/ram-store"
+	"github.com/SirGFM/gfm-speedrun-overlay/web/reload"
+	"github.com/SirGFM/gfm-speedrun-overlay/web/res"
+	"github.com/SirGFM/gfm-speedrun-overlay/web/run"
+	srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+	"github.com/SirGFM/gfm-speedrun-overlay/web/splits"
+	"github.com/SirGFM/gfm-speedrun-overlay/web/theme"
+	"github.com/SirGFM/gfm-speedrun-overlay/web/timer"
+	"github.com/SirGFM/gfm-speedrun-overlay/web/tmpl"
+)
+
+// tmplData is implemented by handlers that may also store the data for tmpl.
+type tmplData interface {
+	tmpl.DataCRUD
+	tmpl.Mapper
+}
+
+// builder tracks the state shared among the handlers while composing the server.
+type builder struct {
+	// The server being composed.
+	srv srv_iface.Server
+	// The parsed configuration.
+	cfg common.INIConfig
+	// Prefix of every enabled handler, keyed by its section's name.
+	prefixes map[string]string
+	// Handlers that may be used as tmpl's data, keyed by their section's name.
+	data map[string]tmplData
+	// The theme manager, if themes are enabled.
+	themes *theme.Manager
+	// The reload hub, if automatic reloads are enabled.
+	reload *reload.Hub
+}
+
+// handlerSetup configures a handler from its section in the configuration.
+type handlerSetup func(b *builder, name string, section common.INISection) (prefix string, err error)
+
+// Every handler that may be enabled in the configuration.
+// Handlers are added in this order,
+// so handlers used by others (e.g., mt-server by tmpl) must come first.
+var handlers = []struct {
+	name  string
+	setup handlerSetup
+}{
+	{"reload", setupReload},
+	{"themes", setupThemes},
+	{"res", setupRes},
+	{"splits", setupSplits},
+	{"run", setupRun},
+	{"timer", setupTimer},
+	{"ram_store", setupRamStore},
+	{"mt-server", setupMTServer},
+	{"mfh-handler", setupMFHHandler},
+	{"tmpl", setupTmpl},
+}
+
+// Sections that don't configure a handler.
+var otherSections = []string{
+	serverSection,
+	"cors",
+	"access_log",
+	"key_events",
+	"obs_text",
+}
+
+// checkSections ensures that every section in the configuration is known,
+// so typos don't silently disable a handler.
+func checkSections(cfg common.INIConfig) {
+	known := make(map[string]bool)
+	for _, h := range handlers {
+		known[h.name] = true
+	}
+	for _, name := range otherSections {
+		known[name] = true
+	}
+
+	for name := range cfg {
+		if !known[name] {
+			logger.Fatalf("config: Unknown section [%s]", name)
+		}
+	}
+}
+
+// mkdir creates a directory (and its parents), if it doesn't exist yet.
+func mkdir(name, dir string) (string, error) {
+	if dir == "" {
+		dir = name
+	}
+	dir = filepath.Clean(dir)
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create '%s': %w", dir, err)
+	}
+	return dir, nil
+}
+
+func setupReload(b *builder, name string, section common.INISection) (string, error) {
+	cfg := reload.Config{
+		Interval: time.Duration(getInt(section, name, "interval", 0)) * time.Millisecond,
+	}
+
+	var err error
+	b.reload, err = reload.GetHandleFromConfig(b.srv, cfg)
+	return reload.Prefix, err
+}
+
+// notifier retrieves the reload hub,
+// or nil if automatic reloads aren't enabled.
+func (b *builder) notifier() reload.Notifier {
+	if b.reload == nil {
+		return nil
+	}
+	return b.reload
+}
+
+func setupThemes(b *builder, name string, section common.INISection) (string, error) {
+	dir, err := mkdir(name, section["dir"])
+	if err != nil {
+		return "", err
+	}
+
+	cfg := theme.Config{
+		Dir:      dir,
+		Active:   section["active"],
+		Notifier: b.notifier(),
+	}
+
+	b.themes, err = theme.GetHandleFromConfig(b.srv, cfg)
+	return theme.Prefix, err
+}
+
+// themeDir retrieves a directory of the active theme,
+// or nil if themes aren't enabled.
+func (b *builder) themeDir(dir string) fs.FS {
+	if b.themes == nil {
+		return nil
+	}
+	return b.themes.Sub(dir)
+}
+
+func setupRes(b *builder, name string, section common.INISection) (string, error) {
+	cfg := res.Config{
+		Dirs:               getList(section, "dirs"),
+		DefaultPage:        section["default-page"],
+		DefaultExtension:   section["default-extension"],
+		DisableCompression: !getBool(section, name, "compress", true),
+		CacheControl:       section["cache-control"],
+		HashedCacheControl: section["hashed-cache-control"],
+		HashedPattern:      section["hashed-pattern"],
+		MimeTypes:          getMap(section, "mime."),
+		SPAIndex:           section["spa-index"],
+		NotFoundPage:       section["not-found-page"],
+		Theme:              b.themeDir("res"),
+		Notifier:           b.notifier(),
+	}
+
+	return res.Prefix, res.GetHandleFromConfig(b.srv, cfg)
+}
+
+func setupSplits(b *builder, name string, section common.INISection) (string, error) {
+	dir, err := mkdir(name, section["dir"])
+	if err != nil {
+		return "", err
+	}
+
+	return splits.Prefix, splits.GetHandle(b.srv, dir)
+}
+
+func setupRun(b *builder, name string, section common.INISection) (string, error) {
+	dir, err := mkdir(name, section["dir"])
+	if err != nil {
+		return "", err
+	}
+
+	return run.Prefix, run.GetHandle(b.srv, dir)
+}
+
+func setupTimer(b *builder, name string, section common.INISection) (string, error) {
+	return timer.Prefix, timer.GetHandle(b.srv)
+}
+
+func setupRamStore(b *builder, name string, section common.INISection) (string, error) {
+	cfg := ram_store.Config{
+		MaxBytes:        int64(getInt(section, name, "max-bytes", 0)),
+		PubSubRetention: getInt(section, name, "retention", 0),
+	}
+
+	if section["snapshot-dir"] != "" {
+		cfg.SnapshotDir = section["snapshot-dir"]
+		cfg.SnapshotInterval = time.Duration(getInt(section, name, "snapshot-interval", 60)) * time.Second
+		cfg.SnapshotOnChange = getBool(section, name, "snapshot-on-change", true)
+	}
+
+	return ram_store.Prefix, ram_store.GetHandleFromConfig(b.srv, cfg)
+}
+
+func setupMTServer(b *builder, name string, section common.INISection) (string, error) {
+	var dir string
+	if _, ok := section["dir"]; ok {
+		var err error
+		dir, err = mkdir(name, section["dir"])
+		if err != nil {
+			return "", err
+		}
+	}
+
+	ctx, err := match.NewFromDir(dir)
+	if err != nil {
+		return "", err
+	}
+	b.data[name] = ctx
+	return match.Prefix, b.srv.AddHandler(ctx)
+}
+
+func setupMFHHandler(b *builder, name string, section common.INISection) (string, error) {
+	var dir string
+	if _, ok := section["dir"]; ok {
+		var err error
+		dir, err = mkdir(name, section["dir"])
+		if err != nil {
+			return "", err
+		}
+	}
+
+	ctx, err := mfh_handler.NewFromDir(dir)
+	if err != nil {
+		return "", err
+	}
+	b.data[name] = ctx
+	return mfh_handler.Prefix, b.srv.AddHandler(ctx)
+}
+
+func setupTmpl(b *builder, name string, section common.INISection) (string, error) {
+	cfg := tmpl.Config{
+		Dirs:           getList(section, "dirs"),
+		MimeTypes:      getMap(section, "mime."),
+		Theme:          b.themeDir("tmpl"),
+		Partials:       section["partials"],
+		ExportDir:      section["export-dir"],
+		TextExtensions: getList(section, "text-extensions"),
+		Notifier:       b.notifier(),
+	}
+
+	dataName := section["data"]
+	if dataName == "file" {
+		dir, err := mkdir("tmpl-data", section["data-dir"])
+		if err != nil {
+			return "", err
+		}
+
+		cfg.Data, err = tmpl.NewFileStore(tmpl.FileStoreConfig{Dir: dir})
+		if err != nil {
+			return "", err
+		}
+	} else if data, ok := b.data[dataName]; ok {
+		cfg.Data = data
+		cfg.Mapper = data
+	} else {
+		return "", fmt.Errorf("[%s].data must be 'file' or name an enabled handler (got '%s')", name, dataName)
+	}
+
+	return tmpl.Prefix, tmpl.GetHandleFromConfig(b.srv, cfg)
+}
+
+// composeServer adds every enabled handler to srv, as described by cfg.
+func composeServer(srv srv_iface.Server, cfg common.INIConfig) {
+	b := builder{
+		srv:      srv,
+		cfg:      cfg,
+		prefixes: make(map[string]string),
+		data:     make(map[string]tmplData),
+	}
+
+	for _, h := range handlers {
+		if !isEnabled(cfg, h.name) {
+			continue
+		}
+
+		prefix, err := h.setup(&b, h.name, cfg[h.name])
+		if err != nil {
+			logger.Fatalf("Failed to add '%s' to the server: %+v", h.name, err)
+		}
+		b.prefixes[h.name] = prefix
+		logger.Infof("Added '%s' to the server (%s)", h.name, prefix)
+	}
+
+	if isEnabled(cfg, "cors") {
+		section := cfg["cors"]
+		corsCfg := srv_iface.CORSConfig{
+			AllowedOrigins: getList(section, "origins"),
+			AllowedMethods: getList(section, "methods"),
+			AllowedHeaders: getList(section, "headers"),
+			ExposedHeaders: getList(section, "expose"),
+			MaxAge:         time.Duration(getInt(section, "cors", "max-age", 0)) * time.Second,
+		}
+
+		err := srv.SetCORS(corsCfg)
+		if err != nil {
+			logger.Fatalf("Failed to configure CORS: %+v", err)
+		}
+	}
+
+	if isEnabled(cfg, "access_log") {
+		section := cfg["access_log"]
+		logCfg := srv_iface.AccessLogConfig{
+			Filename:   section["file"],
+			MaxSize:    int64(getInt(section, "access_log", "max-size", 0)) * 1024 * 1024,
+			MaxBackups: getInt(section, "access_log", "max-backups", 0),
+		}
+		if logCfg.Filename == "" {
+			logCfg.Filename = "access.log"
+		}
+
+		switch section["format"] {
+		case "", "common":
+			logCfg.Format = srv_iface.CommonLogFormat
+		case "json":
+			logCfg.Format = srv_iface.JSONLogFormat
+		default:
+			logger.Fatalf("config: Invalid format '%s' in [access_log] (must be 'common' or 'json')", section["format"])
+		}
+
+		err := srv.SetAccessLog(logCfg)
+		if err != nil {
+			logger.Fatalf("Failed to open the access log: %+v", err)
+		}
+	}
+
+	def := parseServerConfig(cfg).defaultHandler
+	if def != "" {
+		prefix, ok := b.prefixes[def]
+		if !ok && strings.HasPrefix(def, "/") {
+			prefix = def
+		}
+
+		err := srv.SetDefault(prefix)
+		if err != nil {
+			logger.Fatalf("Failed to set '%s' as the default handler: %+v", def, err)
+		}
+	}
+}
+
+// startKeyEvents starts sending the keyboard's state to the ram_store,
+// if enabled in the configuration.
+func startKeyEvents(cfg common.INIConfig, port int) key_events.Watcher {
+	const name = "key_events"
+
+	if !isEnabled(cfg, name) {
+		return nil
+	} else if !isEnabled(cfg, "ram_store") {
+		logger.Fatalf("config: [%s] requires [ram_store]", name)
+	}
+
+	section := cfg[name]
+	store := section["store"]
+	if store == "" {
+		store = ram_store.Prefix + "/keyboard"
+	}
+
+	keyCfg := key_events.WatcherConfig{
+		PoolPerSec:        getInt(section, name, "pool-rate", 20),
+		BaseStoreEndpoint: fmt.Sprintf("http://localhost:%d%s", port, store),
+	}
+	if events := section["events"]; events != "" {
+		keyCfg.EventsEndpoint = fmt.Sprintf("http://localhost:%d%s", port, events)
+	}
+	return key_events.NewEventWatcher(keyCfg)
+}
+
+// startOBSText starts writing the run's state to text files,
+// if enabled in the configuration.
+func startOBSText(cfg common.INIConfig, port int) obs_text.Exporter {
+	const name = "obs_text"
+
+	if !isEnabled(cfg, name) {
+		return nil
+	}
+
+	section := cfg[name]
+	token, ok := section["token"]
+	if !ok && isEnabled(cfg, "run") {
+		token = run.LatestToken
+	} else if !ok && !isEnabled(cfg, "timer") {
+		logger.Fatalf("config: [%s] requires either [run] or [timer]", name)
+	}
+
+	dir, err := mkdir("obs", section["dir"])
+	if err != nil {
+		logger.Fatalf("config: %+v", err)
+	}
+
+	exportCfg := obs_text.Config{
+		BaseURL:  fmt.Sprintf("http://localhost:%d", port),
+		Token:    token,
+		Interval: time.Duration(getInt(section, name, "interval", 0)) * time.Millisecond,
+	}
+	for file, format := range getMap(section, "file.") {
+		exportCfg.Files = append(exportCfg.Files, obs_text.File{
+			Path:   filepath.Join(dir, file),
+			Format: strings.Replace(format, `\n`, "\n", -1),
+		})
+	}
+
+	exporter, err := obs_text.NewExporter(exportCfg)
+	if err != nil {
+		logger.Fatalf("Failed to start [%s]: %+v", name, err)
+	}
+	return exporter
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/SirGFM/gfm-speedrun-overlay/common"
+	"github.com/SirGFM/gfm-speedrun-overlay/logger"
+	"github.com/SirGFM/gfm-speedrun-overlay/web/server"
+)
+
+// changeToAppDir changes the current working directory to the binary's directory.
+// Panics on failure.
+func changeToAppDir() {
+	appPath := filepath.Clean(os.Args[0])
+	appPath, err := filepath.Abs(appPath)
+	if err != nil {
+		logger.Fatalf("Couldn't retrieve the absolute path to the application: %+v", err)
+	}
+
+	appDir := filepath.Dir(appPath)
+	err = os.Chdir(appDir)
+	if err != nil {
+		logger.Fatalf("Couldn't cd into the application's directory: %+v", err)
+	}
+}
+
+func main() {
+	configFile := flag.String("config", "overlay.ini", "The configuration file describing the server")
+	flag.Parse()
+
+	logger.RegisterDefault(logger.LogInfo, logger.LogDebug, os.Stdout, os.Stderr)
+
+	cfg, err := common.ParseINI(*configFile)
+	if err != nil {
+		logger.Fatalf("Failed to parse the configuration: %+v", err)
+	}
+	checkSections(cfg)
+
+	srvCfg := parseServerConfig(cfg)
+	if srvCfg.chdir {
+		changeToAppDir()
+	}
+
+	srv := server.New()
+	composeServer(srv, cfg)
+
+	lst, err := srv.Listen(srvCfg.host, srvCfg.port)
+	if err != nil {
+		logger.Fatalf("Failed to start server: %+v", err)
+	}
+	defer lst.Close()
+	logger.Infof("Started running server on %s:%d", srvCfg.host, srvCfg.port)
+
+	keyWatcher := startKeyEvents(cfg, srvCfg.port)
+	if keyWatcher != nil {
+		defer keyWatcher.Close()
+	}
+
+	obsText := startOBSText(cfg, srvCfg.port)
+	if obsText != nil {
+		defer obsText.Close()
+	}
+
+	intHndlr := make(chan os.Signal, 1)
+	signal.Notify(intHndlr, os.Interrupt)
+	<-intHndlr
+	logger.Infof("Exiting...")
+}
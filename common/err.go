@@ -23,6 +23,10 @@ const (
     ErrDir
     // Couldn't open the requested file
     ErrOpenFile
+    // Couldn't read the configuration file
+    ErrReadConfig
+    // Invalid line in the configuration file
+    ErrParseConfig
 )
 
 // An error associated to its possible cause (which may be nil).
@@ -53,6 +57,10 @@ func (we wrappedErr) Error() string {
         s = "common: The specified path names a directory, and not a regular file"
     case ErrOpenFile:
         s = "common: Couldn't open the requested file"
+    case ErrReadConfig:
+        s = "common: Couldn't read the configuration file"
+    case ErrParseConfig:
+        s = "common: Invalid line in the configuration file"
     default:
         s = "common: Unknown"
     }
@@ -0,0 +1,87 @@
+// Helper functionalities that aren't exclusively related to web-servers.
+
+package common
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+)
+
+// Attributes within a section of an INI file.
+type INISection map[string]string
+
+// Every section within an INI file, keyed by the section's name.
+type INIConfig map[string]INISection
+
+// Parse the INI file `filename`. An empty `filename` results in an empty
+// configuration.
+//
+// Comments start with either ';' or '#' and go until the end of the line.
+// Every attribute must be within a section. If a section is repeated, only
+// its last occurrence is kept.
+func ParseINI(filename string) (INIConfig, error) {
+    if filename == "" {
+        return make(INIConfig), nil
+    }
+
+    data, err := os.ReadFile(filename)
+    if err != nil {
+        return nil, newError(err, ErrReadConfig)
+    }
+
+    sections := make(INIConfig)
+    curSection := ""
+
+    buf := bytes.NewBuffer(data)
+    reading := true
+    for lineNumber := 1; reading; lineNumber++ {
+        line, err := buf.ReadString('\n')
+        if err != nil && err != io.EOF {
+            return nil, newError(fmt.Errorf("line %d: %w", lineNumber, err), ErrReadConfig)
+        }
+
+        reading = (err == nil)
+
+        // Remove comments.
+        line, _, _ = strings.Cut(line, ";")
+        line, _, _ = strings.Cut(line, "#")
+        // Remove whitespaces.
+        line = strings.Trim(line, "\t \r\n")
+        // Skip empty lines.
+        if line == "" {
+            continue
+        }
+
+        // Check if it's a section.
+        if line[0] == '[' && line[len(line)-1] == ']' {
+            curSection = line[1 : len(line)-1]
+            sections[curSection] = make(INISection)
+            continue
+        }
+
+        // Ensure that a section is being parsed.
+        if curSection == "" {
+            err := fmt.Errorf("line %d: attribute outside any section", lineNumber)
+            return nil, newError(err, ErrParseConfig)
+        }
+
+        // Parse attributes.
+        key, value, valid := strings.Cut(line, "=")
+        if !valid {
+            err := fmt.Errorf("line %d: expected a '<key> = <value>' attribute", lineNumber)
+            return nil, newError(err, ErrParseConfig)
+        }
+
+        // Remove whitespaces.
+        key = strings.Trim(key, "\t ")
+        value = strings.Trim(value, "\t ")
+
+        // Save the attribute.
+        sections[curSection][key] = value
+    }
+
+    return sections, nil
+}
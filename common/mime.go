@@ -0,0 +1,124 @@
+// Helper functionalities that aren't exclusively related to web-servers.
+
+package common
+
+import (
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    "io"
+    "mime"
+    "net/http"
+    "path/filepath"
+    "strings"
+)
+
+// Content type used if it can't be detected in any other way.
+const DefaultContentType = "application/octet-stream"
+
+// Content types for extensions that are commonly used by overlays. These
+// take precedence over the system's MIME database, which may be missing
+// (e.g., on Windows) or differ between systems.
+var builtinMimeTypes = map[string]string {
+    ".bmp": "image/bmp",
+    ".css": "text/css",
+    ".csv": "text/csv",
+    ".gif": "image/gif",
+    ".htm": "text/html",
+    ".html": "text/html",
+    ".ico": "image/x-icon",
+    ".jpeg": "image/jpeg",
+    ".jpg": "image/jpeg",
+    ".js": "text/javascript",
+    ".json": "application/json",
+    ".map": "application/json",
+    ".mjs": "text/javascript",
+    ".mp3": "audio/mpeg",
+    ".mp4": "video/mp4",
+    ".oga": "audio/ogg",
+    ".ogg": "audio/ogg",
+    ".ogv": "video/ogg",
+    ".otf": "font/otf",
+    ".png": "image/png",
+    ".svg": "image/svg+xml",
+    ".ttf": "font/ttf",
+    ".txt": "text/plain; charset=utf-8",
+    ".wasm": "application/wasm",
+    ".wav": "audio/wav",
+    ".webm": "video/webm",
+    ".webp": "image/webp",
+    ".woff": "font/woff",
+    ".woff2": "font/woff2",
+    ".xml": "application/xml",
+}
+
+// Maps files into their content type. The type is looked up, in order:
+//
+//   1. In the registry's own types, configured when it's created;
+//   2. In the built-in types;
+//   3. In the system's MIME database (see `mime.TypeByExtension`);
+//   4. By sniffing the file's content (see `http.DetectContentType`).
+//
+// If none of these succeeds, `DefaultContentType` is used.
+type MimeRegistry struct {
+    // Content type for each extension (including its leading '.').
+    types map[string]string
+}
+
+// The registry used by `OpenFile` and `OpenFileFS`.
+var DefaultMimeRegistry = NewMimeRegistry(nil)
+
+// Create a new `MimeRegistry`, with custom content types for the
+// extensions in `types`. Extensions may be supplied with or without their
+// leading '.' (e.g., both ".txt" and "txt" are accepted).
+func NewMimeRegistry(types map[string]string) *MimeRegistry {
+    m := &MimeRegistry {
+        types: make(map[string]string),
+    }
+
+    for ext, ctype := range types {
+        ext = strings.ToLower(ext)
+        if !strings.HasPrefix(ext, ".") {
+            ext = "." + ext
+        }
+        m.types[ext] = ctype
+    }
+    return m
+}
+
+// Retrieve the content type associated with the extension of `filePath`,
+// or an empty string if it's unknown.
+func (m *MimeRegistry) TypeByExtension(filePath string) string {
+    ext := strings.ToLower(filepath.Ext(filePath))
+    if len(ext) == 0 {
+        return ""
+    }
+
+    if ctype, ok := m.types[ext]; ok {
+        return ctype
+    } else if ctype, ok := builtinMimeTypes[ext]; ok {
+        return ctype
+    }
+    return mime.TypeByExtension(ext)
+}
+
+// Retrieve the content type of `filePath`. If it can't be deduced from the
+// file's extension, it's detected from the first bytes in `content` (which
+// is rewound afterwards). `content` may be nil, in which case the content
+// isn't sniffed.
+func (m *MimeRegistry) ContentType(filePath string, content io.ReadSeeker) string {
+    if ctype := m.TypeByExtension(filePath); len(ctype) > 0 {
+        return ctype
+    } else if content == nil {
+        return DefaultContentType
+    }
+
+    var buf [512]byte
+    n, err := io.ReadFull(content, buf[:])
+    _, seekErr := content.Seek(0, io.SeekStart)
+    if (err != nil && err != io.EOF && err != io.ErrUnexpectedEOF) || seekErr != nil {
+        return DefaultContentType
+    }
+
+    ctype := http.DetectContentType(buf[:n])
+    logger.Debugf("common: Unknown file extension for '%s', detected as '%s'", filePath, ctype)
+    return ctype
+}
@@ -0,0 +1,105 @@
+package common
+
+import (
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "testing/fstest"
+)
+
+func TestContentType(t *testing.T) {
+    m := NewMimeRegistry(map[string]string {
+        ".js": "application/javascript",
+        "TMPL": "text/x-template",
+    })
+
+    const html = "<!DOCTYPE html><html><body>x</body></html>"
+    for _, tc := range []struct {
+        desc string
+        filePath string
+        content string
+        ctype string
+    } {
+        { "registry over built-in", "a/main.js", "", "application/javascript" },
+        { "registry without '.'", "page.tmpl", "", "text/x-template" },
+        { "case insensitive", "PAGE.TmPl", "", "text/x-template" },
+        { "built-in over system", "font.woff2", "", "font/woff2" },
+        { "built-in text", "notes.txt", html, "text/plain; charset=utf-8" },
+        { "system", "doc.pdf", "", "application/pdf" },
+        { "sniffed (no extension)", "index", html, "text/html; charset=utf-8" },
+        { "sniffed (unknown extension)", "data.zzz-unknown", "\x89PNG\r\n\x1a\n", "image/png" },
+        { "sniffed text", "README", "just text", "text/plain; charset=utf-8" },
+    } {
+        content := strings.NewReader(tc.content)
+        if got := m.ContentType(tc.filePath, content); got != tc.ctype {
+            t.Errorf("%s: expected '%s', got '%s'", tc.desc, tc.ctype, got)
+        }
+
+        // The content must be rewound after being sniffed
+        if data, _ := io.ReadAll(content); string(data) != tc.content {
+            t.Errorf("%s: expected the content to be rewound, got '%s'", tc.desc, data)
+        }
+    }
+
+    if got := m.ContentType("index", nil); got != DefaultContentType {
+        t.Errorf("Without content: expected '%s', got '%s'", DefaultContentType, got)
+    }
+}
+
+func TestOpenFile(t *testing.T) {
+    dir := t.TempDir()
+    err := os.WriteFile(filepath.Join(dir, "page"), []byte("<html></html>"), 0644)
+    if err != nil {
+        t.Fatalf("Failed to create the test file: %+v", err)
+    }
+    os.Mkdir(filepath.Join(dir, "sub"), 0755)
+
+    fsys := fstest.MapFS {
+        "page": { Data: []byte("<html></html>") },
+        "style.css": { Data: []byte("<html></html>") },
+        "sub/x.txt": { Data: []byte("x") },
+    }
+
+    for _, tc := range []struct {
+        filePath string
+        ctype string
+        err error
+    } {
+        { "page", "text/html; charset=utf-8", nil },
+        { "sub", "", newError(nil, ErrDir) },
+    } {
+        f, ctype, err := OpenFile(dir, tc.filePath)
+        if f != nil {
+            f.Close()
+        }
+        if ctype != tc.ctype || err != tc.err {
+            t.Errorf("OpenFile('%s'): expected '%s' (%v), got '%s' (%v)", tc.filePath, tc.ctype, tc.err, ctype, err)
+        }
+    }
+
+    for _, tc := range []struct {
+        filePath string
+        ctype string
+        err error
+    } {
+        { "page", "text/html; charset=utf-8", nil },
+        { "style.css", "text/css", nil },
+        { "sub", "", newError(nil, ErrDir) },
+    } {
+        f, ctype, err := OpenFileFS(fsys, tc.filePath)
+        if f != nil {
+            f.Close()
+        }
+        if ctype != tc.ctype || err != tc.err {
+            t.Errorf("OpenFileFS('%s'): expected '%s' (%v), got '%s' (%v)", tc.filePath, tc.ctype, tc.err, ctype, err)
+        }
+    }
+
+    if _, _, err := OpenFileFS(fsys, "../page"); err == nil {
+        t.Errorf("OpenFileFS('../page'): expected an error")
+    } else if _, _, err := OpenFile(dir, "missing"); err == nil {
+        t.Errorf("OpenFile('missing'): expected an error")
+    }
+}
@@ -3,8 +3,10 @@
 package common
 
 import (
-    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    "io"
+    "io/fs"
     "os"
+    "path"
     "path/filepath"
 )
 
@@ -31,55 +33,65 @@ func ListToAbsolutePath(list []string, dirs ...string) ([]string, error) {
     return list, nil
 }
 
-// Open the requested file and retrieve its content type, based on the
-// file's extension. If the extension can't be deduced, it defaults to
-// "text/plain".
+// Open the requested file and retrieve its content type, using the
+// default `MimeRegistry`.
 func OpenFile(dir, filePath string) (*os.File, string, error) {
+    return DefaultMimeRegistry.OpenFile(dir, filePath)
+}
+
+// Open the requested file from the file system `fsys` (e.g., an
+// `embed.FS`) and retrieve its content type, just like `OpenFile`.
+// `filePath` must be a slash-separated path, relative to the root of
+// `fsys`.
+func OpenFileFS(fsys fs.FS, filePath string) (fs.File, string, error) {
+    return DefaultMimeRegistry.OpenFileFS(fsys, filePath)
+}
+
+// Open the requested file and retrieve its content type.
+func (m *MimeRegistry) OpenFile(dir, filePath string) (*os.File, string, error) {
     filePath = filepath.Join(dir, filePath)
 
     file, err := os.Open(filePath)
     if err != nil {
-        // Don't do any further post-processing
-        err = newError(err, ErrOpenFile)
-    } else if fi, err := file.Stat(); err != nil {
-        err = newError(err, ErrStatFile)
+        return nil, "", newError(err, ErrOpenFile)
+    }
+
+    fi, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return nil, "", newError(err, ErrStatFile)
     } else if fi.IsDir() {
-        err = newError(err, ErrDir)
+        file.Close()
+        return nil, "", newError(nil, ErrDir)
     }
 
+    return file, m.ContentType(filePath, file), nil
+}
+
+// Open the requested file from the file system `fsys` and retrieve its
+// content type.
+func (m *MimeRegistry) OpenFileFS(fsys fs.FS, filePath string) (fs.File, string, error) {
+    filePath = path.Clean(filePath)
+    if !fs.ValidPath(filePath) {
+        return nil, "", newError(fs.ErrInvalid, ErrOpenFile)
+    }
+
+    file, err := fsys.Open(filePath)
     if err != nil {
-        if we, ok := err.(wrappedErr); ok && we.err != ErrOpenFile {
-            file.Close()
-        }
-        return nil, "", err
+        return nil, "", newError(err, ErrOpenFile)
     }
 
-    var ctype string
-    switch ext := filepath.Ext(filePath); ext {
-    case ".json":
-        ctype = "application/json"
-    case ".css":
-        ctype = "text/css"
-    case ".html":
-        ctype = "text/html"
-    case ".js":
-        ctype = "text/javascript"
-    case ".bmp":
-        ctype = "image/bmp"
-    case ".gif":
-        ctype = "image/gif"
-    case ".ico":
-        ctype = "image/x-icon"
-    case ".jpeg", ".jpg":
-        ctype = "image/jpeg"
-    case ".png":
-        ctype = "image/png"
-    case ".svg":
-        ctype = "image/svg+xml"
-    default:
-        logger.Warnf("common: Unknown file extension: %+s", ext)
-        ctype = "text/plain"
+    if fi, err := file.Stat(); err != nil {
+        file.Close()
+        return nil, "", newError(err, ErrStatFile)
+    } else if fi.IsDir() {
+        file.Close()
+        return nil, "", newError(nil, ErrDir)
     }
 
-    return file, ctype, nil
+    var content io.ReadSeeker
+    if rs, ok := file.(io.ReadSeeker); ok {
+        content = rs
+    }
+    return file, m.ContentType(filePath, content), nil
 }
@@ -14,6 +14,14 @@
 //
 // This base endpoint may be left unconfigured, in which case this module
 // will only trigger the registered key events.
+//
+// Since '/data' only holds the latest state, keys pressed and released
+// between two checks by a client are never seen by it. So every key event
+// may also be published, in order, to a `/ram_store/_pubsub`-like topic
+// (for example, '/ram_store/_pubsub/keyboard'), as a 'application/json'
+// encoded object:
+//
+//     {"Key": "A", "Pressed": true}
 
 package key_events
 
@@ -39,6 +47,8 @@ type WatcherConfig struct {
 	PoolPerSec int
 	// Base URL where a ram_store service is running.
 	BaseStoreEndpoint string
+	// URL of the topic where every key event is published, if any.
+	EventsEndpoint string
 	// URL where the current data is stored.
 	dataStoreEndpoint string
 	// URL where the mapping between indexes and keys is stored.
@@ -68,6 +78,14 @@ type Watcher interface {
 	io.Closer
 }
 
+// A key event, as published to the events topic.
+type keyEvent struct {
+	// Name of the key.
+	Key string
+	// Whether the key was pressed or released.
+	Pressed bool
+}
+
 // doForEachKey execute the callback for every valid key.
 func doForEachKey(callback func (key_logger.Key, string)) {
 	for key := key_logger.Key(0); key < key_logger.KeyCount; key++ {
@@ -105,6 +123,7 @@ func NewEventWatcher(config WatcherConfig) Watcher {
 	}
 
 	newConfig := WatcherConfig {
+		EventsEndpoint: config.EventsEndpoint,
 		OnKeyPress: make(map[key_logger.Key]Action),
 		OnKeyRelease: make(map[key_logger.Key]Action),
 	}
@@ -161,11 +180,31 @@ func (w *watcher) send(data []byte, url string) {
 	resp, err := w.httpClient.Post(url, "application/json", buf)
 	if err != nil {
 		logger.Errorf("key_events: Failed to send the keys to %s: %+v", err, url)
-	} else if code := resp.StatusCode; code != http.StatusOK && code != http.StatusNoContent {
+		return
+	}
+	resp.Body.Close()
+	if code := resp.StatusCode; code != http.StatusOK && code != http.StatusNoContent {
 		logger.Errorf("key_events: Failed to send the keys to %s: %+s", resp.Status, url)
 	}
 }
 
+// publish a key event to the events topic, if configured.
+func (w *watcher) publish(key key_logger.Key, pressed bool) {
+	if len(w.config.EventsEndpoint) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(keyEvent {
+		Key: key.String(),
+		Pressed: pressed,
+	})
+	if err != nil {
+		logger.Errorf("key_events: Failed to encode the key event as JSON: %+v", err)
+		return
+	}
+	w.send(data, w.config.EventsEndpoint)
+}
+
 // run the key logger, executing triggers and reporting to the configure
 // ram_store.
 func (w *watcher) run() {
@@ -223,6 +262,7 @@ from_inner:
 				if ok {
 					act.Execute(pressed)
 				}
+				w.publish(key[i], pressed)
 			}
 		}
 
@@ -0,0 +1,298 @@
+// obs_text writes the state of a run (or of the timer) to text files, so
+// it may be displayed by OBS' "Text (read from file)" sources, which are
+// lighter than browser sources. Just like `key_events`, this module must
+// run locally, and it retrieves the state from the server through HTTP.
+//
+// Each file is described by a format, written as a `text/template` (see
+// https://golang.org/pkg/text/template/), which may use the functions
+// available to `web/tmpl`'s templates (e.g., `duration` and `delta`). For
+// example:
+//
+//     {{ duration .Time }}
+//     {{ .Split }} ({{ if .HasDelta }}{{ delta .Delta }}{{ else }}-{{ end }})
+//     PB: {{ duration .PB }} | Attempts: {{ .Attempts }}
+//
+// The format is executed with a `State`. If a run's token is configured,
+// the state is retrieved from `/run`. Otherwise, only the time is
+// retrieved from `/timer`.
+//
+// Files are only written when their content changes, and they are written
+// atomically (with `common.AtomicSaveFile`), so OBS never reads a
+// partially written file.
+
+package obs_text
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "github.com/SirGFM/gfm-speedrun-overlay/common"
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    "github.com/SirGFM/gfm-speedrun-overlay/web/tmpl"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "text/template"
+    "time"
+)
+
+// Default interval between updates of the files.
+const DefaultInterval = 100 * time.Millisecond
+
+// Interval before retrying to retrieve the state after a failure (e.g., if
+// the run wasn't created yet), so the server isn't flooded with errors.
+const retryInterval = 5 * time.Second
+
+// State of the run, as supplied to the formats.
+type State struct {
+    // The current time.
+    Time time.Duration
+    // Name of the game/category. Empty if tracking the timer.
+    Name string
+    // Name of the current split. Empty if the run has finished or if
+    // tracking the timer.
+    Split string
+    // Difference between the last finished split and the personal best.
+    Delta time.Duration
+    // Whether `Delta` is valid (i.e., a split was finished and there's a
+    // personal best to compare it against).
+    HasDelta bool
+    // Final time of the personal best, or zero if there's none.
+    PB time.Duration
+    // How many times a run of the game/category was started.
+    Attempts int
+}
+
+// A file written by the `Exporter`.
+type File struct {
+    // Path to the file.
+    Path string
+    // Format of the file's content, as a `text/template`.
+    Format string
+}
+
+// Configures the `Exporter`.
+type Config struct {
+    // Base URL where the server is running (e.g., "http://localhost:8080").
+    BaseURL string
+    // Token of the run tracked by `/run` (or `run.LatestToken`, for the
+    // most recently created run). If empty, the time is retrieved from
+    // `/timer`.
+    Token string
+    // Interval between updates of the files. Defaults to
+    // `DefaultInterval`.
+    Interval time.Duration
+    // Every file written by the `Exporter`.
+    Files []File
+}
+
+// A file, with its parsed format.
+type output struct {
+    // Path to the file.
+    path string
+    // The file's format.
+    format *template.Template
+    // Last content written to the file.
+    last []byte
+}
+
+// Periodically writes the state to every configured file.
+type exporter struct {
+    // The exporter's configuration.
+    config Config
+    // Every file written by the exporter.
+    outputs []*output
+    // Client used to communicate with the server.
+    httpClient http.Client
+    // Closed to stop the exporter.
+    stop chan struct{}
+    // Closed once the exporter has stopped.
+    done chan struct{}
+}
+
+// Exporter interface.
+type Exporter interface {
+    io.Closer
+}
+
+// A split, as reported by `/run/splits/<token>`.
+type runSplit struct {
+    Name string
+    EndTime int64
+    Skipped bool
+}
+
+// A run, as reported by `/run/splits/<token>`.
+type runSplits struct {
+    Name string
+    Splits []runSplit
+    Best []runSplit
+    Current int
+    Attempts int
+}
+
+// A time, as reported by both `/run/timer/<token>` and `/timer`.
+type timeResponse struct {
+    Time int64
+}
+
+// Retrieve and decode a JSON object from the server.
+func (e *exporter) getJSON(url string, v interface{}) error {
+    resp, err := e.httpClient.Get(url)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("GET %s: %s", url, resp.Status)
+    }
+    return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Retrieve the current state from the server.
+func (e *exporter) getState() (State, error) {
+    var state State
+    var t timeResponse
+
+    if len(e.config.Token) == 0 {
+        err := e.getJSON(e.config.BaseURL + "/timer", &t)
+        state.Time = time.Duration(t.Time) * time.Millisecond
+        return state, err
+    }
+
+    var sp runSplits
+    base := e.config.BaseURL + "/run/"
+    err := e.getJSON(base + "splits/" + e.config.Token, &sp)
+    if err != nil {
+        return state, err
+    }
+    err = e.getJSON(base + "timer/" + e.config.Token, &t)
+    if err != nil {
+        return state, err
+    }
+
+    state.Time = time.Duration(t.Time) * time.Millisecond
+    state.Name = sp.Name
+    state.Attempts = sp.Attempts
+    if sp.Current < len(sp.Splits) {
+        state.Split = sp.Splits[sp.Current].Name
+    }
+    if n := len(sp.Best); n > 0 {
+        state.PB = time.Duration(sp.Best[n - 1].EndTime) * time.Millisecond
+    }
+
+    // Compare the last finished split against the personal best
+    for i := sp.Current - 1; i >= 0 && i < len(sp.Splits) && i < len(sp.Best); i-- {
+        cur, best := sp.Splits[i], sp.Best[i]
+        if cur.Skipped {
+            continue
+        } else if !best.Skipped && best.EndTime > 0 {
+            state.Delta = time.Duration(cur.EndTime - best.EndTime) * time.Millisecond
+            state.HasDelta = true
+        }
+        break
+    }
+
+    return state, nil
+}
+
+// Write the state to every file whose content changed. Returns `false` if
+// the state couldn't be retrieved.
+func (e *exporter) update() bool {
+    state, err := e.getState()
+    if err != nil {
+        logger.Debugf("obs_text: Failed to retrieve the state: %+v", err)
+        return false
+    }
+
+    for _, out := range e.outputs {
+        var buf bytes.Buffer
+
+        err := out.format.Execute(&buf, &state)
+        if err != nil {
+            logger.Errorf("obs_text: Failed to format '%s': %+v", out.path, err)
+            continue
+        } else if out.last != nil && bytes.Equal(out.last, buf.Bytes()) {
+            continue
+        }
+
+        writefn := func(w io.Writer) error {
+            _, err := w.Write(buf.Bytes())
+            return err
+        }
+        err = common.AtomicSaveFile(filepath.Dir(out.path), out.path, writefn)
+        if err != nil {
+            logger.Errorf("obs_text: Failed to write '%s': %+v", out.path, err)
+            continue
+        }
+        out.last = buf.Bytes()
+    }
+
+    return true
+}
+
+// Periodically update the files, until the exporter is closed.
+func (e *exporter) run() {
+    ticker := time.NewTicker(e.config.Interval)
+    defer ticker.Stop()
+    defer close(e.done)
+
+    for {
+        var wait <-chan time.Time = ticker.C
+        if !e.update() {
+            wait = time.After(retryInterval)
+        }
+
+        select {
+        case <-e.stop:
+            return
+        case <-wait:
+        }
+    }
+}
+
+// Stop updating the files.
+func (e *exporter) Close() error {
+    close(e.stop)
+    <-e.done
+    return nil
+}
+
+// NewExporter parses every file's format and starts updating them.
+func NewExporter(config Config) (Exporter, error) {
+    if config.Interval <= 0 {
+        config.Interval = DefaultInterval
+    }
+
+    e := &exporter {
+        config: config,
+        httpClient: http.Client {
+            Timeout: time.Second,
+        },
+        stop: make(chan struct{}),
+        done: make(chan struct{}),
+    }
+
+    funcs := template.FuncMap(tmpl.Funcs())
+    for _, f := range config.Files {
+        format, err := template.New(f.Path).Funcs(funcs).Parse(f.Format)
+        if err != nil {
+            return nil, fmt.Errorf("obs_text: invalid format for '%s': %w", f.Path, err)
+        }
+
+        err = os.MkdirAll(filepath.Dir(f.Path), 0755)
+        if err != nil {
+            return nil, fmt.Errorf("obs_text: couldn't create the directory for '%s': %w", f.Path, err)
+        }
+
+        e.outputs = append(e.outputs, &output {
+            path: f.Path,
+            format: format,
+        })
+    }
+
+    go e.run()
+    return e, nil
+}
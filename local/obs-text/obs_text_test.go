@@ -0,0 +1,181 @@
+package obs_text
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// Serve `sp` from `/run/splits/tok` and `ms` from both `/run/timer/tok`
+// and `/timer`.
+func newTestServer(t *testing.T, sp *runSplits, ms *int64) *httptest.Server {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/run/splits/tok", func(w http.ResponseWriter, req *http.Request) {
+        json.NewEncoder(w).Encode(sp)
+    })
+    timer := func(w http.ResponseWriter, req *http.Request) {
+        json.NewEncoder(w).Encode(&timeResponse { Time: *ms })
+    }
+    mux.HandleFunc("/run/timer/tok", timer)
+    mux.HandleFunc("/timer", timer)
+
+    srv := httptest.NewServer(mux)
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+// Create a stopped exporter, so it's only updated by the test.
+func newTestExporter(t *testing.T, config Config) *exporter {
+    e, err := NewExporter(config)
+    if err != nil {
+        t.Fatalf("Failed to create the exporter: %+v", err)
+    }
+    e.Close()
+    return e.(*exporter)
+}
+
+func TestGetState(t *testing.T) {
+    best := []runSplit {
+        { "A", 1000, false },
+        { "B", 2500, true },
+        { "C", 4000, false },
+    }
+    sp := &runSplits {
+        Name: "Game",
+        Best: best,
+        Attempts: 3,
+    }
+    ms := int64(1234)
+    srv := newTestServer(t, sp, &ms)
+    e := newTestExporter(t, Config {
+        BaseURL: srv.URL,
+        Token: "tok",
+        Interval: time.Hour,
+    })
+
+    for _, tc := range []struct {
+        desc string
+        splits []runSplit
+        best []runSplit
+        current int
+        exp State
+    } {
+        {
+            "Not started",
+            []runSplit { { "A", 0, false }, { "B", 0, false }, { "C", 0, false } },
+            best,
+            0,
+            State { Split: "A", PB: 4 * time.Second },
+        },
+        {
+            "Ahead",
+            []runSplit { { "A", 900, false }, { "B", 0, false }, { "C", 0, false } },
+            best,
+            1,
+            State { Split: "B", Delta: -100 * time.Millisecond, HasDelta: true, PB: 4 * time.Second },
+        },
+        {
+            "Skipped split",
+            []runSplit { { "A", 1200, false }, { "B", 0, true }, { "C", 0, false } },
+            best,
+            2,
+            State { Split: "C", Delta: 200 * time.Millisecond, HasDelta: true, PB: 4 * time.Second },
+        },
+        {
+            "Skipped in the PB",
+            []runSplit { { "A", 1200, false }, { "B", 2000, false }, { "C", 0, false } },
+            best,
+            2,
+            State { Split: "C", PB: 4 * time.Second },
+        },
+        {
+            "Finished",
+            []runSplit { { "A", 1200, false }, { "B", 2000, false }, { "C", 4500, false } },
+            best,
+            3,
+            State { Delta: 500 * time.Millisecond, HasDelta: true, PB: 4 * time.Second },
+        },
+        {
+            "No PB",
+            []runSplit { { "A", 1200, false }, { "B", 0, false } },
+            nil,
+            1,
+            State { Split: "B" },
+        },
+    } {
+        sp.Splits = tc.splits
+        sp.Best = tc.best
+        sp.Current = tc.current
+        tc.exp.Time = 1234 * time.Millisecond
+        tc.exp.Name = "Game"
+        tc.exp.Attempts = 3
+
+        got, err := e.getState()
+        if err != nil {
+            t.Errorf("%s: failed to retrieve the state: %+v", tc.desc, err)
+        } else if got != tc.exp {
+            t.Errorf("%s: expected %+v, got %+v", tc.desc, tc.exp, got)
+        }
+    }
+
+    // Without a token, only the timer is retrieved
+    e.config.Token = ""
+    if got, err := e.getState(); err != nil || got != (State { Time: 1234 * time.Millisecond }) {
+        t.Errorf("Timer: expected only the time, got %+v (%+v)", got, err)
+    }
+
+    e.config.Token = "missing"
+    if _, err := e.getState(); err == nil {
+        t.Errorf("Missing token: expected an error")
+    }
+}
+
+func TestUpdate(t *testing.T) {
+    ms := int64(61000)
+    srv := newTestServer(t, &runSplits{}, &ms)
+    filename := filepath.Join(t.TempDir(), "obs", "timer.txt")
+    e := newTestExporter(t, Config {
+        BaseURL: srv.URL,
+        Interval: time.Hour,
+        Files: []File {
+            { filename, "{{ .Time.Seconds }}s" },
+        },
+    })
+
+    expectFile := func(desc, exp string) {
+        t.Helper()
+        if data, err := os.ReadFile(filename); err != nil || string(data) != exp {
+            t.Errorf("%s: expected '%s', got '%s' (%+v)", desc, exp, data, err)
+        }
+    }
+
+    if !e.update() {
+        t.Fatalf("Failed to update the files")
+    }
+    expectFile("First update", "61s")
+
+    // Files are only written if their content changes
+    os.WriteFile(filename, []byte("edited"), 0644)
+    e.update()
+    expectFile("Same content", "edited")
+    ms = 62000
+    e.update()
+    expectFile("New content", "62s")
+
+    srv.Close()
+    if e.update() {
+        t.Errorf("Expected the update to fail without a server")
+    }
+    expectFile("Failed update", "62s")
+
+    _, err := NewExporter(Config {
+        Files: []File { { filename, "{{ .Time" } },
+    })
+    if err == nil {
+        t.Errorf("Expected an invalid format to be rejected")
+    }
+}
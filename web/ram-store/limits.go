@@ -0,0 +1,130 @@
+// Limits on the stored resources:
+//
+//   * Resources stored with a `X-TTL` header (e.g., `X-TTL: 30s`, or simply
+//     `X-TTL: 30` for seconds) are deleted once that much time has passed
+//     since they were last modified. Storing the resource again without the
+//     header makes it permanent;
+//   * If `Config.MaxBytes` is set, the total size of the stored resources
+//     and of the messages retained by every topic is limited, and requests
+//     that would exceed it fail with 413 (Request Entity Too Large).
+//     Snapshots are trimmed to the limit when restored (see
+//     `snapshot.go`).
+
+package ram_store
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+    "sync/atomic"
+    "time"
+)
+
+// Header with the time-to-live of a resource.
+const ttlHeader = "X-TTL"
+
+// Interval between checks for expired resources.
+const expiryInterval = time.Second
+
+// Parse either a duration (e.g., "30s") or a number of seconds.
+func parseDuration(value string) (time.Duration, error) {
+    d, err := time.ParseDuration(value)
+    if err == nil {
+        return d, nil
+    }
+
+    secs, err2 := strconv.ParseUint(value, 10, 32)
+    if err2 != nil {
+        return 0, err
+    }
+    return time.Duration(secs) * time.Second, nil
+}
+
+// Check whether the resource has expired by `now`.
+func (d *data) expired(now time.Time) bool {
+    return !d.expires.IsZero() && !now.Before(d.expires)
+}
+
+// Retrieve the time-to-live of the received resource, from its `X-TTL`
+// header. Returns 0 if the resource doesn't expire.
+func (r *request) ttl() (time.Duration, error) {
+    value := r.req.Header.Get(ttlHeader)
+    if len(value) == 0 {
+        return 0, nil
+    }
+
+    ttl, err := parseDuration(value)
+    if err != nil || ttl <= 0 {
+        reason := "Invalid '" + ttlHeader + "': must be a positive duration (e.g., '30s') or a number of seconds"
+        return 0, newError(err, reason, http.StatusBadRequest)
+    }
+    return ttl, nil
+}
+
+// Check whether replacing the resource `key` with `size` bytes would
+// exceed `Config.MaxBytes`. Must be called with the lock held.
+func (ctx *rstore) unsafeCheckSize(key string, size int64) error {
+    if ctx.maxBytes <= 0 {
+        return nil
+    }
+
+    used := ctx.size + atomic.LoadInt64(&ctx.topicBytes)
+    total := used + size
+    if old, ok := ctx.store[key]; ok {
+        total -= int64(old.body.Len())
+    }
+    if total > ctx.maxBytes {
+        reason := fmt.Sprintf("Storing the resource would exceed the limit of %d bytes (%d bytes are in use)", ctx.maxBytes, used)
+        return newCodedError(nil, CodeTooLarge, reason, http.StatusRequestEntityTooLarge)
+    }
+    return nil
+}
+
+// Check whether publishing a message with `size` bytes to the topic `name`
+// would exceed `Config.MaxBytes`, taking into account the message dropped
+// to make room for it. Must be called with `pubMut` held.
+func (ctx *rstore) unsafeCheckTopicSize(name string, size int64) error {
+    if ctx.maxBytes <= 0 {
+        return nil
+    }
+
+    ctx.rwmut.RLock()
+    used := ctx.size + atomic.LoadInt64(&ctx.topicBytes)
+    ctx.rwmut.RUnlock()
+
+    total := used + size
+    if t, ok := ctx.topics[name]; ok && len(t.messages) >= ctx.retention {
+        total -= int64(len(t.messages[0].Body))
+    }
+    if total > ctx.maxBytes {
+        reason := fmt.Sprintf("Publishing the message would exceed the limit of %d bytes (%d bytes are in use)", ctx.maxBytes, used)
+        return newCodedError(nil, CodeTooLarge, reason, http.StatusRequestEntityTooLarge)
+    }
+    return nil
+}
+
+// Periodically delete expired resources (and forget unused cursors), until
+// the handler is closed.
+func (ctx *rstore) runExpiry() {
+    defer ctx.wg.Done()
+
+    ticker := time.NewTicker(expiryInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.stop:
+            return
+        case now := <-ticker.C:
+            ctx.rwmut.Lock()
+            for key, d := range ctx.store {
+                if d.expired(now) {
+                    ctx.unsafeSet(key, nil)
+                }
+            }
+            ctx.rwmut.Unlock()
+
+            ctx.pruneCursors(now)
+        }
+    }
+}
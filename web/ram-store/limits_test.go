@@ -0,0 +1,88 @@
+package ram_store
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestExpiredResource(t *testing.T) {
+    ctx := newTestStore(t, Config{})
+
+    w := serve(ctx, http.MethodPut, "/ram_store/a", "1", "Content-Type", "text/plain", ttlHeader, "20ms")
+    expectStatus(t, "PUT", w, http.StatusNoContent)
+    w = serve(ctx, http.MethodGet, "/ram_store/a", "")
+    expectStatus(t, "GET (before expiring)", w, http.StatusOK)
+
+    // The resource is hidden as soon as it expires, even if it wasn't
+    // deleted yet
+    time.Sleep(30 * time.Millisecond)
+    w = serve(ctx, http.MethodGet, "/ram_store/a", "")
+    expectStatus(t, "GET (expired)", w, http.StatusNotFound)
+    w = serve(ctx, http.MethodDelete, "/ram_store/a", "")
+    expectStatus(t, "DELETE (expired)", w, http.StatusNotFound)
+
+    w = serve(ctx, http.MethodPut, "/ram_store/a", "1", "Content-Type", "text/plain", ttlHeader, "-1s")
+    expectStatus(t, "PUT (invalid TTL)", w, http.StatusBadRequest)
+}
+
+func TestMaxBytes(t *testing.T) {
+    ctx := newTestStore(t, Config {
+        MaxBytes: 8,
+    })
+
+    w := serve(ctx, http.MethodPut, "/ram_store/a", "12345", "Content-Type", "text/plain")
+    expectStatus(t, "PUT a", w, http.StatusNoContent)
+    w = serve(ctx, http.MethodPut, "/ram_store/b", "1234", "Content-Type", "text/plain")
+    expectStatus(t, "PUT b (over the limit)", w, http.StatusRequestEntityTooLarge)
+    w = serve(ctx, http.MethodPut, "/ram_store/b", "123", "Content-Type", "text/plain")
+    expectStatus(t, "PUT b (within the limit)", w, http.StatusNoContent)
+
+    // Replacing a resource only counts its new size
+    w = serve(ctx, http.MethodPut, "/ram_store/a", "1", "Content-Type", "text/plain")
+    expectStatus(t, "PUT a (smaller)", w, http.StatusNoContent)
+    w = serve(ctx, http.MethodPut, "/ram_store/c", "1234", "Content-Type", "text/plain")
+    expectStatus(t, "PUT c (freed space)", w, http.StatusNoContent)
+    w = serve(ctx, http.MethodPut, "/ram_store/d", "123456789", "Content-Type", "text/plain")
+    expectStatus(t, "PUT d (larger than the limit)", w, http.StatusRequestEntityTooLarge)
+}
+
+func TestDeletePrefix(t *testing.T) {
+    ctx := newTestStore(t, Config{})
+
+    for _, key := range []string { "kb", "kb/a", "kb/a/b", "kb-old", "other" } {
+        serve(ctx, http.MethodPut, "/ram_store/" + key, "1", "Content-Type", "text/plain")
+    }
+
+    w := serve(ctx, http.MethodDelete, "/ram_store/", "")
+    expectStatus(t, "DELETE (no prefix)", w, http.StatusBadRequest)
+
+    w = serve(ctx, http.MethodDelete, "/ram_store/?prefix=kb", "")
+    expectStatus(t, "DELETE kb", w, http.StatusOK)
+    var res deleteResult
+    err := json.NewDecoder(w.Body).Decode(&res)
+    if err != nil {
+        t.Fatalf("DELETE kb: failed to decode the response: %+v", err)
+    } else if res.Deleted != 3 {
+        t.Errorf("DELETE kb: expected 3 deleted resources, got %d", res.Deleted)
+    }
+
+    var list []entryInfo
+    w = serve(ctx, http.MethodGet, "/ram_store/", "")
+    err = json.NewDecoder(w.Body).Decode(&list)
+    if err != nil {
+        t.Fatalf("GET: failed to decode the list: %+v", err)
+    } else if len(list) != 2 || list[0].Path != "/ram_store/kb-old" || list[1].Path != "/ram_store/other" {
+        t.Errorf("GET: expected only kb-old and other, got %+v", list)
+    }
+
+    w = serve(ctx, http.MethodDelete, "/ram_store/?prefix=", "")
+    expectStatus(t, "DELETE (every resource)", w, http.StatusOK)
+    ctx.rwmut.RLock()
+    n := len(ctx.store)
+    ctx.rwmut.RUnlock()
+    if n != 0 {
+        t.Errorf("DELETE (every resource): %d resources remain", n)
+    }
+}
@@ -0,0 +1,146 @@
+// Handling of every resource within a path (a prefix), instead of a single
+// resource:
+//
+//   * GET `/ram_store/?prefix=<prefix>`: List every resource within
+//     `<prefix>` (or every resource, if it's omitted), sorted by path;
+//   * DELETE `/ram_store/?prefix=<prefix>`: Delete every resource within
+//     `<prefix>`. The prefix is required, but it may be empty to delete
+//     every resource.
+//
+// Prefixes match whole path components, so `?prefix=keyboard` matches both
+// `/ram_store/keyboard` and `/ram_store/keyboard/data`, but not
+// `/ram_store/keyboard-old`. Resources are listed as:
+//
+//     [
+//         {
+//             "Path": "/ram_store/keyboard/data",
+//             "ContentType": "application/json",
+//             "Size": 42,
+//             "Modified": "2021-06-12T15:04:05.999Z",
+//             "Version": 13,
+//             "Expires": "2021-06-12T15:04:35.999Z"
+//         }
+//     ]
+//
+// `Expires` is omitted for resources that don't expire. Deleting replies
+// with how many resources were deleted (e.g., `{"Deleted": 3}`).
+
+package ram_store
+
+import (
+    "encoding/json"
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    "net/http"
+    "path"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Information about a stored resource.
+type entryInfo struct {
+    // The resource's URL path (e.g., "/ram_store/keyboard/data").
+    Path string
+    // The resource's content type.
+    ContentType string
+    // The resource's size, in bytes.
+    Size int
+    // When the resource was last modified.
+    Modified time.Time
+    // The resource's version, sent in its `ETag`.
+    Version uint64
+    // When the resource expires, if it has a time-to-live.
+    Expires *time.Time `json:",omitempty"`
+}
+
+// Result of deleting every resource within a prefix.
+type deleteResult struct {
+    // How many resources were deleted.
+    Deleted int
+}
+
+// Check whether the resource `key` is within `prefix` (or is `prefix`
+// itself).
+func inPrefix(key, prefix string) bool {
+    return key == prefix || strings.HasPrefix(key, prefix + "/")
+}
+
+// Retrieve the prefix requested by the `prefix` query parameter, as stored
+// in `rstore.store`. `ok` is false if the parameter is missing.
+func (r *request) prefix() (prefix string, ok bool) {
+    values, ok := r.req.URL.Query()["prefix"]
+    if !ok || len(values) == 0 {
+        return r.urlPath[0], false
+    }
+    return path.Join(r.urlPath[0], strings.Trim(values[0], "/")), true
+}
+
+// Send a JSON-encoded response.
+func (r *request) sendJSON(v interface{}) error {
+    r.w.Header().Set("Content-Type", "application/json")
+    r.w.WriteHeader(http.StatusOK)
+    err := json.NewEncoder(r.w).Encode(v)
+    if err != nil {
+        logger.Errorf("web%s: Failed to encode the response: %+v (payload: %+v)", Prefix, err, v)
+    }
+    return nil
+}
+
+// List every resource within the requested prefix.
+func (r *request) list() error {
+    prefix, _ := r.prefix()
+    now := time.Now()
+
+    r.ctx.rwmut.RLock()
+    list := []entryInfo{}
+    for key, d := range r.ctx.store {
+        if !inPrefix(key, prefix) || d.expired(now) {
+            continue
+        }
+
+        info := entryInfo {
+            Path: "/" + key,
+            ContentType: d.contentType,
+            Size: d.body.Len(),
+            Modified: d.modified,
+            Version: d.version,
+        }
+        if !d.expires.IsZero() {
+            expires := d.expires
+            info.Expires = &expires
+        }
+        list = append(list, info)
+    }
+    r.ctx.rwmut.RUnlock()
+
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].Path < list[j].Path
+    })
+    return r.sendJSON(list)
+}
+
+// Delete every resource within the requested prefix.
+func (r *request) delPrefix() error {
+    prefix, ok := r.prefix()
+    if !ok {
+        reason := "Deleting resources requires a 'prefix' (which may be empty, to delete every resource)"
+        return newError(nil, reason, http.StatusBadRequest)
+    }
+
+    var res deleteResult
+    now := time.Now()
+
+    r.ctx.rwmut.Lock()
+    for key, d := range r.ctx.store {
+        if !inPrefix(key, prefix) {
+            continue
+        } else if !d.expired(now) {
+            // Expired resources are deleted, but they weren't visible
+            res.Deleted++
+        }
+        r.ctx.unsafeSet(key, nil)
+    }
+    r.ctx.rwmut.Unlock()
+
+    return r.sendJSON(&res)
+}
@@ -0,0 +1,477 @@
+// Partial updates of JSON resources, so clients don't have to send (nor
+// race with each other over) the entire document to modify a single field.
+// A PATCH is handled based on its `Content-Type`:
+//
+//   * `application/merge-patch+json`: a JSON Merge Patch (RFC 7386), which
+//     is merged into the stored document. Fields set to `null` are removed;
+//   * `application/json-patch+json`: a JSON Patch (RFC 6902), a list of
+//     operations ("add", "remove", "replace", "move", "copy" and "test")
+//     applied in order. If any operation fails, the resource isn't
+//     modified.
+//
+// Patching a resource that doesn't exist patches a `null` document. The
+// resource keeps its content type and its expiration, unless a new `X-TTL`
+// is sent.
+//
+// To avoid overwriting someone else's changes, the request may send the
+// `ETag` of the version it expects in a `If-Match` header. If the resource
+// was modified since then, the request fails with 412 (Precondition
+// Failed). `If-Match` is also accepted by PUT, POST and DELETE. For
+// example:
+//
+//     PATCH /ram_store/config
+//     Content-Type: application/merge-patch+json
+//     If-Match: "kq3v1x2a-12"
+//
+//     {"run-token": "abc", "layout": null}
+
+package ram_store
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "mime"
+    "net/http"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Content type of JSON Merge Patches.
+const mergePatchType = "application/merge-patch+json"
+
+// Content type of JSON Patches.
+const jsonPatchType = "application/json-patch+json"
+
+// An operation of a JSON Patch.
+type patchOp struct {
+    // The operation: "add", "remove", "replace", "move", "copy" or "test".
+    Op string `json:"op"`
+    // JSON Pointer to where the operation is applied.
+    Path string `json:"path"`
+    // JSON Pointer to the source of "move" and "copy".
+    From string `json:"from"`
+    // Value used by "add", "replace" and "test".
+    Value json.RawMessage `json:"value"`
+
+    // The parsed `Path`.
+    path []string
+    // The parsed `From`.
+    from []string
+    // The decoded `Value`.
+    value interface{}
+}
+
+// Parse a JSON Pointer (RFC 6901) into its reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+    if len(pointer) == 0 {
+        return nil, nil
+    } else if pointer[0] != '/' {
+        return nil, fmt.Errorf("pointer '%s' must start with '/'", pointer)
+    }
+
+    tokens := strings.Split(pointer[1:], "/")
+    for i, t := range tokens {
+        t = strings.ReplaceAll(t, "~1", "/")
+        tokens[i] = strings.ReplaceAll(t, "~0", "~")
+    }
+    return tokens, nil
+}
+
+// Parse and check every operation of a JSON Patch.
+func parseJSONPatch(content []byte) ([]patchOp, error) {
+    var ops []patchOp
+
+    err := json.Unmarshal(content, &ops)
+    if err != nil {
+        return nil, err
+    }
+
+    for i := range ops {
+        op := &ops[i]
+
+        switch op.Op {
+        case "add", "replace", "test":
+            if len(op.Value) == 0 {
+                return nil, fmt.Errorf("operation %d ('%s') requires a 'value'", i, op.Op)
+            }
+            err = json.Unmarshal(op.Value, &op.value)
+            if err != nil {
+                return nil, err
+            }
+        case "move", "copy":
+            op.from, err = parsePointer(op.From)
+            if err != nil {
+                return nil, fmt.Errorf("operation %d ('%s'): %w", i, op.Op, err)
+            }
+        case "remove":
+        default:
+            return nil, fmt.Errorf("operation %d: unknown operation '%s'", i, op.Op)
+        }
+
+        op.path, err = parsePointer(op.Path)
+        if err != nil {
+            return nil, fmt.Errorf("operation %d ('%s'): %w", i, op.Op, err)
+        }
+    }
+
+    return ops, nil
+}
+
+// Parse the index `token` of an array of length `length`. If `appending`,
+// the index may be `length` (or "-", which is the same).
+func arrayIndex(token string, length int, appending bool) (int, error) {
+    if appending && token == "-" {
+        return length, nil
+    } else if len(token) == 0 || (len(token) > 1 && token[0] == '0') {
+        return 0, fmt.Errorf("invalid array index '%s'", token)
+    }
+
+    idx, err := strconv.Atoi(token)
+    if err != nil || idx < 0 {
+        return 0, fmt.Errorf("invalid array index '%s'", token)
+    } else if idx > length || (idx == length && !appending) {
+        return 0, fmt.Errorf("array index '%s' is out of bounds", token)
+    }
+    return idx, nil
+}
+
+// Retrieve the value referenced by `tokens` within `doc`.
+func getPointer(doc interface{}, tokens []string) (interface{}, error) {
+    for _, t := range tokens {
+        switch v := doc.(type) {
+        case map[string]interface{}:
+            child, ok := v[t]
+            if !ok {
+                return nil, fmt.Errorf("member '%s' doesn't exist", t)
+            }
+            doc = child
+        case []interface{}:
+            idx, err := arrayIndex(t, len(v), false)
+            if err != nil {
+                return nil, err
+            }
+            doc = v[idx]
+        default:
+            return nil, fmt.Errorf("'%s' isn't within an object or array", t)
+        }
+    }
+    return doc, nil
+}
+
+// Modify the container (object or array) that holds the last token of
+// `tokens`, replacing it by whatever is returned by `fn`. Returns the
+// modified document.
+func updatePointer(doc interface{}, tokens []string, fn func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+    if len(tokens) == 1 {
+        return fn(doc, tokens[0])
+    }
+
+    child, err := getPointer(doc, tokens[:1])
+    if err != nil {
+        return nil, err
+    }
+    child, err = updatePointer(child, tokens[1:], fn)
+    if err != nil {
+        return nil, err
+    }
+
+    // Slices may be reallocated, so the child must be stored back
+    switch v := doc.(type) {
+    case map[string]interface{}:
+        v[tokens[0]] = child
+    case []interface{}:
+        idx, _ := arrayIndex(tokens[0], len(v), false)
+        v[idx] = child
+    }
+    return doc, nil
+}
+
+// Add `value` to `doc` at `tokens`.
+func addPointer(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+    if len(tokens) == 0 {
+        return value, nil
+    }
+
+    return updatePointer(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+        switch v := container.(type) {
+        case map[string]interface{}:
+            v[token] = value
+            return v, nil
+        case []interface{}:
+            idx, err := arrayIndex(token, len(v), true)
+            if err != nil {
+                return nil, err
+            }
+            v = append(v, nil)
+            copy(v[idx + 1:], v[idx:])
+            v[idx] = value
+            return v, nil
+        default:
+            return nil, fmt.Errorf("'%s' isn't within an object or array", token)
+        }
+    })
+}
+
+// Remove the value at `tokens` from `doc`. If `replacement` isn't nil, the
+// value is replaced by it instead.
+func removePointer(doc interface{}, tokens []string, replacement *interface{}) (interface{}, error) {
+    if len(tokens) == 0 {
+        if replacement != nil {
+            return *replacement, nil
+        }
+        return nil, fmt.Errorf("the whole document can't be removed")
+    }
+
+    return updatePointer(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+        switch v := container.(type) {
+        case map[string]interface{}:
+            if _, ok := v[token]; !ok {
+                return nil, fmt.Errorf("member '%s' doesn't exist", token)
+            } else if replacement != nil {
+                v[token] = *replacement
+            } else {
+                delete(v, token)
+            }
+            return v, nil
+        case []interface{}:
+            idx, err := arrayIndex(token, len(v), false)
+            if err != nil {
+                return nil, err
+            } else if replacement != nil {
+                v[idx] = *replacement
+                return v, nil
+            }
+            return append(v[:idx], v[idx + 1:]...), nil
+        default:
+            return nil, fmt.Errorf("'%s' isn't within an object or array", token)
+        }
+    })
+}
+
+// Copy a value decoded by `encoding/json`, so it may be modified without
+// affecting the original.
+func deepCopy(v interface{}) interface{} {
+    switch val := v.(type) {
+    case map[string]interface{}:
+        m := make(map[string]interface{}, len(val))
+        for k, child := range val {
+            m[k] = deepCopy(child)
+        }
+        return m
+    case []interface{}:
+        list := make([]interface{}, len(val))
+        for i, child := range val {
+            list[i] = deepCopy(child)
+        }
+        return list
+    default:
+        return v
+    }
+}
+
+// Check whether the pointer `prefix` references an ancestor of `tokens`.
+func isAncestor(prefix, tokens []string) bool {
+    if len(prefix) >= len(tokens) {
+        return false
+    }
+    for i := range prefix {
+        if prefix[i] != tokens[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// Apply every operation of a JSON Patch to `doc`, retrieving the modified
+// document. `doc` may be modified even on failure.
+func applyJSONPatch(doc interface{}, ops []patchOp) (interface{}, error) {
+    var err error
+
+    for i, op := range ops {
+        switch op.Op {
+        case "add":
+            doc, err = addPointer(doc, op.path, deepCopy(op.value))
+        case "remove":
+            doc, err = removePointer(doc, op.path, nil)
+        case "replace":
+            value := deepCopy(op.value)
+            doc, err = removePointer(doc, op.path, &value)
+        case "move":
+            var value interface{}
+            if isAncestor(op.from, op.path) {
+                err = fmt.Errorf("'%s' can't be moved into itself", op.From)
+                break
+            }
+            value, err = getPointer(doc, op.from)
+            if err == nil {
+                doc, err = removePointer(doc, op.from, nil)
+            }
+            if err == nil {
+                doc, err = addPointer(doc, op.path, value)
+            }
+        case "copy":
+            var value interface{}
+            value, err = getPointer(doc, op.from)
+            if err == nil {
+                doc, err = addPointer(doc, op.path, deepCopy(value))
+            }
+        case "test":
+            var value interface{}
+            value, err = getPointer(doc, op.path)
+            if err == nil && !reflect.DeepEqual(value, op.value) {
+                err = fmt.Errorf("'%s' doesn't match the expected value", op.Path)
+            }
+        }
+
+        if err != nil {
+            return nil, fmt.Errorf("operation %d ('%s'): %w", i, op.Op, err)
+        }
+    }
+
+    return doc, nil
+}
+
+// Merge a JSON Merge Patch into `target`, retrieving the modified
+// document. `target` may be modified.
+func mergePatch(target, patch interface{}) interface{} {
+    p, ok := patch.(map[string]interface{})
+    if !ok {
+        return patch
+    }
+
+    t, ok := target.(map[string]interface{})
+    if !ok {
+        t = make(map[string]interface{})
+    }
+    for k, v := range p {
+        if v == nil {
+            delete(t, k)
+        } else {
+            t[k] = mergePatch(t[k], v)
+        }
+    }
+    return t
+}
+
+// Check the request's `If-Match` against the current version of its
+// resource. Must be called with the lock held.
+func (r *request) unsafeCheckMatch() error {
+    ifMatch := r.req.Header.Get("If-Match")
+    if len(ifMatch) == 0 {
+        return nil
+    }
+
+    d, ok := r.ctx.store[r.URLPath()]
+    if !ok || d.expired(time.Now()) || !matchesETag(ifMatch, d.etag()) {
+        reason := "The resource was modified since it was retrieved"
+        return newCodedError(nil, CodePreconditionFailed, reason, http.StatusPreconditionFailed)
+    }
+    return nil
+}
+
+// Apply the received patch to the requested resource.
+func (r *request) patch() error {
+    if len(r.urlPath) < 2 {
+        reason := "No resource was specified"
+        return newError(nil, reason, http.StatusBadRequest)
+    }
+
+    mtype, _, _ := mime.ParseMediaType(r.ContentType())
+    if mtype != mergePatchType && mtype != jsonPatchType {
+        reason := "Invalid Content-Type: wanted either " + mergePatchType + " or " + jsonPatchType
+        return newError(nil, reason, http.StatusUnsupportedMediaType)
+    }
+
+    ttl, err := r.ttl()
+    if err != nil {
+        return err
+    }
+
+    var src io.Reader = r.req.Body
+    if r.ctx.maxBytes > 0 {
+        src = io.LimitReader(src, r.ctx.maxBytes + 1)
+    }
+    content, err := io.ReadAll(src)
+    if err != nil {
+        reason := "Failed to receive the patch"
+        return newError(err, reason, http.StatusInternalServerError)
+    }
+
+    // Parse the patch before locking, so it's only applied if valid
+    var merge interface{}
+    var ops []patchOp
+    if mtype == mergePatchType {
+        err = json.Unmarshal(content, &merge)
+    } else {
+        ops, err = parseJSONPatch(content)
+    }
+    if err != nil {
+        reason := "Invalid patch: " + err.Error()
+        return newCodedError(err, CodeInvalidPatch, reason, http.StatusBadRequest)
+    }
+
+    r.ctx.rwmut.Lock()
+    defer r.ctx.rwmut.Unlock()
+
+    err = r.unsafeCheckMatch()
+    if err != nil {
+        return err
+    }
+
+    key := r.URLPath()
+    cur, ok := r.ctx.store[key]
+    if ok && cur.expired(time.Now()) {
+        ok = false
+    }
+
+    var doc interface{}
+    if ok {
+        err = json.Unmarshal(cur.body.Bytes(), &doc)
+        if err != nil {
+            reason := "The stored resource isn't JSON"
+            return newCodedError(err, CodePatchFailed, reason, http.StatusConflict)
+        }
+    }
+
+    if mtype == mergePatchType {
+        doc = mergePatch(doc, merge)
+    } else {
+        doc, err = applyJSONPatch(doc, ops)
+        if err != nil {
+            reason := "Failed to apply the patch: " + err.Error()
+            return newCodedError(err, CodePatchFailed, reason, http.StatusConflict)
+        }
+    }
+
+    body, err := json.Marshal(doc)
+    if err != nil {
+        reason := "Failed to encode the patched resource"
+        return newError(err, reason, http.StatusInternalServerError)
+    }
+    err = r.ctx.unsafeCheckSize(key, int64(len(body)))
+    if err != nil {
+        return err
+    }
+
+    d := data {
+        contentType: "application/json",
+        body: bytes.NewBuffer(body),
+        modified: time.Now(),
+    }
+    if ok {
+        d.contentType = cur.contentType
+        d.expires = cur.expires
+    }
+    if ttl > 0 {
+        d.expires = d.modified.Add(ttl)
+    }
+    r.ctx.unsafeSet(key, &d)
+
+    r.w.Header().Set("ETag", d.etag())
+    r.w.WriteHeader(http.StatusNoContent)
+    return nil
+}
@@ -0,0 +1,116 @@
+package ram_store
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+)
+
+// Decode a JSON document used by the tests.
+func decode(t *testing.T, content string) interface{} {
+    var v interface{}
+
+    err := json.Unmarshal([]byte(content), &v)
+    if err != nil {
+        t.Fatalf("Invalid test document '%s': %+v", content, err)
+    }
+    return v
+}
+
+func TestMergePatch(t *testing.T) {
+    // Examples from RFC 7386, Appendix A
+    for _, tc := range []struct {
+        target, patch, expected string
+    } {
+        { `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}` },
+        { `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}` },
+        { `{"a":"b"}`, `{"a":null}`, `{}` },
+        { `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}` },
+        { `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}` },
+        { `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}` },
+        { `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}` },
+        { `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}` },
+        { `["a","b"]`, `["c","d"]`, `["c","d"]` },
+        { `{"a":"b"}`, `["c"]`, `["c"]` },
+        { `{"e":null}`, `{"a":1}`, `{"e":null,"a":1}` },
+        { `[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}` },
+        { `{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}` },
+        { `null`, `{"a":1}`, `{"a":1}` },
+    } {
+        got := mergePatch(decode(t, tc.target), decode(t, tc.patch))
+        if expected := decode(t, tc.expected); !reflect.DeepEqual(got, expected) {
+            t.Errorf("%s + %s: expected %v, got %v", tc.target, tc.patch, expected, got)
+        }
+    }
+}
+
+func TestJSONPatch(t *testing.T) {
+    // Mostly examples from RFC 6902, Appendix A
+    for _, tc := range []struct {
+        doc, patch, expected string
+    } {
+        { `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}` },
+        { `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}` },
+        { `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}` },
+        { `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}` },
+        { `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}` },
+        {
+            `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+            `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+            `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+        },
+        { `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}` },
+        { `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}` },
+        { `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}` },
+        { `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}` },
+        { `{"/":1,"~":2}`, `[{"op":"copy","from":"/~1","path":"/~0"}]`, `{"/":1,"~":1}` },
+        { `{"a":{"b":[1]}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"add","path":"/c/b/-","value":2}]`, `{"a":{"b":[1]},"c":{"b":[1,2]}}` },
+        { `null`, `[{"op":"add","path":"","value":{"a":1}}]`, `{"a":1}` },
+    } {
+        ops, err := parseJSONPatch([]byte(tc.patch))
+        if err != nil {
+            t.Errorf("%s: failed to parse the patch: %+v", tc.patch, err)
+            continue
+        }
+
+        got, err := applyJSONPatch(decode(t, tc.doc), ops)
+        if err != nil {
+            t.Errorf("%s + %s: failed to apply the patch: %+v", tc.doc, tc.patch, err)
+        } else if expected := decode(t, tc.expected); !reflect.DeepEqual(got, expected) {
+            t.Errorf("%s + %s: expected %v, got %v", tc.doc, tc.patch, expected, got)
+        }
+    }
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+    for _, tc := range []struct {
+        doc, patch string
+    } {
+        { `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]` },
+        { `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]` },
+        { `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":1}]` },
+        { `{"foo":["bar"]}`, `[{"op":"remove","path":"/foo/01"}]` },
+        { `{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]` },
+        { `{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]` },
+        { `{"foo":"bar"}`, `[{"op":"remove","path":""}]` },
+    } {
+        ops, err := parseJSONPatch([]byte(tc.patch))
+        if err != nil {
+            t.Errorf("%s: failed to parse the patch: %+v", tc.patch, err)
+        } else if _, err = applyJSONPatch(decode(t, tc.doc), ops); err == nil {
+            t.Errorf("%s + %s: expected an error", tc.doc, tc.patch)
+        }
+    }
+
+    for _, patch := range []string {
+        `{"op":"add","path":"/a","value":1}`,
+        `[{"op":"frobnicate","path":"/a"}]`,
+        `[{"op":"add","path":"a","value":1}]`,
+        `[{"op":"add","path":"/a"}]`,
+        `[{"op":"copy","from":"a","path":"/b"}]`,
+    } {
+        if _, err := parseJSONPatch([]byte(patch)); err == nil {
+            t.Errorf("%s: expected an error", patch)
+        }
+    }
+}
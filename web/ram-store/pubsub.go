@@ -0,0 +1,501 @@
+// Publish/subscribe message channels. Resources are last-write-wins, so
+// clients that poll them (or even watch them) may miss intermediate
+// states. Topics, on the other hand, keep every message in order, so
+// discrete events (e.g., a quick key press and release) aren't lost:
+//
+//   * POST (or PUT) `/ram_store/_pubsub/<topic>`: Publish the request's
+//     body as a message. Messages are numbered sequentially, per topic,
+//     and the reply contains the message's number (e.g., `{"Seq": 13}`);
+//   * GET `/ram_store/_pubsub/<topic>`: Retrieve the messages published
+//     after a given message (see below). Adding `?wait=<timeout>` waits
+//     for new messages, if there aren't any, just like resources do.
+//     `?limit=<n>` limits how many messages are retrieved (at most, and by
+//     default, 100);
+//   * GET with `Accept: text/event-stream`: Stream every message published
+//     to the topic as Server-Sent Events;
+//   * DELETE `/ram_store/_pubsub/<topic>`: Delete the topic, every
+//     retained message and every subscriber's cursor.
+//
+// Topics are created when the first message is published to them.
+// Subscribing to a topic that doesn't exist (or that was deleted) behaves
+// as if the topic were empty, so subscribers may wait for its first
+// message.
+//
+// Subscribers choose where to start reading with `?after=<seq>`. If they
+// instead identify themselves with `?subscriber=<name>`, the server
+// remembers the last message retrieved by each subscriber (its cursor),
+// and each GET continues from there. Cursors that aren't used for
+// `cursorTimeout` are forgotten. New subscribers, and requests
+// without either parameter, only receive messages published after they
+// first arrive (`?after=0` retrieves every retained message). Streams
+// resume from their `Last-Event-ID`, if reconnecting. Messages are
+// retrieved as:
+//
+//     {
+//         "Messages": [
+//             {"Seq": 12, "Time": "2021-06-12T15:04:05.999Z", "ContentType": "application/json", "Body": "{\"Key\":\"A\",\"Pressed\":true}"}
+//         ],
+//         "Next": 12,
+//         "Generation": "kq3v1x2a",
+//         "Lost": 0
+//     }
+//
+// `Next` must be sent as `after` by the next request (if not using a
+// `subscriber`), alongside `Generation` as `?generation=<generation>`.
+// Every time a topic is created it gets a new generation, and its messages
+// are numbered from 1 again. So, if the topic was deleted and created
+// again since the request's generation, every retained message is
+// retrieved, instead of skipping those numbered up to `after`. Streams
+// send the generation in each event's ID (e.g., `id: kq3v1x2a-12`), so
+// `Last-Event-ID` is handled the same way.
+//
+// Only the last `Config.PubSubRetention` messages of each topic are kept,
+// and `Lost` reports how many messages were dropped before the subscriber
+// could retrieve them. Streams report lost messages as a `lost` event
+// (e.g., `data: {"Lost": 3}`). Just like `watch.go`, `Body` is sent as a
+// string.
+//
+// Delivery is at-most-once: a subscriber's cursor advances as soon as the
+// messages are retrieved, so messages in a reply that never reaches the
+// client (e.g., if the connection drops) aren't retrieved again. Clients
+// that can't lose messages should send `after` (and `generation`)
+// themselves, only advancing it once the reply was handled.
+//
+// The retained messages count towards `Config.MaxBytes`, alongside the
+// stored resources, so publishing a message that would exceed it fails
+// with 413 (Request Entity Too Large).
+//
+// Topics are only kept in memory, and they aren't saved in snapshots.
+// Note that, since this is handled by `ram_store`, no resource may be
+// stored in `/ram_store/_pubsub`.
+
+package ram_store
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    "io"
+    "net/http"
+    "path"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// Path (inside `Prefix`) of the topics.
+const pubsubPath = "_pubsub"
+
+// How many messages are kept per topic, if not configured otherwise.
+const DefaultRetention = 256
+
+// Most messages retrieved by a single request.
+const maxMessages = 100
+
+// How long a subscriber's cursor is kept since it was last used.
+const cursorTimeout = 10 * time.Minute
+
+// A message published to a topic.
+type message struct {
+    // The message's sequence number, unique within the topic.
+    Seq uint64
+    // When the message was published.
+    Time time.Time
+    // The message's content type.
+    ContentType string
+    // The message's content.
+    Body string
+}
+
+// Messages retrieved by a subscriber.
+type messageList struct {
+    // The retrieved messages, in order.
+    Messages []message
+    // Sequence number of the last retrieved message.
+    Next uint64
+    // The topic's generation, which changes whenever it's created.
+    Generation string
+    // How many messages were dropped before being retrieved.
+    Lost uint64
+}
+
+// A subscriber's position within a topic.
+type cursor struct {
+    // Sequence number of the last message retrieved by the subscriber.
+    seq uint64
+    // When the subscriber last retrieved messages.
+    used time.Time
+}
+
+// A topic and its retained messages.
+type topic struct {
+    // The last retained messages, in order. Their sequence numbers are
+    // contiguous.
+    messages []message
+    // Sequence number of the last published message.
+    latest uint64
+    // Identifies this instance of the topic. Empty if the topic doesn't
+    // exist.
+    generation string
+    // Total size of the retained messages, in bytes.
+    size int64
+    // Cursor of each subscriber. Nil if the topic doesn't exist.
+    cursors map[string]cursor
+    // Closed (and replaced) whenever a message is published.
+    signal chan struct{}
+}
+
+// Retrieve the topic `name`. If it doesn't exist, an empty topic (which
+// isn't stored) is retrieved instead, signaled whenever any topic is
+// created. Must be called with `pubMut` held.
+func (ctx *rstore) unsafeTopic(name string) *topic {
+    if t, ok := ctx.topics[name]; ok {
+        return t
+    }
+    return &topic {
+        signal: ctx.created,
+    }
+}
+
+// Retrieve the topic `name`, creating it if needed. Must be called with
+// `pubMut` held.
+func (ctx *rstore) unsafeCreateTopic(name string) *topic {
+    t, ok := ctx.topics[name]
+    if !ok {
+        t = &topic {
+            generation: newEpoch(),
+            cursors: make(map[string]cursor),
+            signal: make(chan struct{}),
+        }
+        ctx.topics[name] = t
+
+        close(ctx.created)
+        ctx.created = make(chan struct{})
+    }
+    return t
+}
+
+// Forget the cursors that weren't used since `cursorTimeout` before `now`.
+func (ctx *rstore) pruneCursors(now time.Time) {
+    ctx.pubMut.Lock()
+    defer ctx.pubMut.Unlock()
+
+    for _, t := range ctx.topics {
+        for name, c := range t.cursors {
+            if now.Sub(c.used) >= cursorTimeout {
+                delete(t.cursors, name)
+            }
+        }
+    }
+}
+
+// Check whether `after` (from a request for the topic's `generation`) may
+// be used to retrieve messages from `t`, resetting it to 0 otherwise. A
+// cursor from another generation, or beyond the latest message (e.g., from
+// before a restart, if the generation wasn't sent), refers to a topic that
+// was deleted, so every message in `t` wasn't retrieved yet.
+func (t *topic) checkCursor(after uint64, generation string) uint64 {
+    if generation != t.generation || after > t.latest {
+        return 0
+    }
+    return after
+}
+
+// Parse the ID of an event sent by `streamTopic` (i.e.,
+// "<generation>-<seq>", or simply "<seq>").
+func parseEventID(id string) (generation string, seq uint64, err error) {
+    if i := strings.LastIndex(id, "-"); i >= 0 {
+        generation, id = id[:i], id[i + 1:]
+    }
+    seq, err = strconv.ParseUint(id, 10, 64)
+    return generation, seq, err
+}
+
+// Retrieve up to `limit` messages published after `after`, and how many
+// of those were already dropped.
+func (t *topic) read(after uint64, limit int) ([]message, uint64) {
+    if after >= t.latest {
+        return []message{}, 0
+    }
+
+    oldest := t.latest + 1 - uint64(len(t.messages))
+
+    var lost uint64
+    if after + 1 < oldest {
+        lost = oldest - (after + 1)
+        after = oldest - 1
+    }
+
+    list := t.messages[after + 1 - oldest:]
+    if len(list) > limit {
+        list = list[:limit]
+    }
+    return append([]message{}, list...), lost
+}
+
+// Retrieve the topic requested by the URL (e.g., "keyboard", for
+// `/ram_store/_pubsub/keyboard`).
+func (r *request) topicName() (string, error) {
+    name := path.Join(r.urlPath[2:]...)
+    if len(name) == 0 {
+        reason := "URL must be " + Prefix + "/" + pubsubPath + "/<topic>"
+        return "", newError(nil, reason, http.StatusNotFound)
+    }
+    return name, nil
+}
+
+// Parse the unsigned integer query parameter `key`. `ok` is false if the
+// parameter is missing.
+func (r *request) queryUint(key string) (value uint64, ok bool, err error) {
+    str := r.req.URL.Query().Get(key)
+    if len(str) == 0 {
+        return 0, false, nil
+    }
+
+    value, err = strconv.ParseUint(str, 10, 64)
+    if err != nil {
+        reason := "Invalid '" + key + "': must be a non-negative integer"
+        return 0, false, newError(err, reason, http.StatusBadRequest)
+    }
+    return value, true, nil
+}
+
+// Handle a request to a topic.
+func (r *request) handlePubSub() error {
+    name, err := r.topicName()
+    if err != nil {
+        return err
+    }
+
+    switch r.req.Method {
+    case http.MethodGet:
+        if strings.Contains(r.req.Header.Get("Accept"), "text/event-stream") {
+            return r.streamTopic(name)
+        }
+        return r.subscribe(name)
+    case http.MethodPost, http.MethodPut:
+        return r.publish(name)
+    case http.MethodDelete:
+        r.ctx.pubMut.Lock()
+        t, ok := r.ctx.topics[name]
+        if ok {
+            delete(r.ctx.topics, name)
+            atomic.AddInt64(&r.ctx.topicBytes, -t.size)
+        }
+        r.ctx.pubMut.Unlock()
+
+        if !ok {
+            reason := "Topic not found in the server"
+            return newError(nil, reason, http.StatusNotFound)
+        }
+        close(t.signal)
+        r.w.WriteHeader(http.StatusNoContent)
+        return nil
+    default:
+        return newError(nil, "Invalid method: wanted one of GET, POST, PUT or DELETE", http.StatusMethodNotAllowed)
+    }
+}
+
+// Publish the request's body to the topic `name`.
+func (r *request) publish(name string) error {
+    var src io.Reader = r.req.Body
+    if r.ctx.maxBytes > 0 {
+        src = io.LimitReader(src, r.ctx.maxBytes + 1)
+    }
+    var body bytes.Buffer
+    _, err := io.Copy(&body, src)
+    if err != nil {
+        reason := "Failed to receive the message"
+        return newError(err, reason, http.StatusInternalServerError)
+    } else if r.ctx.maxBytes > 0 && int64(body.Len()) > r.ctx.maxBytes {
+        reason := fmt.Sprintf("The message exceeds the limit of %d bytes", r.ctx.maxBytes)
+        return newCodedError(nil, CodeTooLarge, reason, http.StatusRequestEntityTooLarge)
+    }
+
+    r.ctx.pubMut.Lock()
+    err = r.ctx.unsafeCheckTopicSize(name, int64(body.Len()))
+    if err != nil {
+        r.ctx.pubMut.Unlock()
+        return err
+    }
+
+    t := r.ctx.unsafeCreateTopic(name)
+    t.latest++
+    t.messages = append(t.messages, message {
+        Seq: t.latest,
+        Time: time.Now(),
+        ContentType: r.ContentType(),
+        Body: body.String(),
+    })
+    size := int64(body.Len())
+    if n := len(t.messages) - r.ctx.retention; n > 0 {
+        for _, msg := range t.messages[:n] {
+            size -= int64(len(msg.Body))
+        }
+        t.messages = append([]message{}, t.messages[n:]...)
+    }
+    t.size += size
+    atomic.AddInt64(&r.ctx.topicBytes, size)
+    seq := t.latest
+
+    close(t.signal)
+    t.signal = make(chan struct{})
+    r.ctx.pubMut.Unlock()
+
+    return r.sendJSON(&struct{ Seq uint64 } { seq })
+}
+
+// Send the messages published to the topic `name` after the subscriber's
+// cursor, waiting for them if requested.
+func (r *request) subscribe(name string) error {
+    subscriber := r.req.URL.Query().Get("subscriber")
+    generation := r.req.URL.Query().Get("generation")
+    after, hasAfter, err := r.queryUint("after")
+    if err != nil {
+        return err
+    }
+    limit, hasLimit, err := r.queryUint("limit")
+    if err != nil {
+        return err
+    } else if !hasLimit || limit == 0 || limit > maxMessages {
+        limit = maxMessages
+    }
+    timeout, err := r.waitTimeout()
+    if err != nil {
+        return err
+    }
+
+    r.ctx.pubMut.Lock()
+    t := r.ctx.unsafeTopic(name)
+    if c, ok := t.cursors[subscriber]; !hasAfter && ok && len(subscriber) > 0 {
+        after = c.seq
+    } else if !hasAfter {
+        after = t.latest
+    }
+    if len(generation) == 0 {
+        generation = t.generation
+    }
+    r.ctx.pubMut.Unlock()
+
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+
+    for {
+        r.ctx.pubMut.Lock()
+        t := r.ctx.unsafeTopic(name)
+        after = t.checkCursor(after, generation)
+        generation = t.generation
+        msgs, lost := t.read(after, int(limit))
+        signal := t.signal
+
+        done := len(msgs) > 0 || lost > 0 || timeout <= 0
+        if done {
+            res := messageList {
+                Messages: msgs,
+                Next: after + lost + uint64(len(msgs)),
+                Generation: generation,
+                Lost: lost,
+            }
+            if len(subscriber) > 0 && t.cursors != nil {
+                t.cursors[subscriber] = cursor {
+                    seq: res.Next,
+                    used: time.Now(),
+                }
+            }
+            r.ctx.pubMut.Unlock()
+            return r.sendJSON(&res)
+        }
+        r.ctx.pubMut.Unlock()
+
+        select {
+        case <-signal:
+        case <-timer.C:
+            timeout = 0
+        case <-r.req.Context().Done():
+            return nil
+        case <-r.ctx.stop:
+            timeout = 0
+        }
+    }
+}
+
+// Stream the messages published to the topic `name` until the client
+// disconnects or the handler is closed.
+func (r *request) streamTopic(name string) error {
+    flusher, ok := r.w.(http.Flusher)
+    if !ok {
+        return newError(nil, "Streaming isn't supported", http.StatusInternalServerError)
+    }
+
+    generation := r.req.URL.Query().Get("generation")
+    after, hasAfter, err := r.queryUint("after")
+    if err != nil {
+        return err
+    } else if id := r.req.Header.Get("Last-Event-ID"); len(id) > 0 {
+        if gen, seq, err := parseEventID(id); err == nil {
+            after = seq
+            hasAfter = true
+            generation = gen
+        }
+    }
+
+    r.ctx.pubMut.Lock()
+    if t := r.ctx.unsafeTopic(name); !hasAfter {
+        after = t.latest
+        generation = t.generation
+    } else if len(generation) == 0 {
+        generation = t.generation
+    }
+    r.ctx.pubMut.Unlock()
+
+    hdr := r.w.Header()
+    hdr.Set("Content-Type", "text/event-stream")
+    hdr.Set("Cache-Control", "no-cache")
+    hdr.Set("X-Accel-Buffering", "no")
+    r.w.WriteHeader(http.StatusOK)
+    io.WriteString(r.w, "retry: 1000\n\n")
+    flusher.Flush()
+
+    keepAlive := time.NewTicker(keepAliveInterval)
+    defer keepAlive.Stop()
+
+    for {
+        r.ctx.pubMut.Lock()
+        t := r.ctx.unsafeTopic(name)
+        after = t.checkCursor(after, generation)
+        generation = t.generation
+        msgs, lost := t.read(after, len(t.messages))
+        signal := t.signal
+        r.ctx.pubMut.Unlock()
+
+        if lost > 0 {
+            fmt.Fprintf(r.w, "event: lost\ndata: {\"Lost\": %d}\n\n", lost)
+            after += lost
+        }
+        for _, msg := range msgs {
+            data, err := json.Marshal(&msg)
+            if err != nil {
+                logger.Errorf("web%s: Failed to encode the message: %+v", Prefix, err)
+                continue
+            }
+            fmt.Fprintf(r.w, "event: message\nid: %s-%d\ndata: %s\n\n", generation, msg.Seq, data)
+            after = msg.Seq
+        }
+        if lost > 0 || len(msgs) > 0 {
+            flusher.Flush()
+        }
+
+        select {
+        case <-r.req.Context().Done():
+            return nil
+        case <-r.ctx.stop:
+            return nil
+        case <-keepAlive.C:
+            io.WriteString(r.w, ": keep-alive\n\n")
+            flusher.Flush()
+        case <-signal:
+        }
+    }
+}
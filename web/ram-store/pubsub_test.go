@@ -0,0 +1,200 @@
+package ram_store
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// Publish `count` messages to the topic `name`.
+func publishN(t *testing.T, ctx *rstore, name string, count int) {
+    for i := 0; i < count; i++ {
+        w := serve(ctx, http.MethodPost, "/ram_store/_pubsub/" + name, fmt.Sprint(i), "Content-Type", "text/plain")
+        expectStatus(t, "POST " + name, w, http.StatusOK)
+    }
+}
+
+// Retrieve the messages from `target`, checking the sequence number of the
+// first and last messages (0 if no message is expected), `Next` and `Lost`.
+func expectMessages(t *testing.T, ctx *rstore, target string, first, last, next, lost uint64) messageList {
+    t.Helper()
+
+    var res messageList
+    w := serve(ctx, http.MethodGet, target, "")
+    if w.Code != http.StatusOK {
+        t.Errorf("GET %s: expected status %d, got %d (%s)", target, http.StatusOK, w.Code, w.Body.String())
+        return res
+    }
+
+    err := json.NewDecoder(w.Body).Decode(&res)
+    if err != nil {
+        t.Errorf("GET %s: failed to decode the response: %+v", target, err)
+        return res
+    }
+
+    var gotFirst, gotLast uint64
+    if n := len(res.Messages); n > 0 {
+        gotFirst, gotLast = res.Messages[0].Seq, res.Messages[n - 1].Seq
+    }
+    if gotFirst != first || gotLast != last || res.Next != next || res.Lost != lost {
+        t.Errorf("GET %s: expected messages %d..%d (next: %d, lost: %d), got %d..%d (next: %d, lost: %d)",
+                 target, first, last, next, lost, gotFirst, gotLast, res.Next, res.Lost)
+    }
+    return res
+}
+
+func TestPubSubRetention(t *testing.T) {
+    ctx := newTestStore(t, Config {
+        PubSubRetention: 3,
+    })
+
+    publishN(t, ctx, "t", 2)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?after=0", 1, 2, 2, 0)
+
+    publishN(t, ctx, "t", 3)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?after=0", 3, 5, 5, 2)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?after=1", 3, 5, 5, 1)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?after=3", 4, 5, 5, 0)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?after=0&limit=2", 3, 4, 4, 2)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?after=5", 0, 0, 5, 0)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t", 0, 0, 5, 0)
+}
+
+func TestPubSubCursors(t *testing.T) {
+    ctx := newTestStore(t, Config{})
+
+    publishN(t, ctx, "t", 2)
+    // New subscribers start after the latest message
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?subscriber=a", 0, 0, 2, 0)
+
+    publishN(t, ctx, "t", 3)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?subscriber=a&limit=2", 3, 4, 4, 0)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?subscriber=a", 5, 5, 5, 0)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?subscriber=a", 0, 0, 5, 0)
+    // `after` overrides (and replaces) the cursor
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?subscriber=a&after=3", 4, 5, 5, 0)
+
+    // Unused cursors are eventually forgotten
+    ctx.pruneCursors(time.Now().Add(cursorTimeout))
+    publishN(t, ctx, "t", 1)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?subscriber=a", 0, 0, 6, 0)
+}
+
+func TestPubSubDelete(t *testing.T) {
+    ctx := newTestStore(t, Config{})
+
+    publishN(t, ctx, "t", 5)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?subscriber=a&after=0", 1, 5, 5, 0)
+
+    w := serve(ctx, http.MethodDelete, "/ram_store/_pubsub/t", "")
+    expectStatus(t, "DELETE", w, http.StatusNoContent)
+    w = serve(ctx, http.MethodDelete, "/ram_store/_pubsub/t", "")
+    expectStatus(t, "DELETE (deleted)", w, http.StatusNotFound)
+
+    // Subscribing doesn't create the topic
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?subscriber=a", 0, 0, 0, 0)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?after=5", 0, 0, 0, 0)
+    ctx.pubMut.Lock()
+    topics := len(ctx.topics)
+    ctx.pubMut.Unlock()
+    if topics != 0 {
+        t.Errorf("GET (deleted): expected no topic, got %d", topics)
+    }
+
+    // Cursors from before the topic was deleted retrieve every new message
+    publishN(t, ctx, "t", 2)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?after=5", 1, 2, 2, 0)
+    // The subscriber's cursor was deleted alongside the topic
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?subscriber=a", 0, 0, 2, 0)
+}
+
+func TestPubSubGeneration(t *testing.T) {
+    ctx := newTestStore(t, Config{})
+
+    publishN(t, ctx, "t", 5)
+    old := expectMessages(t, ctx, "/ram_store/_pubsub/t?subscriber=a&after=0", 1, 5, 5, 0).Generation
+    if len(old) == 0 {
+        t.Fatalf("GET: expected the topic's generation")
+    }
+
+    // Once the topic is created again, cursors from the previous
+    // generation retrieve every message, even if the new topic already
+    // went past them
+    serve(ctx, http.MethodDelete, "/ram_store/_pubsub/t", "")
+    publishN(t, ctx, "t", 7)
+    target := "/ram_store/_pubsub/t?generation=" + old
+    gen := expectMessages(t, ctx, target + "&after=5", 1, 7, 7, 0).Generation
+    if gen == old {
+        t.Errorf("GET: expected a new generation, got '%s'", gen)
+    }
+    expectMessages(t, ctx, target + "&subscriber=a", 1, 7, 7, 0)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?subscriber=a&generation=" + gen, 0, 0, 7, 0)
+    expectMessages(t, ctx, "/ram_store/_pubsub/t?after=5&generation=" + gen, 6, 7, 7, 0)
+
+    for _, tc := range []struct {
+        id string
+        generation string
+        seq uint64
+    } {
+        { gen + "-12", gen, 12 },
+        { "12", "", 12 },
+    } {
+        generation, seq, err := parseEventID(tc.id)
+        if err != nil || generation != tc.generation || seq != tc.seq {
+            t.Errorf("Event ID '%s': expected '%s' and %d, got '%s' and %d (%+v)", tc.id, tc.generation, tc.seq, generation, seq, err)
+        }
+    }
+}
+
+func TestPubSubMaxBytes(t *testing.T) {
+    ctx := newTestStore(t, Config {
+        MaxBytes: 8,
+        PubSubRetention: 1,
+    })
+
+    for _, tc := range []struct {
+        method string
+        target string
+        body string
+        status int
+    } {
+        { http.MethodPost, "/ram_store/_pubsub/t", "1234", http.StatusOK },
+        { http.MethodPost, "/ram_store/_pubsub/u", "1234", http.StatusOK },
+        { http.MethodPut, "/ram_store/a", "1", http.StatusRequestEntityTooLarge },
+        // The retained message is dropped to make room for the new one
+        { http.MethodPost, "/ram_store/_pubsub/t", "123", http.StatusOK },
+        { http.MethodPut, "/ram_store/a", "1", http.StatusNoContent },
+        { http.MethodPost, "/ram_store/_pubsub/v", "1", http.StatusRequestEntityTooLarge },
+        { http.MethodDelete, "/ram_store/_pubsub/u", "", http.StatusNoContent },
+        { http.MethodPut, "/ram_store/b", "1234", http.StatusNoContent },
+    } {
+        w := serve(ctx, tc.method, tc.target, tc.body, "Content-Type", "text/plain")
+        expectStatus(t, tc.method + " " + tc.target, w, tc.status)
+    }
+
+    if got := atomic.LoadInt64(&ctx.topicBytes); got != 3 {
+        t.Errorf("Expected the topics to retain 3 bytes, got %d", got)
+    }
+}
+
+func TestPubSubWait(t *testing.T) {
+    ctx := newTestStore(t, Config{})
+
+    // Waiting on a topic that doesn't exist yet
+    done := make(chan struct{})
+    go func() {
+        expectMessages(t, ctx, "/ram_store/_pubsub/t?after=0&wait=10s", 1, 1, 1, 0)
+        close(done)
+    }()
+    time.Sleep(20 * time.Millisecond)
+    publishN(t, ctx, "t", 1)
+
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatalf("GET: didn't return after a message was published")
+    }
+}
@@ -6,8 +6,8 @@
 // implement communication between arbitrary elements (e.g., a key logger
 // sending the keyboard status and a page displaying the keys)
 //
-// The accept methods are GET, DELETE, POST and PUT, and the last two are
-// handled identically. GET returns the same Content Type specified in the
+// The accept methods are GET, DELETE, POST, PUT and PATCH, and POST and PUT
+// are handled identically. GET returns the same Content Type specified in the
 // POST/PUT request, and the URL identify the resource being accessed.
 //
 // For example, to implement a keyboard viewer one could send the keyboard
@@ -20,6 +20,14 @@
 //         "SpaceBar": false,
 //         "Return": false,
 //     }
+//
+// Resources are only kept in memory, unless snapshots are enabled (see
+// `Config`). Clients may also wait for changes to resources, instead of
+// polling them (see `watch.go`). Resources may be listed or deleted by
+// prefix (see `list.go`), they may expire (see `limits.go`) and JSON
+// resources may be partially updated with a PATCH (see `patch.go`).
+// Discrete messages, which must not be lost, may be sent through topics
+// instead (see `pubsub.go`).
 
 package ram_store
 
@@ -29,31 +37,108 @@ import (
     "io"
     srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
     "net/http"
+    "os"
     "path"
     "sync"
+    "sync/atomic"
+    "time"
 )
 
 const Prefix = "/ram_store"
 
+const (
+    // Snapshots were requested, but `Config.SnapshotDir` isn't set.
+    CodeSnapshotDisabled = "snapshot-disabled"
+    // A restore was requested, but no snapshot was taken yet.
+    CodeNoSnapshot = "no-snapshot"
+    // Storing the resource would exceed `Config.MaxBytes`.
+    CodeTooLarge = "too-large"
+    // The received patch is malformed.
+    CodeInvalidPatch = "invalid-patch"
+    // The patch couldn't be applied to the stored resource.
+    CodePatchFailed = "patch-failed"
+    // The resource doesn't match the request's `If-Match`.
+    CodePreconditionFailed = "precondition-failed"
+)
+
 // Build a new error
 func newError(err error, res string, status int) error {
     return srv_iface.NewHttpError(err, "web"+Prefix, res, status)
 }
 
+// Build a new error, identified by `code`.
+func newCodedError(err error, code, res string, status int) error {
+    return srv_iface.NewCodedHttpError(err, "web"+Prefix, code, res, status)
+}
+
 // Associate the received data to its content type.
 type data struct {
 	// The received content type for this resource
 	contentType string
 	// The resource's content
 	body *bytes.Buffer
+	// The resource's version, sent in its `ETag`.
+	version uint64
+	// The store's epoch when the resource was modified, sent in its
+	// `ETag`.
+	epoch string
+	// When the resource was last modified.
+	modified time.Time
+	// When the resource expires, or zero if it doesn't expire.
+	expires time.Time
 }
 
 // Context for the ram_store service
 type rstore struct {
     // Every received resource.
     store map[string]data
+    // Total size of the stored resources, in bytes.
+    size int64
+    // Limit to `size`, or zero if unlimited.
+    maxBytes int64
     // Synchronize access to the context.
     rwmut sync.RWMutex
+
+    // Directory where snapshots are saved. Empty if snapshots are
+    // disabled.
+    snapshotDir string
+    // Number of modifications to `store`. Also used as the version of the
+    // last modified resource.
+    changes uint64
+    // Value of `changes` when the last snapshot was saved (or restored).
+    saved uint64
+    // Identifies the current instance of the store, changing whenever
+    // it's created or restored, so versions from before a restart (which
+    // resets `changes`) never match the current ones.
+    epoch string
+    // Serialize taking and restoring snapshots.
+    snapMut sync.Mutex
+    // Signals that a resource was modified.
+    changed chan struct{}
+    // Closed when the handler is stopped (or closed), stopping every
+    // goroutine and watcher.
+    stop chan struct{}
+    // Ensure `stop` is only closed once.
+    stopOnce sync.Once
+    // Wait until every goroutine has stopped.
+    wg sync.WaitGroup
+
+    // Every client waiting for changes.
+    watchers map[*watcher]struct{}
+    // Synchronize access to the watchers.
+    watchMut sync.Mutex
+
+    // Every topic, by name.
+    topics map[string]*topic
+    // Total size of the messages retained by every topic. Only modified
+    // with `pubMut` held, but read atomically.
+    topicBytes int64
+    // Closed (and replaced) whenever a topic is created.
+    created chan struct{}
+    // How many messages are kept per topic.
+    retention int
+    // Synchronize access to the topics.
+    pubMut sync.Mutex
 }
 
 // Retrieve the path handled by `res`
@@ -91,15 +176,30 @@ func (r *request) Read(buf []byte) (int, error) {
 }
 
 func (r *request) send() error {
+    timeout, err := r.waitTimeout()
+    if err != nil {
+        return err
+    } else if timeout > 0 {
+        r.waitChange(timeout)
+    }
+
     r.ctx.rwmut.RLock()
 	defer r.ctx.rwmut.RUnlock()
 
 	data, ok := r.ctx.store[r.URLPath()]
-	if !ok {
+	if !ok || data.expired(time.Now()) {
         reason := "No resource was specified"
         return newError(nil, reason, http.StatusNotFound)
 	}
 
+    tag := data.etag()
+    r.w.Header().Set("ETag", tag)
+    r.w.Header().Set("Last-Modified", data.modified.UTC().Format(http.TimeFormat))
+    if inm := r.req.Header.Get("If-None-Match"); len(inm) > 0 && matchesETag(inm, tag) {
+        r.w.WriteHeader(http.StatusNotModified)
+        return nil
+    }
+
     r.w.Header().Set("Content-Type", data.contentType)
     r.w.WriteHeader(http.StatusOK)
 
@@ -122,23 +222,51 @@ func (r *request) send() error {
 }
 
 func (r *request) recv() error {
-    r.ctx.rwmut.Lock()
-    defer r.ctx.rwmut.Unlock()
+    if len(r.urlPath) < 2 {
+        reason := "No resource was specified"
+        return newError(nil, reason, http.StatusBadRequest)
+    }
 
-	data, ok := r.ctx.store[r.URLPath()]
-	if !ok {
-		data.body = bytes.NewBuffer(nil)
-	}
+    ttl, err := r.ttl()
+    if err != nil {
+        return err
+    }
 
-	data.contentType = r.ContentType()
-	data.body.Reset()
-	_, err := io.Copy(data.body, r.req.Body)
+    // Read the body before locking, so slow clients don't block everyone
+    // else. If the size is limited, at most one byte over the limit is
+    // read, which is enough to reject the resource.
+    var src io.Reader = r.req.Body
+    if r.ctx.maxBytes > 0 {
+        src = io.LimitReader(src, r.ctx.maxBytes + 1)
+    }
+    body := bytes.NewBuffer(nil)
+	_, err = io.Copy(body, src)
 	if err != nil {
         reason := "Failed to store the data"
         return newError(err, reason, http.StatusInternalServerError)
 	}
 
-	r.ctx.store[r.URLPath()] = data
+    r.ctx.rwmut.Lock()
+    defer r.ctx.rwmut.Unlock()
+
+    err = r.unsafeCheckMatch()
+    if err != nil {
+        return err
+    }
+    err = r.ctx.unsafeCheckSize(r.URLPath(), int64(body.Len()))
+    if err != nil {
+        return err
+    }
+
+    d := data {
+        contentType: r.ContentType(),
+        body: body,
+        modified: time.Now(),
+    }
+    if ttl > 0 {
+        d.expires = d.modified.Add(ttl)
+    }
+	r.ctx.unsafeSet(r.URLPath(), &d)
 
     r.w.WriteHeader(http.StatusNoContent)
     return nil
@@ -148,11 +276,13 @@ func (r *request) del() error {
     r.ctx.rwmut.Lock()
     defer r.ctx.rwmut.Unlock()
 
-	if _, ok := r.ctx.store[r.URLPath()]; !ok {
+	if d, ok := r.ctx.store[r.URLPath()]; !ok || d.expired(time.Now()) {
         reason := "Resource not found in the server"
         return newError(nil, reason, http.StatusNotFound)
-	}
-	delete(r.ctx.store, r.URLPath())
+	} else if err := r.unsafeCheckMatch(); err != nil {
+        return err
+    }
+	r.ctx.unsafeSet(r.URLPath(), nil)
 
     r.w.WriteHeader(http.StatusNoContent)
     return nil
@@ -166,20 +296,94 @@ func (ctx *rstore) Handle(w http.ResponseWriter, req *http.Request, urlPath []st
         urlPath: urlPath,
     }
 
+    if len(urlPath) == 2 && (urlPath[1] == snapshotPath || urlPath[1] == restorePath) {
+        return r.handleSnapshot()
+    } else if len(urlPath) >= 2 && urlPath[1] == watchPath {
+        return r.stream()
+    } else if len(urlPath) >= 2 && urlPath[1] == pubsubPath {
+        return r.handlePubSub()
+    } else if len(urlPath) == 1 && req.Method == http.MethodGet {
+        return r.list()
+    } else if len(urlPath) == 1 && req.Method == http.MethodDelete {
+        return r.delPrefix()
+    }
+
     switch req.Method {
     case http.MethodGet:
         return r.send()
     case http.MethodPost, http.MethodPut:
         return r.recv()
+    case http.MethodPatch:
+        return r.patch()
     case http.MethodDelete:
         return r.del()
     default:
-        return newError(nil, "Invalid method: wanted one of GET, POST, PUT or DELETE", http.StatusMethodNotAllowed)
+        return newError(nil, "Invalid method: wanted one of GET, POST, PUT, PATCH or DELETE", http.StatusMethodNotAllowed)
     }
 }
 
+// Retrieve the metrics about the stored resources.
+func (ctx *rstore) Metrics() []srv_iface.Metric {
+    ctx.watchMut.Lock()
+    watchers := len(ctx.watchers)
+    ctx.watchMut.Unlock()
+
+    ctx.pubMut.Lock()
+    topics := len(ctx.topics)
+    messages := 0
+    for _, t := range ctx.topics {
+        messages += len(t.messages)
+    }
+    ctx.pubMut.Unlock()
+
+    ctx.rwmut.RLock()
+    defer ctx.rwmut.RUnlock()
+
+    return []srv_iface.Metric {
+        srv_iface.Metric {
+            Name: "ram_store_entries",
+            Help: "Number of resources stored.",
+            Value: float64(len(ctx.store)),
+        },
+        srv_iface.Metric {
+            Name: "ram_store_bytes",
+            Help: "Total size of the stored resources, in bytes.",
+            Value: float64(ctx.size),
+        },
+        srv_iface.Metric {
+            Name: "ram_store_watchers",
+            Help: "Number of clients waiting for changes.",
+            Value: float64(watchers),
+        },
+        srv_iface.Metric {
+            Name: "ram_store_topics",
+            Help: "Number of publish/subscribe topics.",
+            Value: float64(topics),
+        },
+        srv_iface.Metric {
+            Name: "ram_store_messages",
+            Help: "Number of messages retained in every topic.",
+            Value: float64(messages),
+        },
+        srv_iface.Metric {
+            Name: "ram_store_message_bytes",
+            Help: "Total size of the messages retained in every topic, in bytes.",
+            Value: float64(atomic.LoadInt64(&ctx.topicBytes)),
+        },
+    }
+}
+
+// End every stream and long-poll, so the `rstore` may be closed.
+func (ctx *rstore) Stop() {
+    ctx.stopOnce.Do(func() {
+        close(ctx.stop)
+    })
+}
+
 // Close resources associated with the `rstore`
 func (ctx *rstore) Close() {
+    ctx.shutdown()
+
     ctx.rwmut.Lock()
     defer ctx.rwmut.Unlock()
 
@@ -188,12 +392,83 @@ func (ctx *rstore) Close() {
     }
 }
 
+// Configure the `ram_store` handler.
+type Config struct {
+    // Directory where snapshots are saved, and from where they are
+    // restored. If empty, resources are only kept in memory.
+    SnapshotDir string
+    // Interval between periodic snapshots. Snapshots are only taken if any
+    // resource was modified. If zero, snapshots aren't taken
+    // periodically.
+    SnapshotInterval time.Duration
+    // Take a snapshot shortly after any resource is modified.
+    SnapshotOnChange bool
+    // Limit to the total size of the stored resources and of the messages
+    // retained by every topic, in bytes. If zero, the size isn't limited.
+    MaxBytes int64
+    // How many messages are kept per topic. Defaults to
+    // `DefaultRetention`.
+    PubSubRetention int
+}
+
 // Register a `ram_store` handler in the `Server`.
 func GetHandle(srv srv_iface.Server) error {
-    var ctx rstore
+    return GetHandleFromConfig(srv, Config{})
+}
 
-    ctx.store = make(map[string]data)
+// Register a `ram_store` handler in the `Server`, configured by `cfg`. If
+// snapshots are enabled, the last snapshot is restored.
+func GetHandleFromConfig(srv srv_iface.Server, cfg Config) error {
+    ctx, err := newStore(cfg)
+    if err != nil {
+        return err
+    }
 
-    srv.AddHandler(&ctx)
+    err = srv.AddHandler(ctx)
+    if err != nil {
+        ctx.shutdown()
+        return err
+    }
     return nil
 }
+
+// Create a new `rstore`, configured by `cfg`, and start its goroutines.
+func newStore(cfg Config) (*rstore, error) {
+    ctx := &rstore {
+        store: make(map[string]data),
+        snapshotDir: cfg.SnapshotDir,
+        maxBytes: cfg.MaxBytes,
+        stop: make(chan struct{}),
+        watchers: make(map[*watcher]struct{}),
+        topics: make(map[string]*topic),
+        created: make(chan struct{}),
+        retention: cfg.PubSubRetention,
+        epoch: newEpoch(),
+    }
+    if ctx.retention <= 0 {
+        ctx.retention = DefaultRetention
+    }
+
+    if len(ctx.snapshotDir) > 0 {
+        err := os.MkdirAll(ctx.snapshotDir, 0755)
+        if err != nil {
+            reason := "Failed to create the snapshots directory"
+            return nil, newError(err, reason, http.StatusInternalServerError)
+        }
+
+        err = ctx.restore()
+        if err != nil && !os.IsNotExist(err) {
+            reason := "Failed to restore the snapshot"
+            return nil, newError(err, reason, http.StatusInternalServerError)
+        }
+
+        ctx.changed = make(chan struct{}, 1)
+        ctx.wg.Add(1)
+        go ctx.runSnapshots(cfg.SnapshotInterval, cfg.SnapshotOnChange)
+    }
+
+    ctx.wg.Add(1)
+    go ctx.runExpiry()
+
+    return ctx, nil
+}
@@ -0,0 +1,46 @@
+package ram_store
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+)
+
+// Create a new `rstore` for a test, which is closed when the test ends.
+func newTestStore(t *testing.T, cfg Config) *rstore {
+    ctx, err := newStore(cfg)
+    if err != nil {
+        t.Fatalf("Failed to create the store: %+v", err)
+    }
+    t.Cleanup(ctx.Close)
+    return ctx
+}
+
+// Send a request to `ctx`, just like the server would, and retrieve its
+// response. `hdr` lists pairs of header names and values.
+func serve(ctx *rstore, method, target, body string, hdr ...string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, target, strings.NewReader(body))
+    for i := 0; i + 1 < len(hdr); i += 2 {
+        req.Header.Set(hdr[i], hdr[i + 1])
+    }
+    w := httptest.NewRecorder()
+
+    urlPath := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+    err := ctx.Handle(w, req, urlPath)
+    if herr, ok := err.(srv_iface.HttpError); ok {
+        srv_iface.ReplyHttpError(herr, w, req)
+    } else if err != nil {
+        w.WriteHeader(http.StatusInternalServerError)
+    }
+    return w
+}
+
+// Check that the response `w` has the status `status`.
+func expectStatus(t *testing.T, desc string, w *httptest.ResponseRecorder, status int) {
+    t.Helper()
+    if w.Code != status {
+        t.Errorf("%s: expected status %d, got %d (%s)", desc, status, w.Code, w.Body.String())
+    }
+}
@@ -0,0 +1,302 @@
+// Persistence of the stored resources. If `Config.SnapshotDir` is set,
+// every resource is saved to `<SnapshotDir>/ram_store.json` (a snapshot),
+// which is restored when the handler is created. Snapshots are taken:
+//
+//   * Periodically, every `Config.SnapshotInterval`, if anything changed;
+//   * Shortly after a resource is modified, if `Config.SnapshotOnChange`
+//     is set. Changes are grouped, so resources that are updated
+//     frequently (e.g., the keyboard's state) don't cause a write for
+//     every update;
+//   * When the server is closed;
+//   * On a POST to `/ram_store/_snapshot`.
+//
+// A POST to `/ram_store/_restore` replaces every stored resource with
+// those in the last snapshot.
+//
+// Restored resources are also limited by `Config.MaxBytes` (e.g., if the
+// limit was lowered since the snapshot was taken), alongside the messages
+// currently retained by the topics. The most recently modified resources
+// are kept, and every resource that doesn't fit is dropped (and logged).
+//
+// Note that, since these are handled by `ram_store`, no resource may be
+// stored in `/ram_store/_snapshot` nor in `/ram_store/_restore`.
+
+package ram_store
+
+import (
+    "bytes"
+    "encoding/json"
+    "github.com/SirGFM/gfm-speedrun-overlay/common"
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync/atomic"
+    "time"
+)
+
+// Path (inside `Prefix`) used to take a snapshot.
+const snapshotPath = "_snapshot"
+
+// Path (inside `Prefix`) used to restore the last snapshot.
+const restorePath = "_restore"
+
+// Name of the snapshot, inside `Config.SnapshotDir`.
+const snapshotFile = "ram_store.json"
+
+// How long after a change the snapshot is taken, if
+// `Config.SnapshotOnChange` is set.
+const changeDelay = time.Second
+
+// A resource, as saved in the snapshot.
+type snapshotEntry struct {
+    // The resource's content type.
+    ContentType string
+    // The resource's content.
+    Body []byte
+    // When the resource was last modified.
+    Modified time.Time
+    // When the resource expires, if it has a time-to-live.
+    Expires *time.Time `json:",omitempty"`
+}
+
+// Retrieve the path to the snapshot.
+func (ctx *rstore) snapshotName() string {
+    return filepath.Join(ctx.snapshotDir, snapshotFile)
+}
+
+// Store the resource `key`, updating its version, or delete it if `d` is
+// nil. Must be called with the write lock held.
+func (ctx *rstore) unsafeSet(key string, d *data) {
+    if old, ok := ctx.store[key]; ok {
+        ctx.size -= int64(old.body.Len())
+    }
+
+    ctx.changes++
+    if d != nil {
+        d.version = ctx.changes
+        d.epoch = ctx.epoch
+        ctx.store[key] = *d
+        ctx.size += int64(d.body.Len())
+    } else {
+        delete(ctx.store, key)
+    }
+
+    ctx.unsafeNotify(key, d)
+    select {
+    case ctx.changed <- struct{}{}:
+    default:
+    }
+}
+
+// Save every stored resource to the snapshot, if anything changed since
+// the last snapshot (or if `force` is set).
+func (ctx *rstore) snapshot(force bool) error {
+    ctx.snapMut.Lock()
+    defer ctx.snapMut.Unlock()
+
+    ctx.rwmut.RLock()
+    changes := ctx.changes
+    if !force && changes == ctx.saved {
+        ctx.rwmut.RUnlock()
+        return nil
+    }
+
+    entries := make(map[string]snapshotEntry, len(ctx.store))
+    for p, data := range ctx.store {
+        entry := snapshotEntry {
+            ContentType: data.contentType,
+            Body: data.body.Bytes(),
+            Modified: data.modified,
+        }
+        if !data.expires.IsZero() {
+            expires := data.expires
+            entry.Expires = &expires
+        }
+        entries[p] = entry
+    }
+    content, err := json.Marshal(entries)
+    ctx.rwmut.RUnlock()
+    if err != nil {
+        return err
+    }
+
+    writefn := func(w io.Writer) error {
+        _, err := w.Write(content)
+        return err
+    }
+    err = common.AtomicSaveFile(ctx.snapshotDir, ctx.snapshotName(), writefn)
+    if err != nil {
+        return err
+    }
+
+    ctx.saved = changes
+    logger.Debugf("web%s: Saved %d resources to '%s'", Prefix, len(entries), ctx.snapshotName())
+    return nil
+}
+
+// Remove the least recently modified entries that don't fit in `maxBytes`,
+// returning their paths.
+func trimEntries(entries map[string]snapshotEntry, maxBytes int64) []string {
+    var paths []string
+    for p := range entries {
+        paths = append(paths, p)
+    }
+    sort.Slice(paths, func(i, j int) bool {
+        a, b := entries[paths[i]].Modified, entries[paths[j]].Modified
+        if !a.Equal(b) {
+            return a.After(b)
+        }
+        return paths[i] < paths[j]
+    })
+
+    var size int64
+    var dropped []string
+    for _, p := range paths {
+        if n := int64(len(entries[p].Body)); size + n <= maxBytes {
+            size += n
+        } else {
+            dropped = append(dropped, p)
+            delete(entries, p)
+        }
+    }
+    return dropped
+}
+
+// Replace every stored resource with those in the snapshot. Returns an
+// error satisfying `os.IsNotExist` if there's no snapshot.
+func (ctx *rstore) restore() error {
+    ctx.snapMut.Lock()
+    defer ctx.snapMut.Unlock()
+
+    content, err := ioutil.ReadFile(ctx.snapshotName())
+    if err != nil {
+        return err
+    }
+
+    var entries map[string]snapshotEntry
+    err = json.Unmarshal(content, &entries)
+    if err != nil {
+        return err
+    }
+
+    // Resources that expired while stored are dropped.
+    now := time.Now()
+    for p, entry := range entries {
+        if entry.Expires != nil && !now.Before(*entry.Expires) {
+            delete(entries, p)
+        }
+    }
+
+    if ctx.maxBytes > 0 {
+        free := ctx.maxBytes - atomic.LoadInt64(&ctx.topicBytes)
+        for _, p := range trimEntries(entries, free) {
+            logger.Warnf("web%s: Dropped '%s' from the snapshot, since it exceeds the limit of %d bytes", Prefix, p, ctx.maxBytes)
+        }
+    }
+
+    ctx.rwmut.Lock()
+    ctx.epoch = newEpoch()
+    for p := range ctx.store {
+        if _, ok := entries[p]; !ok {
+            ctx.unsafeSet(p, nil)
+        }
+    }
+    for p, entry := range entries {
+        d := data {
+            contentType: entry.ContentType,
+            body: bytes.NewBuffer(entry.Body),
+            modified: entry.Modified,
+        }
+        if entry.Expires != nil {
+            d.expires = *entry.Expires
+        }
+        ctx.unsafeSet(p, &d)
+    }
+    ctx.saved = ctx.changes
+    ctx.rwmut.Unlock()
+
+    logger.Debugf("web%s: Restored %d resources from '%s'", Prefix, len(entries), ctx.snapshotName())
+    return nil
+}
+
+// Periodically save the snapshot, until the handler is closed.
+func (ctx *rstore) runSnapshots(interval time.Duration, onChange bool) {
+    defer ctx.wg.Done()
+
+    var tick <-chan time.Time
+    if interval > 0 {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        tick = ticker.C
+    }
+
+    var delay <-chan time.Time
+    for {
+        select {
+        case <-ctx.stop:
+            return
+        case <-ctx.changed:
+            if onChange && delay == nil {
+                delay = time.After(changeDelay)
+            }
+            continue
+        case <-delay:
+            delay = nil
+        case <-tick:
+        }
+
+        err := ctx.snapshot(false)
+        if err != nil {
+            logger.Errorf("web%s: Failed to save the snapshot: %+v", Prefix, err)
+        }
+    }
+}
+
+// Stop taking snapshots and waiting for changes, saving any pending
+// change.
+func (ctx *rstore) shutdown() {
+    ctx.Stop()
+    ctx.wg.Wait()
+    if len(ctx.snapshotDir) == 0 {
+        return
+    }
+
+    err := ctx.snapshot(false)
+    if err != nil {
+        logger.Errorf("web%s: Failed to save the snapshot: %+v", Prefix, err)
+    }
+}
+
+// Handle a request to either `_snapshot` or `_restore`.
+func (r *request) handleSnapshot() error {
+    if r.req.Method != http.MethodPost {
+        return newError(nil, "Invalid method: wanted POST", http.StatusMethodNotAllowed)
+    } else if len(r.ctx.snapshotDir) == 0 {
+        reason := "Snapshots aren't enabled"
+        return newCodedError(nil, CodeSnapshotDisabled, reason, http.StatusNotFound)
+    }
+
+    if r.urlPath[1] == snapshotPath {
+        err := r.ctx.snapshot(true)
+        if err != nil {
+            reason := "Failed to save the snapshot"
+            return newError(err, reason, http.StatusInternalServerError)
+        }
+    } else {
+        err := r.ctx.restore()
+        if os.IsNotExist(err) {
+            reason := "There's no snapshot to be restored"
+            return newCodedError(err, CodeNoSnapshot, reason, http.StatusNotFound)
+        } else if err != nil {
+            reason := "Failed to restore the snapshot"
+            return newError(err, reason, http.StatusInternalServerError)
+        }
+    }
+
+    r.w.WriteHeader(http.StatusNoContent)
+    return nil
+}
@@ -0,0 +1,134 @@
+package ram_store
+
+import (
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+    cfg := Config {
+        SnapshotDir: t.TempDir(),
+    }
+
+    ctx, err := newStore(cfg)
+    if err != nil {
+        t.Fatalf("Failed to create the store: %+v", err)
+    }
+    w := serve(ctx, http.MethodPut, "/ram_store/a/b", `{"a":1}`, "Content-Type", "application/json")
+    expectStatus(t, "PUT a/b", w, http.StatusNoContent)
+    w = serve(ctx, http.MethodPut, "/ram_store/c", "text", "Content-Type", "text/plain", ttlHeader, "1h")
+    expectStatus(t, "PUT c", w, http.StatusNoContent)
+    // Closing the store saves the pending changes
+    ctx.Close()
+
+    ctx = newTestStore(t, cfg)
+    w = serve(ctx, http.MethodGet, "/ram_store/a/b", "")
+    expectStatus(t, "GET a/b", w, http.StatusOK)
+    if body, ctype := w.Body.String(), w.Header().Get("Content-Type"); body != `{"a":1}` || ctype != "application/json" {
+        t.Errorf("GET a/b: expected the saved resource, got '%s' (%s)", body, ctype)
+    }
+    ctx.rwmut.RLock()
+    d := ctx.store["ram_store/c"]
+    ctx.rwmut.RUnlock()
+    if d.expires.IsZero() {
+        t.Errorf("GET c: expected the resource to keep its expiration")
+    }
+
+    // Changes after the snapshot are discarded by a restore
+    w = serve(ctx, http.MethodPost, "/ram_store/_snapshot", "")
+    expectStatus(t, "POST _snapshot", w, http.StatusNoContent)
+    serve(ctx, http.MethodPut, "/ram_store/a/b", "changed", "Content-Type", "text/plain")
+    serve(ctx, http.MethodPut, "/ram_store/d", "new", "Content-Type", "text/plain")
+    w = serve(ctx, http.MethodPost, "/ram_store/_restore", "")
+    expectStatus(t, "POST _restore", w, http.StatusNoContent)
+
+    w = serve(ctx, http.MethodGet, "/ram_store/a/b", "")
+    if body := w.Body.String(); body != `{"a":1}` {
+        t.Errorf("GET a/b: expected the restored resource, got '%s'", body)
+    }
+    w = serve(ctx, http.MethodGet, "/ram_store/d", "")
+    expectStatus(t, "GET d", w, http.StatusNotFound)
+}
+
+func TestSnapshotDisabled(t *testing.T) {
+    ctx := newTestStore(t, Config{})
+
+    w := serve(ctx, http.MethodPost, "/ram_store/_snapshot", "")
+    expectStatus(t, "POST _snapshot", w, http.StatusNotFound)
+}
+
+func TestSnapshotETag(t *testing.T) {
+    cfg := Config {
+        SnapshotDir: t.TempDir(),
+    }
+
+    ctx, err := newStore(cfg)
+    if err != nil {
+        t.Fatalf("Failed to create the store: %+v", err)
+    }
+    serve(ctx, http.MethodPut, "/ram_store/a", `{"a":1}`, "Content-Type", "application/json")
+    w := serve(ctx, http.MethodPost, "/ram_store/_snapshot", "")
+    expectStatus(t, "POST _snapshot", w, http.StatusNoContent)
+    saved := serve(ctx, http.MethodGet, "/ram_store/a", "").Header().Get("ETag")
+
+    // The restored resource has the same version, but a different ETag
+    w = serve(ctx, http.MethodPost, "/ram_store/_restore", "")
+    expectStatus(t, "POST _restore", w, http.StatusNoContent)
+    restored := serve(ctx, http.MethodGet, "/ram_store/a", "").Header().Get("ETag")
+    if restored == saved {
+        t.Errorf("Expected a new ETag after restoring, got %s", restored)
+    }
+    ctx.Close()
+
+    // So does the resource after a restart, which resets the versions
+    ctx = newTestStore(t, cfg)
+    for _, tag := range []string { saved, restored } {
+        w = serve(ctx, http.MethodGet, "/ram_store/a", "", "If-None-Match", tag)
+        expectStatus(t, "GET (old ETag " + tag + ")", w, http.StatusOK)
+
+        w = serve(ctx, http.MethodPatch, "/ram_store/a", `{"b":2}`, "Content-Type", "application/merge-patch+json", "If-Match", tag)
+        expectStatus(t, "PATCH (old ETag " + tag + ")", w, http.StatusPreconditionFailed)
+    }
+
+    tag := serve(ctx, http.MethodGet, "/ram_store/a", "").Header().Get("ETag")
+    if tag == saved || tag == restored {
+        t.Errorf("Expected a new ETag after restarting, got %s", tag)
+    }
+    w = serve(ctx, http.MethodPatch, "/ram_store/a", `{"b":2}`, "Content-Type", "application/merge-patch+json", "If-Match", tag)
+    expectStatus(t, "PATCH (current ETag)", w, http.StatusNoContent)
+}
+
+func TestSnapshotMaxBytes(t *testing.T) {
+    cfg := Config {
+        SnapshotDir: t.TempDir(),
+    }
+
+    ctx, err := newStore(cfg)
+    if err != nil {
+        t.Fatalf("Failed to create the store: %+v", err)
+    }
+    for _, res := range [][2]string { { "a", "12345" }, { "b", "12345" }, { "c", "123" } } {
+        serve(ctx, http.MethodPut, "/ram_store/" + res[0], res[1], "Content-Type", "text/plain")
+        time.Sleep(time.Millisecond)
+    }
+    ctx.Close()
+
+    // Only the most recently modified resources that fit are restored
+    cfg.MaxBytes = 8
+    ctx = newTestStore(t, cfg)
+    for _, tc := range []struct {
+        p string
+        status int
+    } {
+        { "a", http.StatusNotFound },
+        { "b", http.StatusOK },
+        { "c", http.StatusOK },
+    } {
+        w := serve(ctx, http.MethodGet, "/ram_store/" + tc.p, "")
+        expectStatus(t, "GET " + tc.p, w, tc.status)
+    }
+
+    w := serve(ctx, http.MethodPut, "/ram_store/d", "1", "Content-Type", "text/plain")
+    expectStatus(t, "PUT d (over the limit)", w, http.StatusRequestEntityTooLarge)
+}
@@ -0,0 +1,317 @@
+// Watching resources for changes, so clients don't have to poll them
+// constantly.
+//
+// Every resource has a version, which is increased whenever it's modified.
+// The resource's `ETag` is its version prefixed by the store's epoch (which
+// changes whenever the store is created or its snapshot is restored), so
+// tags from before a restart never match. A GET with `If-None-Match`
+// replies with 304 (Not Modified) if the resource didn't change. Adding
+// `?wait=<timeout>` (e.g., `?wait=30s`, or simply `?wait=30` for seconds)
+// turns the request into a long-poll: the reply is delayed until the
+// resource changes (or is created, if it doesn't exist yet), or until the
+// timeout expires. For example:
+//
+//     GET /ram_store/config?wait=60s
+//     If-None-Match: "kq3v1x2a-12"
+//
+// A GET to `/ram_store/_watch/<prefix>` streams changes to every resource
+// in `<prefix>` (including `/ram_store/<prefix>` itself) as Server-Sent
+// Events. The current state of every resource is sent as soon as the
+// client connects. Each event looks like:
+//
+//     event: change
+//     id: 13
+//     data: {"Path":"/ram_store/keyboard/data","Version":13,"Deleted":false,"ContentType":"application/json","Body":"{\"0\":1}"}
+//
+// `Body` is sent as a string, so this is only suitable for textual
+// resources. If a resource changes faster than the client receives the
+// events, only its latest state is sent.
+//
+// Note that, since this is handled by `ram_store`, no resource may be
+// stored in `/ram_store/_watch`.
+
+package ram_store
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    "io"
+    "net/http"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Path (inside `Prefix`) used to stream changes.
+const watchPath = "_watch"
+
+// Longest time a long-poll may wait for a change.
+const maxWait = 5 * time.Minute
+
+// Interval between comments sent to keep idle streams open.
+const keepAliveInterval = 15 * time.Second
+
+// A change to a resource, as reported to watchers.
+type changeEvent struct {
+    // The resource's URL path (e.g., "/ram_store/keyboard/data").
+    Path string
+    // The resource's version after the change.
+    Version uint64
+    // Whether the resource was deleted.
+    Deleted bool
+    // The resource's content type, if it wasn't deleted.
+    ContentType string `json:",omitempty"`
+    // The resource's content, if it wasn't deleted.
+    Body string `json:",omitempty"`
+}
+
+// Someone waiting for changes to either a resource or to every resource
+// within a path.
+type watcher struct {
+    // The watched resource (or path), as stored in `rstore.store`.
+    key string
+    // Whether every resource within `key` is watched.
+    prefix bool
+    // Latest change to every resource that wasn't handled yet.
+    pending map[string]changeEvent
+    // Signals that there are pending changes.
+    signal chan struct{}
+}
+
+// Generate a new epoch, identifying an instance of the store.
+func newEpoch() string {
+    return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// Retrieve the ETag of the resource's version.
+func (d data) etag() string {
+    return `"` + d.epoch + "-" + strconv.FormatUint(d.version, 10) + `"`
+}
+
+// Check whether `header` (an `If-None-Match`) matches `tag`.
+func matchesETag(header, tag string) bool {
+    for _, entry := range strings.Split(header, ",") {
+        entry = strings.TrimPrefix(strings.TrimSpace(entry), "W/")
+        if entry == "*" || entry == tag {
+            return true
+        }
+    }
+    return false
+}
+
+// Check whether the watcher is interested in the resource `key`.
+func (w *watcher) matches(key string) bool {
+    if w.prefix {
+        return inPrefix(key, w.key)
+    }
+    return key == w.key
+}
+
+// Start watching the resource (or path) `key`.
+func (ctx *rstore) watch(key string, prefix bool) *watcher {
+    w := &watcher {
+        key: key,
+        prefix: prefix,
+        pending: make(map[string]changeEvent),
+        signal: make(chan struct{}, 1),
+    }
+
+    ctx.watchMut.Lock()
+    ctx.watchers[w] = struct{}{}
+    ctx.watchMut.Unlock()
+
+    return w
+}
+
+// Stop watching for changes.
+func (ctx *rstore) unwatch(w *watcher) {
+    ctx.watchMut.Lock()
+    delete(ctx.watchers, w)
+    ctx.watchMut.Unlock()
+}
+
+// Report a change to the resource `key` to every interested watcher. `d`
+// must be nil if the resource was deleted. Must be called with the write
+// lock held.
+func (ctx *rstore) unsafeNotify(key string, d *data) {
+    ctx.watchMut.Lock()
+    defer ctx.watchMut.Unlock()
+
+    var ev *changeEvent
+    for w := range ctx.watchers {
+        if !w.matches(key) {
+            continue
+        }
+
+        if ev == nil {
+            ev = &changeEvent {
+                Path: "/" + key,
+                Version: ctx.changes,
+                Deleted: d == nil,
+            }
+            if d != nil {
+                ev.ContentType = d.contentType
+                ev.Body = d.body.String()
+            }
+        }
+
+        w.pending[key] = *ev
+        select {
+        case w.signal <- struct{}{}:
+        default:
+        }
+    }
+}
+
+// Retrieve (and clear) every pending change, sorted by version. Must be
+// called with `watchMut` held.
+func (w *watcher) unsafeTake() []changeEvent {
+    list := make([]changeEvent, 0, len(w.pending))
+    for key, ev := range w.pending {
+        list = append(list, ev)
+        delete(w.pending, key)
+    }
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].Version < list[j].Version
+    })
+    return list
+}
+
+// Retrieve how long the request should wait for a change, from its
+// `wait` query parameter. Returns 0 if it shouldn't wait.
+func (r *request) waitTimeout() (time.Duration, error) {
+    value := r.req.URL.Query().Get("wait")
+    if len(value) == 0 {
+        return 0, nil
+    }
+
+    timeout, err := parseDuration(value)
+    if err != nil {
+        reason := "Invalid 'wait': must be a duration (e.g., '30s') or a number of seconds"
+        return 0, newError(err, reason, http.StatusBadRequest)
+    }
+
+    if timeout > maxWait {
+        timeout = maxWait
+    }
+    return timeout, nil
+}
+
+// Wait until the requested resource doesn't match the request's
+// `If-None-Match` (or until it's created, if it doesn't exist), or until
+// `timeout` expires.
+func (r *request) waitChange(timeout time.Duration) {
+    key := r.URLPath()
+    inm := r.req.Header.Get("If-None-Match")
+
+    // Start watching before checking the resource, so changes between the
+    // check and the wait aren't missed.
+    w := r.ctx.watch(key, false)
+    defer r.ctx.unwatch(w)
+
+    r.ctx.rwmut.RLock()
+    data, ok := r.ctx.store[key]
+    r.ctx.rwmut.RUnlock()
+    if ok && data.expired(time.Now()) {
+        ok = false
+    }
+    if ok && (len(inm) == 0 || !matchesETag(inm, data.etag())) {
+        return
+    }
+
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+
+    select {
+    case <-w.signal:
+    case <-timer.C:
+    case <-r.req.Context().Done():
+    case <-r.ctx.stop:
+    }
+}
+
+// Stream changes to every resource within the requested path until the
+// client disconnects or the handler is closed.
+func (r *request) stream() error {
+    if r.req.Method != http.MethodGet {
+        return newError(nil, "Invalid method: wanted GET", http.StatusMethodNotAllowed)
+    }
+
+    flusher, ok := r.w.(http.Flusher)
+    if !ok {
+        return newError(nil, "Streaming isn't supported", http.StatusInternalServerError)
+    }
+
+    // Watch `ram_store/<prefix>`, skipping `_watch`
+    key := path.Join(append([]string { r.urlPath[0] }, r.urlPath[2:]...)...)
+    w := r.ctx.watch(key, true)
+    defer r.ctx.unwatch(w)
+
+    // Retrieve the current state. Changes up to `since` are already
+    // reflected in it, so they are skipped.
+    var initial []changeEvent
+    r.ctx.rwmut.RLock()
+    since := r.ctx.changes
+    now := time.Now()
+    for k, d := range r.ctx.store {
+        if w.matches(k) && !d.expired(now) {
+            initial = append(initial, changeEvent {
+                Path: "/" + k,
+                Version: d.version,
+                ContentType: d.contentType,
+                Body: d.body.String(),
+            })
+        }
+    }
+    r.ctx.rwmut.RUnlock()
+    sort.Slice(initial, func(i, j int) bool {
+        return initial[i].Version < initial[j].Version
+    })
+
+    hdr := r.w.Header()
+    hdr.Set("Content-Type", "text/event-stream")
+    hdr.Set("Cache-Control", "no-cache")
+    hdr.Set("X-Accel-Buffering", "no")
+    r.w.WriteHeader(http.StatusOK)
+    io.WriteString(r.w, "retry: 1000\n\n")
+
+    send := func(events []changeEvent) {
+        for _, ev := range events {
+            data, err := json.Marshal(&ev)
+            if err != nil {
+                logger.Errorf("web%s: Failed to encode the event: %+v", Prefix, err)
+                continue
+            }
+            fmt.Fprintf(r.w, "event: change\nid: %d\ndata: %s\n\n", ev.Version, data)
+        }
+        flusher.Flush()
+    }
+    send(initial)
+
+    keepAlive := time.NewTicker(keepAliveInterval)
+    defer keepAlive.Stop()
+
+    for {
+        select {
+        case <-r.req.Context().Done():
+            return nil
+        case <-r.ctx.stop:
+            return nil
+        case <-keepAlive.C:
+            io.WriteString(r.w, ": keep-alive\n\n")
+            flusher.Flush()
+        case <-w.signal:
+            r.ctx.watchMut.Lock()
+            events := w.unsafeTake()
+            r.ctx.watchMut.Unlock()
+
+            for len(events) > 0 && events[0].Version <= since {
+                events = events[1:]
+            }
+            send(events)
+        }
+    }
+}
@@ -0,0 +1,64 @@
+package ram_store
+
+import (
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestETag(t *testing.T) {
+    ctx := newTestStore(t, Config{})
+
+    serve(ctx, http.MethodPut, "/ram_store/a", "1", "Content-Type", "text/plain")
+    w := serve(ctx, http.MethodGet, "/ram_store/a", "")
+    expectStatus(t, "GET", w, http.StatusOK)
+    tag := w.Header().Get("ETag")
+    if len(tag) == 0 {
+        t.Fatalf("GET: expected an ETag")
+    }
+
+    w = serve(ctx, http.MethodGet, "/ram_store/a", "", "If-None-Match", tag)
+    expectStatus(t, "GET (matching ETag)", w, http.StatusNotModified)
+    w = serve(ctx, http.MethodGet, "/ram_store/a", "", "If-None-Match", `W/"0", ` + tag)
+    expectStatus(t, "GET (weak ETag list)", w, http.StatusNotModified)
+
+    serve(ctx, http.MethodPut, "/ram_store/a", "2", "Content-Type", "text/plain")
+    w = serve(ctx, http.MethodGet, "/ram_store/a", "", "If-None-Match", tag)
+    expectStatus(t, "GET (stale ETag)", w, http.StatusOK)
+    if newTag := w.Header().Get("ETag"); newTag == tag {
+        t.Errorf("GET (stale ETag): expected a new ETag, got %s", newTag)
+    }
+}
+
+func TestLongPoll(t *testing.T) {
+    ctx := newTestStore(t, Config{})
+
+    serve(ctx, http.MethodPut, "/ram_store/a", "1", "Content-Type", "text/plain")
+    tag := serve(ctx, http.MethodGet, "/ram_store/a", "").Header().Get("ETag")
+
+    // Times out without any change
+    start := time.Now()
+    w := serve(ctx, http.MethodGet, "/ram_store/a?wait=50ms", "", "If-None-Match", tag)
+    expectStatus(t, "GET (timeout)", w, http.StatusNotModified)
+    if elapsed := time.Since(start); elapsed < 50 * time.Millisecond {
+        t.Errorf("GET (timeout): returned after only %s", elapsed)
+    }
+
+    // Returns as soon as the resource changes
+    done := make(chan string)
+    go func() {
+        w := serve(ctx, http.MethodGet, "/ram_store/a?wait=10s", "", "If-None-Match", tag)
+        done <- w.Body.String()
+    }()
+    time.Sleep(20 * time.Millisecond)
+    serve(ctx, http.MethodPut, "/ram_store/a", "2", "Content-Type", "text/plain")
+
+    select {
+    case body := <-done:
+        if body != "2" {
+            t.Errorf("GET (change): expected the new resource, got '%s'", body)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatalf("GET (change): didn't return after the resource changed")
+    }
+}
@@ -0,0 +1,364 @@
+// `reload` notifies connected overlays whenever one of their files
+// changes, so browser sources reload automatically while a layout is being
+// edited.
+//
+// Directories are watched by polling them periodically. Handlers that
+// serve files (e.g., `res` and `tmpl`) register their directories with
+// `Notifier.Watch()`, optionally supplying a callback to invalidate their
+// caches. Changes that don't come from a watched directory (e.g.,
+// switching the active theme) may be reported with `Notifier.Notify()`.
+//
+// Clients are notified through Server-Sent Events:
+//
+//   * GET `/reload`: Stream a `reload` event whenever a file changes, with
+//     the list of changed files as its data (e.g., `{"Files":
+//     ["gfm/dashboard.html"]}`);
+//   * GET `/reload/client.js`: A script that reloads the page whenever it
+//     receives a `reload` event.
+//
+// Thus, enabling automatic reloads in a page only requires adding
+// `<script src="/reload/client.js"></script>` to it.
+
+package reload
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "io"
+    "net/http"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+const Prefix = "/reload"
+
+// Default interval between checks of the watched directories.
+const DefaultInterval = time.Second
+
+// How often a comment is sent to idle clients, so proxies don't close the
+// connection.
+const keepAliveInterval = 15 * time.Second
+
+// Stable, machine-readable codes for the errors reported by this service.
+const (
+    // The response can't be streamed to the client
+    CodeNoStreaming = "no-streaming"
+    // Invalid path for the requested operation
+    CodeInvalidPath = "invalid-path"
+    // The request's method isn't accepted by the service
+    CodeBadMethod = "bad-method"
+)
+
+// Build a new error, identified by one of the `Code*` constants.
+func newError(err error, code, res string, status int) error {
+    return srv_iface.NewCodedHttpError(err, "web"+Prefix, code, res, status)
+}
+
+// Script served by `/reload/client.js`.
+const clientJS = `(function() {
+    var url = '` + Prefix + `';
+    if (document.currentScript && document.currentScript.src) {
+        url = new URL('` + Prefix + `', document.currentScript.src).href;
+    }
+
+    var events = new EventSource(url);
+    events.addEventListener('reload', function() {
+        window.location.reload();
+    });
+})();
+`
+
+// Watches files and reports whenever they change.
+type Notifier interface {
+    // Watch every file in `dirs`. Whenever any watched file changes,
+    // `onChange` (if not nil) is called with the list of changed files,
+    // before the clients are notified.
+    Watch(dirs []string, onChange func(changed []string))
+    // Report that files changed, notifying every client. If `changed` is
+    // empty, every file may have changed.
+    Notify(changed []string)
+}
+
+// Watches directories and streams changes to every connected client.
+type Hub struct {
+    // Interval between checks of the watched directories.
+    interval time.Duration
+    // Every watched directory.
+    dirs []string
+    // State of every watched file, when last checked.
+    files map[string]fileState
+    // Called whenever any file changes.
+    callbacks []func(changed []string)
+    // Every connected client.
+    clients map[chan []string]struct{}
+    // Closed to stop the watcher and every client.
+    stop chan struct{}
+    // Whether the goroutine that checks the directories is running.
+    running bool
+    // Synchronize access to the hub.
+    mut sync.Mutex
+}
+
+// Watch every file in `dirs`, calling `onChange` whenever any of them
+// changes.
+func (h *Hub) Watch(dirs []string, onChange func(changed []string)) {
+    h.mut.Lock()
+    defer h.mut.Unlock()
+
+    var newDirs []string
+    for _, dir := range dirs {
+        dir = filepath.Clean(dir)
+
+        isNew := true
+        for _, d := range h.dirs {
+            if d == dir {
+                isNew = false
+                break
+            }
+        }
+        if isNew {
+            newDirs = append(newDirs, dir)
+        }
+    }
+
+    for p, st := range scan(newDirs) {
+        h.files[p] = st
+    }
+    h.dirs = append(h.dirs, newDirs...)
+    if onChange != nil {
+        h.callbacks = append(h.callbacks, onChange)
+    }
+
+    if !h.running && len(h.dirs) > 0 {
+        h.running = true
+        go h.run()
+    }
+}
+
+// Report that files changed, notifying every client.
+func (h *Hub) Notify(changed []string) {
+    h.mut.Lock()
+    callbacks := append([]func([]string){}, h.callbacks...)
+    h.mut.Unlock()
+
+    if changed == nil {
+        changed = []string{}
+    }
+
+    for _, cb := range callbacks {
+        cb(changed)
+    }
+
+    h.mut.Lock()
+    defer h.mut.Unlock()
+    for c := range h.clients {
+        // Clients reload on any change, so there's no need to queue
+        // changes for slow clients.
+        select {
+        case c <- changed:
+        default:
+        }
+    }
+}
+
+// Periodically check the watched directories.
+func (h *Hub) run() {
+    ticker := time.NewTicker(h.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-h.stop:
+            return
+        case <-ticker.C:
+        }
+
+        h.mut.Lock()
+        dirs := h.dirs
+        old := h.files
+        h.mut.Unlock()
+
+        cur := scan(dirs)
+        changed := diff(old, cur)
+
+        h.mut.Lock()
+        if len(h.dirs) != len(dirs) {
+            // A directory was added while scanning, so its files aren't in
+            // `cur` yet. Check everything again on the next tick.
+            h.mut.Unlock()
+            continue
+        }
+        h.files = cur
+        h.mut.Unlock()
+
+        if len(changed) > 0 {
+            logger.Infof("web%s: Files changed: %v", Prefix, changed)
+            h.Notify(changed)
+        }
+    }
+}
+
+// Add a new client, retrieving the channel where changes are sent.
+func (h *Hub) subscribe() chan []string {
+    c := make(chan []string, 1)
+
+    h.mut.Lock()
+    h.clients[c] = struct{}{}
+    h.mut.Unlock()
+
+    return c
+}
+
+// Remove a client.
+func (h *Hub) unsubscribe(c chan []string) {
+    h.mut.Lock()
+    delete(h.clients, c)
+    h.mut.Unlock()
+}
+
+// Event sent whenever a file changes.
+type reloadEvent struct {
+    // The changed files, relative to their directories.
+    Files []string
+}
+
+// Stream changes to the client until it disconnects or the hub is closed.
+func (h *Hub) stream(w http.ResponseWriter, req *http.Request) error {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        return newError(nil, CodeNoStreaming, "Streaming isn't supported", http.StatusInternalServerError)
+    }
+
+    c := h.subscribe()
+    defer h.unsubscribe(c)
+
+    hdr := w.Header()
+    hdr.Set("Content-Type", "text/event-stream")
+    hdr.Set("Cache-Control", "no-cache")
+    hdr.Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+    io.WriteString(w, "retry: 1000\n\n")
+    flusher.Flush()
+
+    keepAlive := time.NewTicker(keepAliveInterval)
+    defer keepAlive.Stop()
+
+    for {
+        select {
+        case <-req.Context().Done():
+            return nil
+        case <-h.stop:
+            return nil
+        case <-keepAlive.C:
+            io.WriteString(w, ": keep-alive\n\n")
+        case changed := <-c:
+            data, err := json.Marshal(reloadEvent {
+                Files: changed,
+            })
+            if err != nil {
+                logger.Errorf("web%s: Failed to encode the event: %+v", Prefix, err)
+                continue
+            }
+            fmt.Fprintf(w, "event: reload\ndata: %s\n\n", data)
+        }
+        flusher.Flush()
+    }
+}
+
+// Retrieve the path handled by `reload`
+func (*Hub) Prefix() string {
+    return Prefix
+}
+
+// List every other service used by this handler.
+func (*Hub) Dependencies() []string {
+    return nil
+}
+
+// Handle a request to either wait for changes or retrieve the client script.
+func (h *Hub) Handle(w http.ResponseWriter, req *http.Request, urlPath []string) error {
+    if req.Method != http.MethodGet {
+        return newError(nil, CodeBadMethod, "Invalid method: wanted GET", http.StatusMethodNotAllowed)
+    }
+
+    switch {
+    case len(urlPath) == 1:
+        return h.stream(w, req)
+    case len(urlPath) == 2 && urlPath[1] == "client.js":
+        w.Header().Set("Content-Type", "text/javascript")
+        w.WriteHeader(http.StatusOK)
+        io.WriteString(w, clientJS)
+        return nil
+    default:
+        reason := "URL must be either " + Prefix + " or " + Prefix + "/client.js"
+        return newError(nil, CodeInvalidPath, reason, http.StatusNotFound)
+    }
+}
+
+// Retrieve the metrics about the connected clients.
+func (h *Hub) Metrics() []srv_iface.Metric {
+    h.mut.Lock()
+    clients := len(h.clients)
+    files := len(h.files)
+    h.mut.Unlock()
+
+    return []srv_iface.Metric {
+        srv_iface.Metric {
+            Name: "reload_clients",
+            Help: "Number of clients waiting for changes.",
+            Value: float64(clients),
+        },
+        srv_iface.Metric {
+            Name: "reload_watched_files",
+            Help: "Number of watched files.",
+            Value: float64(files),
+        },
+    }
+}
+
+// Disconnect every client, so the `Hub` may be closed.
+func (h *Hub) Stop() {
+    h.Close()
+}
+
+// Stop watching the directories and disconnect every client.
+func (h *Hub) Close() {
+    h.mut.Lock()
+    defer h.mut.Unlock()
+
+    select {
+    case <-h.stop:
+    default:
+        close(h.stop)
+    }
+}
+
+// Configure the reload `Hub`.
+type Config struct {
+    // Interval between checks of the watched directories. Defaults to
+    // `DefaultInterval`.
+    Interval time.Duration
+}
+
+// Register a reload `Hub` in the `Server`. The returned `Hub` should be
+// supplied as the `Notifier` of the handlers that serve files.
+func GetHandleFromConfig(srv srv_iface.Server, cfg Config) (*Hub, error) {
+    h := &Hub {
+        interval: cfg.Interval,
+        files: make(map[string]fileState),
+        clients: make(map[chan []string]struct{}),
+        stop: make(chan struct{}),
+    }
+    if h.interval <= 0 {
+        h.interval = DefaultInterval
+    }
+
+    err := srv.AddHandler(h)
+    if err != nil {
+        return nil, err
+    }
+    return h, nil
+}
@@ -0,0 +1,79 @@
+// Polling watcher used by the `Hub` to detect changed files.
+
+package reload
+
+import (
+    "io/fs"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// State of a file when it was last checked.
+type fileState struct {
+    // Path to the file, relative to its watched directory.
+    rel string
+    // When the file was last modified.
+    modtime time.Time
+    // Size of the file, in bytes.
+    size int64
+}
+
+// List every file (recursively) in `dirs`, indexed by their absolute path.
+// Hidden files and directories (i.e., starting with a '.') are ignored.
+func scan(dirs []string) map[string]fileState {
+    files := make(map[string]fileState)
+
+    for _, dir := range dirs {
+        filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+            if err != nil {
+                // Ignore files removed while scanning the directory
+                return nil
+            } else if p != dir && strings.HasPrefix(d.Name(), ".") {
+                if d.IsDir() {
+                    return filepath.SkipDir
+                }
+                return nil
+            } else if d.IsDir() {
+                return nil
+            }
+
+            fi, err := d.Info()
+            if err != nil {
+                return nil
+            }
+
+            rel, err := filepath.Rel(dir, p)
+            if err != nil {
+                rel = p
+            }
+            files[p] = fileState {
+                rel: filepath.ToSlash(rel),
+                modtime: fi.ModTime(),
+                size: fi.Size(),
+            }
+            return nil
+        })
+    }
+
+    return files
+}
+
+// List every file that was added, removed or modified between `old` and
+// `cur`.
+func diff(old, cur map[string]fileState) []string {
+    var changed []string
+
+    for p, st := range cur {
+        if prev, ok := old[p]; !ok || prev.size != st.size || !prev.modtime.Equal(st.modtime) {
+            changed = append(changed, st.rel)
+        }
+    }
+    for p, st := range old {
+        if _, ok := cur[p]; !ok {
+            changed = append(changed, st.rel)
+        }
+    }
+
+    return changed
+}
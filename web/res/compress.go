@@ -0,0 +1,241 @@
+// Compression and caching of the served resources.
+//
+// If the client accepts it, a precompressed sibling of the requested file
+// (e.g., "main.js.br" or "main.js.gz") is served instead of the file
+// itself. Otherwise, text-based files are compressed with gzip on the fly,
+// and kept in memory so they are only compressed once. Since Go's standard
+// library can't encode brotli, it's only served if precompressed.
+//
+// Every file is also identified by an ETag, based on the hash of its
+// content, so clients may skip downloading files that didn't change.
+
+package res
+
+import (
+    "bytes"
+    "compress/gzip"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "github.com/SirGFM/gfm-speedrun-overlay/common"
+    "io/fs"
+    "io/ioutil"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "unicode"
+)
+
+// Files smaller than this aren't worth compressing.
+const minCompressSize = 256
+
+// Maximum number of bytes kept in the cache of compressed files.
+const maxCacheSize = 64 * 1024 * 1024
+
+// Default Cache-Control for hashed assets.
+const DefaultHashedCacheControl = "public, max-age=31536000, immutable"
+
+// Default pattern for hashed assets: Next.js' static files and files with
+// a hex hash in their names (e.g., "main.0123abcd.js"). The hash is
+// captured in the group "hash", so dates and other numbers (e.g.,
+// "logo-20260101.png") may be rejected by `isHashed`.
+const DefaultHashedPattern = `(^|/)_next/static/|[.-](?P<hash>[0-9a-f]{8,})\.[a-z0-9]+$`
+
+// Name of the group, in a pattern for hashed assets, that captures the
+// hash itself.
+const hashGroup = "hash"
+
+// Check whether `filePath` is a hashed asset, according to `pattern`. If
+// the pattern has a "hash" group, the matched hash must have at least one
+// letter, so numbers aren't mistaken for hashes.
+func isHashed(pattern *regexp.Regexp, filePath string) bool {
+    m := pattern.FindStringSubmatch(filePath)
+    if m == nil {
+        return false
+    }
+
+    idx := pattern.SubexpIndex(hashGroup)
+    if idx < 0 || len(m[idx]) == 0 {
+        return true
+    }
+    return strings.IndexFunc(m[idx], unicode.IsLetter) >= 0
+}
+
+// An encoding that may be used in a precompressed sibling.
+type encoding struct {
+    // The name used in the `Accept-Encoding` and `Content-Encoding`
+    // headers.
+    name string
+    // Extension of the precompressed sibling.
+    ext string
+}
+
+// Precompressed siblings, in order of preference.
+var precompressed = []encoding {
+    encoding { "br", ".br" },
+    encoding { "gzip", ".gz" },
+}
+
+// A location where resources may be looked up.
+type source struct {
+    // Directory in the local file system. Ignored if `fs` is set.
+    dir string
+    // A generic file system (e.g., an `embed.FS`).
+    fs fs.FS
+    // Maps files into their content type.
+    mime *common.MimeRegistry
+}
+
+// Open a file in the source, retrieving its content type.
+func (s source) open(filePath string) (fs.File, string, error) {
+    if s.fs != nil {
+        return s.mime.OpenFileFS(s.fs, filePath)
+    }
+    return s.mime.OpenFile(s.dir, filePath)
+}
+
+// File systems whose content may be swapped at runtime (e.g., a
+// `theme.Manager`) report a version that changes whenever that happens.
+type versionedFS interface {
+    Version() string
+}
+
+// Retrieve a key that uniquely identifies `filePath` in this source.
+func (s source) key(filePath string) string {
+    if vfs, ok := s.fs.(versionedFS); ok {
+        return fmt.Sprintf("fs:%p:%s:%s", s.fs, vfs.Version(), filePath)
+    } else if s.fs != nil {
+        return fmt.Sprintf("fs:%p:%s", s.fs, filePath)
+    }
+    return filepath.Join(s.dir, filePath)
+}
+
+// Check whether the client accepts the encoding `enc`, based on the
+// request's `Accept-Encoding` header.
+func acceptsEncoding(header, enc string) bool {
+    accepted := false
+    explicit := false
+
+    for _, entry := range strings.Split(header, ",") {
+        fields := strings.Split(entry, ";")
+        name := strings.ToLower(strings.TrimSpace(fields[0]))
+        if name != enc && name != "*" {
+            continue
+        }
+
+        ok := true
+        for _, param := range fields[1:] {
+            param = strings.TrimSpace(param)
+            if strings.HasPrefix(param, "q=") {
+                q, err := strconv.ParseFloat(param[2:], 64)
+                ok = (err == nil && q > 0)
+            }
+        }
+
+        // An explicit entry overrides the wildcard
+        if name == enc {
+            accepted = ok
+            explicit = true
+        } else if !explicit {
+            accepted = ok
+        }
+    }
+
+    return accepted
+}
+
+// Check whether files of the given content type are worth compressing.
+func isCompressible(ctype string) bool {
+    if strings.HasPrefix(ctype, "text/") {
+        return true
+    }
+
+    for _, sub := range []string { "javascript", "json", "xml", "svg", "wasm" } {
+        if strings.Contains(ctype, sub) {
+            return true
+        }
+    }
+    return false
+}
+
+// A file whose hash (and possibly compressed content) has been cached.
+type cachedFile struct {
+    // Modification time of the file when it was cached.
+    modtime time.Time
+    // Size of the file when it was cached.
+    size int64
+    // ETag derived from the file's content (without quotes).
+    etag string
+    // The file compressed with gzip, if it's worth compressing.
+    gz []byte
+}
+
+// Cache every served file.
+type fileCache struct {
+    // The cached files, indexed by their `source.key()`.
+    files map[string]*cachedFile
+    // Number of compressed bytes in the cache.
+    size int
+    // Synchronize access to the cache.
+    mut sync.Mutex
+}
+
+// Retrieve the cached information about a file, updating it if the file
+// changed. If the file had to be read, its content is also returned.
+func (c *fileCache) get(key string, f fs.File, fi fs.FileInfo, compress bool) (*cachedFile, []byte, error) {
+    c.mut.Lock()
+    entry, ok := c.files[key]
+    c.mut.Unlock()
+    if ok && entry.size == fi.Size() && entry.modtime.Equal(fi.ModTime()) {
+        return entry, nil, nil
+    }
+
+    content, err := ioutil.ReadAll(f)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    hash := sha256.Sum256(content)
+    entry = &cachedFile {
+        modtime: fi.ModTime(),
+        size: fi.Size(),
+        etag: hex.EncodeToString(hash[:16]),
+    }
+
+    if compress && len(content) >= minCompressSize {
+        var buf bytes.Buffer
+        w, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+        w.Write(content)
+        w.Close()
+
+        // Only keep the compressed file if it's actually smaller
+        if buf.Len() < len(content) {
+            entry.gz = buf.Bytes()
+        }
+    }
+
+    c.mut.Lock()
+    defer c.mut.Unlock()
+    if old, ok := c.files[key]; ok {
+        c.size -= len(old.gz)
+        delete(c.files, key)
+    }
+    if c.size + len(entry.gz) <= maxCacheSize {
+        c.files[key] = entry
+        c.size += len(entry.gz)
+    }
+
+    return entry, content, nil
+}
+
+// Remove every file from the cache.
+func (c *fileCache) clear() {
+    c.mut.Lock()
+    defer c.mut.Unlock()
+
+    c.files = make(map[string]*cachedFile)
+    c.size = 0
+}
@@ -0,0 +1,99 @@
+package res
+
+import (
+    "net/http"
+    "regexp"
+    "testing"
+    "testing/fstest"
+)
+
+func TestHashedPattern(t *testing.T) {
+    pattern := regexp.MustCompile(DefaultHashedPattern)
+
+    for _, tc := range []struct {
+        filePath string
+        hashed bool
+    } {
+        { "main.0123abcd.js", true },
+        { "js/main-deadbeef01.css", true },
+        { "_next/static/chunks/app.js", true },
+        { "a/_next/static/x.js", true },
+        { "logo-20260101.png", false },
+        { "frame.12345678.png", false },
+        { "main.0123abc.js", false },
+        { "main.js", false },
+        { "main.0123ABCD.js", false },
+        { "not_next/static/x.js", false },
+    } {
+        if got := isHashed(pattern, tc.filePath); got != tc.hashed {
+            t.Errorf("'%s': expected hashed to be %v, got %v", tc.filePath, tc.hashed, got)
+        }
+    }
+
+    // Patterns without a "hash" group accept any match
+    if !isHashed(regexp.MustCompile(`-[0-9]{8}\.png$`), "logo-20260101.png") {
+        t.Errorf("Expected a custom pattern to accept numbers")
+    }
+}
+
+func TestPrecompressedSiblings(t *testing.T) {
+    r := newTestRes(t, Config{}, fstest.MapFS {
+        "both.js": { Data: []byte("plain") },
+        "both.js.br": { Data: []byte("brotli") },
+        "both.js.gz": { Data: []byte("gzip") },
+        "gz.js": { Data: []byte("plain") },
+        "gz.js.gz": { Data: []byte("gzip") },
+        "img.png": { Data: []byte("plain") },
+        "img.png.gz": { Data: []byte("gzip") },
+    })
+
+    for _, tc := range []struct {
+        filePath string
+        accept string
+        body string
+        encoding string
+    } {
+        { "both.js", "gzip, br", "brotli", "br" },
+        { "both.js", "br;q=0, gzip", "gzip", "gzip" },
+        { "both.js", "gzip", "gzip", "gzip" },
+        { "both.js", "*", "brotli", "br" },
+        { "both.js", "*, br;q=0", "gzip", "gzip" },
+        { "both.js", "", "plain", "" },
+        { "both.js", "identity", "plain", "" },
+        { "gz.js", "br", "plain", "" },
+        { "gz.js", "br, gzip", "gzip", "gzip" },
+        // Files that aren't compressible never use their siblings
+        { "img.png", "gzip", "plain", "" },
+    } {
+        w := get(r, tc.filePath, "Accept-Encoding", tc.accept)
+        if w.Code != http.StatusOK {
+            t.Errorf("%s (%s): expected status %d, got %d", tc.filePath, tc.accept, http.StatusOK, w.Code)
+        } else if got := w.Body.String(); got != tc.body {
+            t.Errorf("%s (%s): expected '%s', got '%s'", tc.filePath, tc.accept, tc.body, got)
+        } else if got := w.Header().Get("Content-Encoding"); got != tc.encoding {
+            t.Errorf("%s (%s): expected encoding '%s', got '%s'", tc.filePath, tc.accept, tc.encoding, got)
+        }
+    }
+}
+
+func TestHashedCacheControl(t *testing.T) {
+    r := newTestRes(t, Config {
+        CacheControl: "no-cache",
+    }, fstest.MapFS {
+        "main.0123abcd.js": { Data: []byte("a") },
+        "logo-20260101.png": { Data: []byte("b") },
+    })
+
+    for _, tc := range []struct {
+        filePath string
+        cache string
+    } {
+        { "main.0123abcd.js", DefaultHashedCacheControl },
+        { "logo-20260101.png", "no-cache" },
+    } {
+        w := get(r, tc.filePath)
+        if got := w.Header().Get("Cache-Control"); got != tc.cache {
+            t.Errorf("%s: expected Cache-Control '%s', got '%s'", tc.filePath, tc.cache, got)
+        }
+    }
+}
@@ -1,14 +1,39 @@
 // `res` serve extra resources, which are packed as static files located
 // in a `res/` directory.
+//
+// Resources may also be read from a `fs.FS` (e.g., an `embed.FS`), so the
+// default resources may be shipped within the binary. Files on disk take
+// precedence over the ones in the `fs.FS`, so any embedded file may be
+// overridden by a local one. Files in the active theme (see `web/theme`)
+// take precedence over everything else.
+//
+// Text-based files are served compressed, if the client accepts it (see
+// `compress.go`).
+//
+// To support single-page applications, `res` may be configured to serve
+// an index page for every unknown path without an extension, so the
+// application handles the route on the client. Missing assets (i.e., paths
+// with an extension) still result in a 404, which may be served with a
+// custom page.
+//
+// If a `Notifier` (see `web/reload`) is supplied, the resource directories
+// are watched, and connected overlays are reloaded whenever a file changes.
 
 package res
 
 import (
+    "bytes"
+    "fmt"
     "github.com/SirGFM/gfm-speedrun-overlay/common"
+    "github.com/SirGFM/gfm-speedrun-overlay/web/reload"
     srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "io"
+    "io/fs"
+    "io/ioutil"
     "net/http"
     "os"
     "path"
+    "regexp"
     "time"
 )
 
@@ -20,13 +45,27 @@ func newError(err error, res string, status int) error {
 }
 
 type res struct{
-    // List of directories where resources may be located
-    entryPoints []string
+    // Locations where resources may be located, in order of priority.
+    sources []source
     // Page used when the requested URL is empty
     defaultPage string
     // Default extension, if the requested URL doesn't have any **and**
     // no file was found.
     defaultExtension string
+    // Whether files should never be sent compressed.
+    disableCompression bool
+    // Cache-Control sent for files that aren't hashed.
+    cacheControl string
+    // Cache-Control sent for hashed files.
+    hashedCacheControl string
+    // Pattern that identifies hashed files.
+    hashedPattern *regexp.Regexp
+    // Hash and compressed content of the served files.
+    cache fileCache
+    // Page served for unknown paths without an extension, if any.
+    spaIndex string
+    // Page served, with a 404 status, if the file isn't found.
+    notFoundPage string
 }
 
 // Retrieve the path handled by `res`
@@ -51,20 +90,144 @@ type fileRequest struct {
     filePath string
 }
 
+// Reply that the requested file couldn't be read.
+func (ctx fileRequest) replyReadError() {
+    srv_iface.ReplyStatus("web"+Prefix, http.StatusInternalServerError, "Couldn't read the requested file", ctx.w)
+}
+
+// Send the content of an opened file. If `content` is nil, it's read from
+// `f`.
+func (ctx fileRequest) serveContent(f fs.File, content []byte, modtime time.Time) {
+    // `http.ServeContent` requires seeking the file, which isn't
+    // guaranteed by every `fs.FS`. If that's the case, read it into
+    // memory.
+    var rs io.ReadSeeker
+    if content != nil {
+        rs = bytes.NewReader(content)
+    } else if seeker, ok := f.(io.ReadSeeker); ok {
+        if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+            ctx.replyReadError()
+            return
+        }
+        rs = seeker
+    } else {
+        data, err := ioutil.ReadAll(f)
+        if err != nil {
+            ctx.replyReadError()
+            return
+        }
+        rs = bytes.NewReader(data)
+    }
+
+    http.ServeContent(ctx.w, ctx.req, "", modtime, rs)
+}
+
+// Try to send a precompressed sibling of the requested file, returning
+// whether it was sent.
+func (ctx fileRequest) sendPrecompressed(src source) bool {
+    accept := ctx.req.Header.Get("Accept-Encoding")
+
+    for _, enc := range precompressed {
+        if !acceptsEncoding(accept, enc.name) {
+            continue
+        }
+
+        f, _, err := src.open(ctx.filePath + enc.ext)
+        if err != nil {
+            continue
+        }
+        defer f.Close()
+
+        fi, err := f.Stat()
+        if err != nil {
+            continue
+        }
+
+        h := ctx.w.Header()
+        h.Set("Content-Encoding", enc.name)
+        h.Set("ETag", fmt.Sprintf("\"%x-%x-%s\"", fi.Size(), fi.ModTime().UnixNano(), enc.name))
+        ctx.serveContent(f, nil, fi.ModTime())
+        return true
+    }
+
+    return false
+}
+
+// Send the content of an opened file, compressing it if possible.
+func (ctx fileRequest) send(src source, f fs.File, ctype string) {
+    fi, err := f.Stat()
+    if err != nil {
+        ctx.replyReadError()
+        return
+    }
+
+    h := ctx.w.Header()
+    h.Set("Content-Type", ctype)
+    if ctx.r.hashedPattern != nil && isHashed(ctx.r.hashedPattern, ctx.filePath) {
+        h.Set("Cache-Control", ctx.r.hashedCacheControl)
+    } else if len(ctx.r.cacheControl) > 0 {
+        h.Set("Cache-Control", ctx.r.cacheControl)
+    }
+
+    compress := !ctx.r.disableCompression && isCompressible(ctype)
+    if compress {
+        h.Add("Vary", "Accept-Encoding")
+        if ctx.sendPrecompressed(src) {
+            return
+        }
+    }
+
+    entry, content, err := ctx.r.cache.get(src.key(ctx.filePath), f, fi, compress)
+    if err != nil {
+        ctx.replyReadError()
+        return
+    }
+
+    accept := ctx.req.Header.Get("Accept-Encoding")
+    if entry.gz != nil && acceptsEncoding(accept, "gzip") {
+        h.Set("Content-Encoding", "gzip")
+        h.Set("ETag", "\"" + entry.etag + "-gzip\"")
+        ctx.serveContent(nil, entry.gz, fi.ModTime())
+    } else {
+        h.Set("ETag", "\"" + entry.etag + "\"")
+        ctx.serveContent(f, content, fi.ModTime())
+    }
+}
+
+// Send the configured 404 page with a 404 status, returning whether it was
+// found.
+func (ctx fileRequest) serveNotFound() bool {
+    for _, src := range ctx.r.sources {
+        f, ctype, err := src.open(ctx.r.notFoundPage)
+        if err != nil {
+            continue
+        }
+
+        content, err := ioutil.ReadAll(f)
+        f.Close()
+        if err != nil {
+            ctx.replyReadError()
+            return true
+        }
+
+        h := ctx.w.Header()
+        h.Set("Content-Type", ctype)
+        h.Set("Cache-Control", "no-cache")
+        ctx.w.WriteHeader(http.StatusNotFound)
+        ctx.w.Write(content)
+        return true
+    }
+
+    return false
+}
+
 // Server the requested file, returning whether or not it was found.
 func (ctx fileRequest) serveFile() bool {
-    // Server the file if it's in any of the listed directories
-    for _, dir := range ctx.r.entryPoints {
-        if f, ctype, err := common.OpenFile(dir, ctx.filePath); err == nil {
+    // Server the file if it's in any of the listed locations
+    for _, src := range ctx.r.sources {
+        if f, ctype, err := src.open(ctx.filePath); err == nil {
             defer f.Close()
-
-            var modtime time.Time
-            if fi, err := f.Stat(); err == nil {
-                modtime = fi.ModTime()
-            }
-
-            ctx.w.Header().Set("Content-Type", ctype)
-            http.ServeContent(ctx.w, ctx.req, "", modtime, f)
+            ctx.send(src, f, ctype)
             return true
         }
     }
@@ -111,24 +274,73 @@ func (r *res) Handle(w http.ResponseWriter, req *http.Request, urlPath []string)
         }
     }
 
+    // In SPA mode, paths without an extension are routes in the
+    // application, so send its index and let the client handle it.
+    if len(path.Ext(filePath)) == 0 && len(r.spaIndex) != 0 {
+        ctx.filePath = r.spaIndex
+        if ctx.serveFile() {
+            // File served successfully
+            return nil
+        }
+    }
+
+    if len(r.notFoundPage) != 0 && ctx.serveNotFound() {
+        // 404 page served successfully
+        return nil
+    }
+
     // Couldn't find the file
     reason := "Couldn't find file '" + filePath + "'"
     return newError(nil, reason, http.StatusNotFound)
 }
 
-// Close resources associated with the `res` (i.e, nothing)
-func (*res) Close() {
+// Close resources associated with the `res` (i.e., its cache)
+func (r *res) Close() {
+    r.cache.clear()
 }
 
 // Configure the `res` server.
 type Config struct {
     // List of directories where resources may be located
     Dirs []string
+    // File system (e.g., an `embed.FS`) searched if the resource isn't
+    // in any directory. If set, the `res/` directory in the current
+    // working directory becomes optional.
+    FS fs.FS
+    // File system searched before every other location (e.g., a
+    // `theme.Manager`).
+    Theme fs.FS
     // Page used when the requested URL is empty
     DefaultPage string
     // Default extension, if the requested URL doesn't have any **and**
     // no file was found.
     DefaultExtension string
+    // Never send files compressed, even if the client accepts it.
+    DisableCompression bool
+    // Cache-Control sent for files that aren't hashed. If empty, the
+    // header isn't sent.
+    CacheControl string
+    // Cache-Control sent for hashed files. Defaults to
+    // `DefaultHashedCacheControl`.
+    HashedCacheControl string
+    // Regular expression that identifies hashed files, which never change
+    // and thus may be cached indefinitely. If it has a group named "hash",
+    // the captured hash must have at least one letter. Defaults to
+    // `DefaultHashedPattern`.
+    HashedPattern string
+    // Content type for each extension (e.g., ".txt": "text/plain"),
+    // overriding the default ones.
+    MimeTypes map[string]string
+    // Page (e.g., "index.html") served for unknown paths without an
+    // extension, so client-side routes in single-page applications may be
+    // accessed directly. If empty, these paths result in a 404.
+    SPAIndex string
+    // Page (e.g., "404.html") served, with a 404 status, if the requested
+    // file isn't found.
+    NotFoundPage string
+    // Watches the resource directories, notifying the clients whenever any
+    // file changes. If nil, the directories aren't watched.
+    Notifier reload.Notifier
 }
 
 // Register a `res` handler in the `Server`.
@@ -143,28 +355,82 @@ func GetHandle(srv srv_iface.Server, dirs []string) error {
 // Register a `res` handler in the `Server`. The resource is configured
 // based on the supplied `cfg`.
 func GetHandleFromConfig(srv srv_iface.Server, cfg Config) error {
+    r, err := newRes(cfg)
+    if err != nil {
+        return err
+    }
+
+    srv.AddHandler(r)
+    return nil
+}
+
+// Create a new `res`, configured by `cfg`.
+func newRes(cfg Config) (*res, error) {
     var r res
 
     cwd, err := os.Getwd()
     if err != nil {
         reason := "Failed to the the current working directory"
-        return newError(err, reason, http.StatusInternalServerError)
+        return nil, newError(err, reason, http.StatusInternalServerError)
     }
 
     // Get a list with possible resources
-    r.entryPoints, err = common.ListToAbsolutePath(nil, path.Join(cwd, "res"))
-    if err != nil {
+    entryPoints, err := common.ListToAbsolutePath(nil, path.Join(cwd, "res"))
+    if err != nil && cfg.FS == nil {
         reason := "Failed to create the list of resource directories"
-        return newError(err, reason, http.StatusInternalServerError)
+        return nil, newError(err, reason, http.StatusInternalServerError)
     }
-    r.entryPoints, err = common.ListToAbsolutePath(r.entryPoints, cfg.Dirs...)
+    entryPoints, err = common.ListToAbsolutePath(entryPoints, cfg.Dirs...)
     if err != nil {
         reason := "Failed to create the list of resource directories"
-        return newError(err, reason, http.StatusInternalServerError)
+        return nil, newError(err, reason, http.StatusInternalServerError)
+    }
+    mime := common.NewMimeRegistry(cfg.MimeTypes)
+    if cfg.Theme != nil {
+        r.sources = append(r.sources, source {
+            fs: cfg.Theme,
+            mime: mime,
+        })
+    }
+    for _, dir := range entryPoints {
+        r.sources = append(r.sources, source {
+            dir: dir,
+            mime: mime,
+        })
     }
+    if cfg.FS != nil {
+        r.sources = append(r.sources, source {
+            fs: cfg.FS,
+            mime: mime,
+        })
+    }
+
+    pattern := cfg.HashedPattern
+    if len(pattern) == 0 {
+        pattern = DefaultHashedPattern
+    }
+    r.hashedPattern, err = regexp.Compile(pattern)
+    if err != nil {
+        reason := "Invalid pattern for hashed files"
+        return nil, newError(err, reason, http.StatusInternalServerError)
+    }
+    r.hashedCacheControl = cfg.HashedCacheControl
+    if len(r.hashedCacheControl) == 0 {
+        r.hashedCacheControl = DefaultHashedCacheControl
+    }
+    r.cacheControl = cfg.CacheControl
+    r.disableCompression = cfg.DisableCompression
+    r.cache.files = make(map[string]*cachedFile)
     r.defaultPage = cfg.DefaultPage
     r.defaultExtension = cfg.DefaultExtension
+    r.spaIndex = cfg.SPAIndex
+    r.notFoundPage = cfg.NotFoundPage
 
-    srv.AddHandler(&r)
-    return nil
+    if cfg.Notifier != nil {
+        cfg.Notifier.Watch(entryPoints, func([]string) {
+            r.cache.clear()
+        })
+    }
+
+    return &r, nil
 }
@@ -0,0 +1,117 @@
+package res
+
+import (
+    "net/http"
+    "net/http/httptest"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "strings"
+    "testing"
+    "testing/fstest"
+)
+
+// Create a `res` that only serves files from `files`.
+func newTestRes(t *testing.T, cfg Config, files fstest.MapFS) *res {
+    cfg.FS = files
+    r, err := newRes(cfg)
+    if err != nil {
+        t.Fatalf("Failed to create the handler: %+v", err)
+    }
+    return r
+}
+
+// Request `filePath` from `r`, retrieving its response.
+func get(r *res, filePath string, hdr ...string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(http.MethodGet, Prefix + "/" + filePath, nil)
+    for i := 0; i + 1 < len(hdr); i += 2 {
+        req.Header.Set(hdr[i], hdr[i + 1])
+    }
+    w := httptest.NewRecorder()
+
+    urlPath := append([]string { Prefix[1:] }, strings.Split(filePath, "/")...)
+    err := r.Handle(w, req, urlPath)
+    if herr, ok := err.(srv_iface.HttpError); ok {
+        srv_iface.ReplyHttpError(herr, w, req)
+    } else if err != nil {
+        w.WriteHeader(http.StatusInternalServerError)
+    }
+    return w
+}
+
+func TestContentType(t *testing.T) {
+    r := newTestRes(t, Config {
+        MimeTypes: map[string]string { "js": "application/javascript" },
+    }, fstest.MapFS {
+        "main.js": { Data: []byte("x") },
+        "style.css": { Data: []byte("x") },
+        "page": { Data: []byte("<!DOCTYPE html><p>x</p>") },
+    })
+
+    for _, tc := range []struct {
+        filePath string
+        ctype string
+    } {
+        { "main.js", "application/javascript" },
+        { "style.css", "text/css" },
+        { "page", "text/html; charset=utf-8" },
+    } {
+        w := get(r, tc.filePath)
+        if got := w.Header().Get("Content-Type"); got != tc.ctype {
+            t.Errorf("%s: expected '%s', got '%s'", tc.filePath, tc.ctype, got)
+        }
+    }
+}
+
+func TestSPAFallback(t *testing.T) {
+    files := fstest.MapFS {
+        "index.html": { Data: []byte("index") },
+        "404.html": { Data: []byte("not found") },
+        "about.html": { Data: []byte("about") },
+        "app.js": { Data: []byte("app") },
+    }
+    r := newTestRes(t, Config {
+        DefaultPage: "index.html",
+        DefaultExtension: ".html",
+        SPAIndex: "index.html",
+        NotFoundPage: "404.html",
+    }, files)
+
+    for _, tc := range []struct {
+        filePath string
+        status int
+        body string
+    } {
+        { "", http.StatusOK, "index" },
+        { "app.js", http.StatusOK, "app" },
+        // The default extension takes precedence over the SPA index
+        { "about", http.StatusOK, "about" },
+        { "users/42", http.StatusOK, "index" },
+        { "settings", http.StatusOK, "index" },
+        // Missing assets are never sent the index
+        { "missing.js", http.StatusNotFound, "not found" },
+        { "img/missing.png", http.StatusNotFound, "not found" },
+    } {
+        w := get(r, tc.filePath)
+        if w.Code != tc.status || w.Body.String() != tc.body {
+            t.Errorf("'%s': expected %d '%s', got %d '%s'", tc.filePath, tc.status, tc.body, w.Code, w.Body.String())
+        } else if tc.status == http.StatusNotFound && w.Header().Get("Cache-Control") != "no-cache" {
+            t.Errorf("'%s': expected the 404 page not to be cached", tc.filePath)
+        }
+    }
+
+    // Without the SPA index or the 404 page, unknown paths are plain 404s
+    r = newTestRes(t, Config{}, files)
+    for _, filePath := range []string { "users/42", "missing.js" } {
+        w := get(r, filePath)
+        if w.Code != http.StatusNotFound || strings.Contains(w.Body.String(), "not found") {
+            t.Errorf("'%s': expected a plain 404, got %d '%s'", filePath, w.Code, w.Body.String())
+        }
+    }
+
+    // If the 404 page is missing, the error is sent instead
+    r = newTestRes(t, Config {
+        NotFoundPage: "missing.html",
+    }, files)
+    if w := get(r, "missing.js"); w.Code != http.StatusNotFound {
+        t.Errorf("Missing 404 page: expected status %d, got %d", http.StatusNotFound, w.Code)
+    }
+}
@@ -77,8 +77,16 @@
 //             // Same structure as Splits
 //         ],
 //         "Current": 1,
+//         "Attempts": 12,
 //     }
 //
+// `Attempts` counts how many times a run of this game/category (with the
+// same splits) was started, including the current one.
+//
+// Instead of a token, `latest` may be used to refer to the most recently
+// created run (e.g., `splits/latest`), so local tools don't need to know
+// the token.
+//
 // ## POST
 //
 // POST requests should be used to control a previously initialized run.
@@ -147,6 +155,49 @@ import (
 
 const Prefix = "/run"
 
+// Token that refers to the most recently created run.
+const LatestToken = "latest"
+
+// Stable, machine-readable codes for the errors reported by this service.
+const (
+    // Couldn't encode the run as a JSON
+    CodeEncodeRun = "encode-run"
+    // Couldn't save the run to a file
+    CodeSaveRun = "save-run"
+    // Couldn't save the best run to a file
+    CodeSaveBest = "save-best"
+    // Couldn't create the first best run
+    CodeCreateBest = "create-best"
+    // Couldn't open the best run
+    CodeOpenBest = "open-best"
+    // Couldn't decode the best run
+    CodeDecodeBest = "decode-best"
+    // Couldn't retrieve the best run
+    CodeGetBest = "get-best"
+    // Couldn't create the directory for the runs
+    CodeCreateRunsDir = "create-runs-dir"
+    // Couldn't generate a new token
+    CodeGenerateToken = "generate-token"
+    // Couldn't encode the response
+    CodeEncodeResponse = "encode-response"
+    // The requested token isn't associated with any run
+    CodeTokenNotFound = "token-not-found"
+    // The request is missing its command or parameter
+    CodeMissingCommand = "missing-command"
+    // The requested operation doesn't exist
+    CodeInvalidOperation = "invalid-operation"
+    // The run was already started
+    CodeRunStarted = "run-started"
+    // The run hasn't been started yet
+    CodeRunNotStarted = "run-not-started"
+    // The run hasn't finished yet
+    CodeRunNotFinished = "run-not-finished"
+    // The request didn't have the expected Content-Type
+    CodeBadContentType = "bad-content-type"
+    // The request used an unsupported method
+    CodeBadMethod = "bad-method"
+)
+
 type DurationMs struct {
     time.Duration
 }
@@ -231,18 +282,52 @@ func (idx runIndexer) _saveRun(splits []split, filename string) error {
         enc := json.NewEncoder(w)
         err := enc.Encode(&tmp)
         if err != nil {
-            return newError(err, "Couldn't save the splits", http.StatusInternalServerError)
+            return newError(err, CodeEncodeRun, "Couldn't save the splits", http.StatusInternalServerError)
         }
         return nil
     }
     err := common.AtomicSaveFile(idx.runsDir, filePath, writefn)
     if err != nil {
-        return newError(err, "Couldn't create the splits", http.StatusInternalServerError)
+        return newError(err, CodeSaveRun, "Couldn't create the splits", http.StatusInternalServerError)
     }
 
     return nil
 }
 
+// Number of attempts, as stored in `attempts.json`.
+type attemptsFile struct {
+    // How many times a run was started.
+    Attempts int
+}
+
+// Retrieve the number of attempts stored in `idx`'s `runsDir`. If there's
+// no such file, no run was attempted yet.
+func (idx runIndexer) loadAttempts() int {
+    var tmp attemptsFile
+
+    data, err := os.ReadFile(path.Join(idx.runsDir, "attempts.json"))
+    if err == nil {
+        err = json.Unmarshal(data, &tmp)
+    }
+    if err != nil && !os.IsNotExist(err) {
+        logger.Warnf("web%s: Couldn't read the attempts of '%s': %+v", Prefix, idx.name, err)
+    }
+    return tmp.Attempts
+}
+
+// Save the number of attempts to `idx`'s `runsDir`.
+func (idx runIndexer) saveAttempts(attempts int) error {
+    writefn := func(w io.Writer) error {
+        tmp := attemptsFile {
+            Attempts: attempts,
+        }
+        return json.NewEncoder(w).Encode(&tmp)
+    }
+
+    filePath := path.Join(idx.runsDir, "attempts.json")
+    return common.AtomicSaveFile(idx.runsDir, filePath, writefn)
+}
+
 // Save `splits` to `idx`'s `runsDir`, encoding it as a JSON, in a file
 // named after the current date.
 func (idx runIndexer) saveRun(splits []split) error {
@@ -270,6 +355,8 @@ type run struct {
     Current int
     // Whether the timer was started.
     Started bool
+    // How many times a run of the game/category was started.
+    Attempts int
     // The token used to access the run.
     token string `json:"-"`
     // Information
@@ -289,6 +376,8 @@ type runCtx struct {
     baseDir string
     // Currently running splits.
     tokens map[string]*run
+    // Token of the most recently created run.
+    latest string
     // Synchronize access to the context.
     rwmut sync.RWMutex
 }
@@ -305,9 +394,9 @@ type getTimerResponse struct {
     Time int64
 }
 
-// Build a new error
-func newError(err error, res string, status int) error {
-    return srv_iface.NewHttpError(err, "web"+Prefix, res, status)
+// Build a new error, identified by one of the `Code*` constants.
+func newError(err error, code, res string, status int) error {
+    return srv_iface.NewCodedHttpError(err, "web"+Prefix, code, res, status)
 }
 
 // Reset the splits in a run back to the best splits.
@@ -328,9 +417,16 @@ func (r *run) resetRun() {
     r.Started = false
 }
 
-// Start the run.
+// Start the run, counting a new attempt. Since other tokens may track the
+// same game/category, the attempts are read again from the disk, so the
+// caller must hold the context's lock for writing.
 func (r *run) start() {
     r.Started = true
+    r.Attempts = r.idx.loadAttempts() + 1
+    err := r.idx.saveAttempts(r.Attempts)
+    if err != nil {
+        logger.Warnf("web%s: Couldn't save the attempts of '%s': %+v", Prefix, r.Name, err)
+    }
     r.Splits[0].StartTime.Duration = r.timer.Get()
     r.timer.Start()
 }
@@ -372,7 +468,7 @@ func (r *run) finishSegment() {
 // Save a run to disk, updating the best run ever if needed.
 func (r *run) saveRun() error {
     if r.Current < len(r.Splits) {
-        return newError(nil, "Run still hasn't finished", http.StatusBadRequest)
+        return newError(nil, CodeRunNotFinished, "Run still hasn't finished", http.StatusBadRequest)
     }
 
     if last := r.Current - 1; r.Splits[last].EndTime.Duration < r.Best[last].EndTime.Duration || r.Best[last].EndTime.Duration == 0 {
@@ -385,13 +481,13 @@ func (r *run) saveRun() error {
 
         err := r.idx.saveBestRun(r.Best)
         if err != nil {
-            return newError(err, "Couldn't update 'best.json'", http.StatusInternalServerError)
+            return newError(err, CodeSaveBest, "Couldn't update 'best.json'", http.StatusInternalServerError)
         }
     }
 
     err := r.idx.saveRun(r.Splits)
     if err != nil {
-        return newError(err, "Couldn't save the new run", http.StatusInternalServerError)
+        return newError(err, CodeSaveRun, "Couldn't save the new run", http.StatusInternalServerError)
     }
     return nil
 }
@@ -411,6 +507,7 @@ func newRun(idx runIndexer, token string, best []split) *run {
     r.Current = 0
     r.Started = false
     r.idx = idx
+    r.Attempts = idx.loadAttempts()
     r.timer = timer.New()
     r.lastUse = time.Now()
 
@@ -440,6 +537,21 @@ func (ctx *runCtx) Close() {
     }
 }
 
+// Retrieve the metrics about the runs being tracked.
+func (ctx *runCtx) Metrics() []srv_iface.Metric {
+    ctx.rwmut.RLock()
+    tokens := len(ctx.tokens)
+    ctx.rwmut.RUnlock()
+
+    return []srv_iface.Metric {
+        srv_iface.Metric {
+            Name: "run_active_tokens",
+            Help: "Number of runs being tracked.",
+            Value: float64(tokens),
+        },
+    }
+}
+
 // Receive the server's listening port
 func (ctx *runCtx) SetListeningPort(port int) {
     ctx.listeningPort = port
@@ -461,9 +573,9 @@ func (ctx *runCtx) unsafeGenerateToken() (string, error) {
         var tokenBytes [12]byte
         n, err := crand.Read(tokenBytes[:])
         if err != nil {
-            return "", newError(err, "Failed to generate a new token", http.StatusInternalServerError)
+            return "", newError(err, CodeGenerateToken, "Failed to generate a new token", http.StatusInternalServerError)
         } else if n != len(tokenBytes) {
-            return "", newError(err, "Failed to generate enough bytes for the token", http.StatusInternalServerError)
+            return "", newError(err, CodeGenerateToken, "Failed to generate enough bytes for the token", http.StatusInternalServerError)
         }
 
         token = base64.URLEncoding.EncodeToString(tokenBytes[:])
@@ -508,7 +620,7 @@ func (ctx *runCtx) getRunIndex(name string) (runIndexer, error) {
     ctx.rwmut.Unlock()
     if err != nil {
         reason := "Failed to create runs directory"
-        return idx, newError(err, reason, http.StatusInternalServerError)
+        return idx, newError(err, CodeCreateRunsDir, reason, http.StatusInternalServerError)
     }
 
     return idx, nil
@@ -536,7 +648,7 @@ func (ctx *runCtx) unsafeGetBestRun(idx runIndexer) ([]split, error) {
         // Save it to a file
         err = idx.saveBestRun(splits.Splits)
         if err != nil {
-            return nil, newError(err, "Couldn't create first 'best.json'", http.StatusInternalServerError)
+            return nil, newError(err, CodeCreateBest, "Couldn't create first 'best.json'", http.StatusInternalServerError)
         }
     } else if err == nil {
         // 'best.json' exists, so read the file
@@ -544,11 +656,11 @@ func (ctx *runCtx) unsafeGetBestRun(idx runIndexer) ([]split, error) {
         err = dec.Decode(&splits)
         f.Close()
         if err != nil {
-            return nil, newError(err, "Couldn't decode best.json", http.StatusInternalServerError)
+            return nil, newError(err, CodeDecodeBest, "Couldn't decode best.json", http.StatusInternalServerError)
         }
     } else if err != nil {
         // Failed to open 'best.json' at all
-        return nil, newError(err, "Couldn't open best.json", http.StatusInternalServerError)
+        return nil, newError(err, CodeOpenBest, "Couldn't open best.json", http.StatusInternalServerError)
     }
 
     return splits.Splits, nil
@@ -568,19 +680,20 @@ func (ctx *runCtx) getNewRun(w http.ResponseWriter, req *http.Request, name stri
     defer ctx.rwmut.Unlock()
     best, err := ctx.unsafeGetBestRun(idx)
     if err != nil {
-        err = newError(err, "Failed to retrieve the best run", http.StatusInternalServerError)
+        err = newError(err, CodeGetBest, "Failed to retrieve the best run", http.StatusInternalServerError)
         return err
     }
 
     token, err := ctx.unsafeGenerateToken()
     if err != nil {
-        err = newError(err, "Failed to generate a token", http.StatusInternalServerError)
+        err = newError(err, CodeGenerateToken, "Failed to generate a token", http.StatusInternalServerError)
         return err
     }
 
     // Configure and save the run
     t := newRun(idx, token, best)
     ctx.tokens[token] = t
+    ctx.latest = token
 
     // Reply with the token
     resp := getNewResponse {
@@ -592,13 +705,22 @@ func (ctx *runCtx) getNewRun(w http.ResponseWriter, req *http.Request, name stri
     err = enc.Encode(&resp)
     if err != nil {
         // Welp, nothing else to do... D:
-        err = newError(err, "Failed to encode the responde", http.StatusInternalServerError)
+        err = newError(err, CodeEncodeResponse, "Failed to encode the responde", http.StatusInternalServerError)
         logger.Errorf("%+v", err)
     }
 
     return nil
 }
 
+// Retrieve the run referenced by `token`, which may also be `latest`.
+func (ctx *runCtx) unsafeGetRun(token string) (*run, bool) {
+    if token == LatestToken {
+        token = ctx.latest
+    }
+    r, ok := ctx.tokens[token]
+    return r, ok
+}
+
 // Retrieve a generic structure from a given token, encoding it as a JSON
 // on the response. The token mutex is properly locked for reading, and the
 // field is retrieved by the `getResponse` function.
@@ -607,9 +729,9 @@ func (ctx *runCtx) getGeneric(getResponse func(*run)(interface{}, error), w http
     ctx.rwmut.RLock()
     defer ctx.rwmut.RUnlock()
 
-    r, ok := ctx.tokens[token]
+    r, ok := ctx.unsafeGetRun(token)
     if !ok {
-        return newError(nil, "Failed to find the token", http.StatusNotFound)
+        return newError(nil, CodeTokenNotFound, "Failed to find the token", http.StatusNotFound)
     }
 
     resp, err := getResponse(r)
@@ -620,7 +742,7 @@ func (ctx *runCtx) getGeneric(getResponse func(*run)(interface{}, error), w http
     err = enc.Encode(resp)
     if err != nil {
         // Welp, nothing else to do... D:
-        err = newError(err, "Failed to encode the responde", http.StatusInternalServerError)
+        err = newError(err, CodeEncodeResponse, "Failed to encode the responde", http.StatusInternalServerError)
         logger.Errorf("%+v", err)
     }
 
@@ -654,7 +776,7 @@ func (ctx *runCtx) getSplits(w http.ResponseWriter, req *http.Request, token str
 // Handle GET requests.
 func (ctx *runCtx) get(w http.ResponseWriter, req *http.Request, urlPath []string) error {
     if len(urlPath) != 2 {
-        return newError(nil, "Missing command/parameter", http.StatusBadRequest)
+        return newError(nil, CodeMissingCommand, "Missing command/parameter", http.StatusBadRequest)
     }
 
     switch urlPath[0] {
@@ -665,7 +787,7 @@ func (ctx *runCtx) get(w http.ResponseWriter, req *http.Request, urlPath []strin
     case "timer":
         return ctx.getTimer(w, req, urlPath[1])
     default:
-        return newError(nil, "Invalid operation", http.StatusBadRequest)
+        return newError(nil, CodeInvalidOperation, "Invalid operation", http.StatusBadRequest)
     }
 
     // Shouldn't ever reach here
@@ -674,39 +796,39 @@ func (ctx *runCtx) get(w http.ResponseWriter, req *http.Request, urlPath []strin
 // Handle POST request.
 func (ctx *runCtx) post(w http.ResponseWriter, req *http.Request, urlPath []string) error {
     if len(urlPath) < 2 {
-        return newError(nil, "Missing command (expected \"<url>/<token>/<command>\"", http.StatusBadRequest)
+        return newError(nil, CodeMissingCommand, "Missing command (expected \"<url>/<token>/<command>\"", http.StatusBadRequest)
     }
 
     // Try to get the run referenced by the token
     token := urlPath[0]
     ctx.rwmut.Lock()
     defer ctx.rwmut.Unlock()
-    r, ok := ctx.tokens[token]
+    r, ok := ctx.unsafeGetRun(token)
     if !ok {
-        return newError(nil, "Failed to find the token", http.StatusNotFound)
+        return newError(nil, CodeTokenNotFound, "Failed to find the token", http.StatusNotFound)
     }
 
     // Ensure the operation would be valid
     switch urlPath[1] {
     case "start":
         if r.Started {
-            return newError(nil, "Run was already started", http.StatusBadRequest)
+            return newError(nil, CodeRunStarted, "Run was already started", http.StatusBadRequest)
         }
     case "split",
         "undo",
         "skip",
         "pause-toggle":
         if !r.Started {
-            return newError(nil, "Run was already started", http.StatusBadRequest)
+            return newError(nil, CodeRunNotStarted, "Run hasn't started yet", http.StatusBadRequest)
         }
     case "reset":
         // Works in both states
     case "save":
         if !r.Started || r.Current != len(r.Splits) {
-            return newError(nil, "Run must have finished before it may be saved", http.StatusBadRequest)
+            return newError(nil, CodeRunNotFinished, "Run must have finished before it may be saved", http.StatusBadRequest)
         }
     default:
-        return newError(nil, "Invalid operation", http.StatusBadRequest)
+        return newError(nil, CodeInvalidOperation, "Invalid operation", http.StatusBadRequest)
     }
 
     switch urlPath[1] {
@@ -714,6 +836,12 @@ func (ctx *runCtx) post(w http.ResponseWriter, req *http.Request, urlPath []stri
         r.resetRun()
     case "start":
         r.start()
+        // Keep every token of the same game/category up to date
+        for _, other := range ctx.tokens {
+            if other.idx.runsDir == r.idx.runsDir {
+                other.Attempts = r.Attempts
+            }
+        }
     case "split":
         r.finishSegment()
         r.advanceSplits()
@@ -742,7 +870,7 @@ func (ctx *runCtx) post(w http.ResponseWriter, req *http.Request, urlPath []stri
         }
     default:
         // Shouldn't happen
-        return newError(nil, "Invalid operation", http.StatusBadRequest)
+        return newError(nil, CodeInvalidOperation, "Invalid operation", http.StatusBadRequest)
     }
 
     w.WriteHeader(http.StatusNoContent)
@@ -752,9 +880,9 @@ func (ctx *runCtx) post(w http.ResponseWriter, req *http.Request, urlPath []stri
 // Handle requests to the `run` service, filtering and
 // redirecting as necessary.
 func (ctx *runCtx) Handle(w http.ResponseWriter, req *http.Request, urlPath []string) error {
-    if req.Header.Get("Content-Type") != "application/json" {
-        reason := "Content-Type must be \"application/json\""
-        return newError(nil, reason, http.StatusUnsupportedMediaType)
+    if !srv_iface.IsJSONRequest(req) {
+        reason := "Content-Type must be either empty or \"application/json\""
+        return newError(nil, CodeBadContentType, reason, http.StatusUnsupportedMediaType)
     }
     urlPath = urlPath[1:]
 
@@ -764,7 +892,7 @@ func (ctx *runCtx) Handle(w http.ResponseWriter, req *http.Request, urlPath []st
     case "POST":
         return ctx.post(w, req, urlPath)
     default:
-        return newError(nil, "Invalid method: wanted either GET or POST", http.StatusMethodNotAllowed)
+        return newError(nil, CodeBadMethod, "Invalid method: wanted either GET or POST", http.StatusMethodNotAllowed)
     }
 }
 
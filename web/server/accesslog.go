@@ -0,0 +1,273 @@
+// Access log for the requests handled by the server, written separately
+// from the application log.
+//
+// Every request is assigned an ID, either received from the client (in a
+// "X-Request-Id" header) or generated by the server. This ID is sent back
+// in the response, attached to every `HttpError` and logged alongside the
+// request, so a reply may be correlated with the server's logs.
+
+package server
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "io"
+    "net"
+    "net/http"
+    "os"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Maximum length of a request ID received from a client.
+const maxRequestIDLen = 128
+
+// Fallback counter used if the random generator fails.
+var requestCounter uint64
+
+// Check whether a request ID received from a client may be reused. Only
+// short IDs with letters, digits, '.', '_' and '-' are accepted, so they
+// may be safely logged.
+func isValidRequestID(id string) bool {
+    if len(id) == 0 || len(id) > maxRequestIDLen {
+        return false
+    }
+
+    for _, c := range id {
+        switch {
+        case c >= 'a' && c <= 'z':
+        case c >= 'A' && c <= 'Z':
+        case c >= '0' && c <= '9':
+        case c == '.' || c == '_' || c == '-':
+        default:
+            return false
+        }
+    }
+    return true
+}
+
+// Retrieve the ID for the request, generating a new one if the client
+// didn't send a valid one.
+func getRequestID(req *http.Request) string {
+    if id := req.Header.Get(srv_iface.RequestIDHeader); isValidRequestID(id) {
+        return id
+    }
+
+    var buf [8]byte
+    if _, err := rand.Read(buf[:]); err != nil {
+        count := atomic.AddUint64(&requestCounter, 1)
+        return strconv.FormatUint(count, 16)
+    }
+    return hex.EncodeToString(buf[:])
+}
+
+// A single request, as recorded in the access log.
+type accessEntry struct {
+    // When the request arrived.
+    Time time.Time `json:"time"`
+    // ID assigned to the request.
+    RequestID string `json:"request_id"`
+    // Address of the client, without its port.
+    Remote string `json:"remote"`
+    // The request's method.
+    Method string `json:"method"`
+    // The requested URL, including its query.
+    Path string `json:"path"`
+    // The request's protocol (e.g., "HTTP/1.1").
+    Proto string `json:"proto"`
+    // Status code sent in the response.
+    Status int `json:"status"`
+    // Number of bytes sent in the response's body.
+    Bytes int64 `json:"bytes"`
+    // How long the request took, in milliseconds.
+    Duration float64 `json:"duration_ms"`
+    // Prefix of the handler that handled the request.
+    Handler string `json:"handler"`
+}
+
+// Format the entry in Apache's Common Log Format, followed by the request
+// ID and the request's duration in microseconds.
+func (e *accessEntry) common() string {
+    size := "-"
+    if e.Bytes > 0 {
+        size = strconv.FormatInt(e.Bytes, 10)
+    }
+
+    return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s %s %d\n",
+            e.Remote,
+            e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+            e.Method,
+            e.Path,
+            e.Proto,
+            e.Status,
+            size,
+            e.RequestID,
+            int64(e.Duration * 1000))
+}
+
+// Writes requests to a file, rotating it as it grows.
+type accessLog struct {
+    // How requests are logged.
+    cfg srv_iface.AccessLogConfig
+    // The file where requests are written.
+    out io.WriteCloser
+    // Size of the current file, in bytes.
+    size int64
+    // Synchronize access to the file.
+    mut sync.Mutex
+}
+
+// Open (or create) the access log described by `cfg`.
+func newAccessLog(cfg srv_iface.AccessLogConfig) (*accessLog, error) {
+    l := &accessLog {
+        cfg: cfg,
+    }
+
+    if cfg.Filename == "-" {
+        l.out = nopCloser{os.Stdout}
+        return l, nil
+    }
+
+    err := l.open()
+    if err != nil {
+        return nil, err
+    }
+    return l, nil
+}
+
+// Wraps a writer that mustn't be closed (e.g., `os.Stdout`).
+type nopCloser struct {
+    io.Writer
+}
+
+// Do nothing.
+func (nopCloser) Close() error {
+    return nil
+}
+
+// Open the log file, appending to it if it already exists.
+func (l *accessLog) open() error {
+    f, err := os.OpenFile(l.cfg.Filename, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+    if err != nil {
+        return err
+    }
+
+    info, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return err
+    }
+
+    l.out = f
+    l.size = info.Size()
+    return nil
+}
+
+// Rotate the log file, shifting every backup by one and discarding the
+// oldest one. Must be called with `mut` locked.
+func (l *accessLog) unsafeRotate() error {
+    l.out.Close()
+    l.out = nil
+
+    name := l.cfg.Filename
+    if l.cfg.MaxBackups <= 0 {
+        os.Remove(name)
+    } else {
+        os.Remove(name + "." + strconv.Itoa(l.cfg.MaxBackups))
+        for i := l.cfg.MaxBackups - 1; i > 0; i-- {
+            os.Rename(name + "." + strconv.Itoa(i), name + "." + strconv.Itoa(i + 1))
+        }
+        os.Rename(name, name + ".1")
+    }
+
+    return l.open()
+}
+
+// Write a request to the log.
+func (l *accessLog) write(e *accessEntry) {
+    var line []byte
+
+    if l.cfg.Format == srv_iface.JSONLogFormat {
+        data, err := json.Marshal(e)
+        if err != nil {
+            logger.Errorf("web/server: Failed to encode the access log entry: %+v", err)
+            return
+        }
+        line = append(data, '\n')
+    } else {
+        line = []byte(e.common())
+    }
+
+    l.mut.Lock()
+    defer l.mut.Unlock()
+
+    if l.out == nil {
+        return
+    }
+
+    if max := l.cfg.MaxSize; max > 0 && l.size > 0 && l.size + int64(len(line)) > max {
+        if err := l.unsafeRotate(); err != nil {
+            logger.Errorf("web/server: Failed to rotate the access log: %+v", err)
+            return
+        }
+    }
+
+    n, err := l.out.Write(line)
+    l.size += int64(n)
+    if err != nil {
+        logger.Errorf("web/server: Failed to write to the access log: %+v", err)
+    }
+}
+
+// Close the log file.
+func (l *accessLog) Close() {
+    l.mut.Lock()
+    defer l.mut.Unlock()
+
+    if l.out != nil {
+        l.out.Close()
+        l.out = nil
+    }
+}
+
+// Record a request that has just been handled.
+func (s *runningServer) logAccess(req *http.Request, id string, rec *responseRecorder, prefix string, start time.Time, duration time.Duration) {
+    status := rec.getStatus()
+    ms := float64(duration) / float64(time.Millisecond)
+
+    if status >= http.StatusInternalServerError {
+        logger.Errorf("%s %s - %d in %.2fms (%s)", req.Method, req.URL.Path, status, ms, id)
+    } else if status >= http.StatusBadRequest {
+        logger.Warnf("%s %s - %d in %.2fms (%s)", req.Method, req.URL.Path, status, ms, id)
+    } else {
+        logger.Infof("%s %s - %d in %.2fms (%s)", req.Method, req.URL.Path, status, ms, id)
+    }
+
+    if s.accessLog == nil {
+        return
+    }
+
+    remote, _, err := net.SplitHostPort(req.RemoteAddr)
+    if err != nil {
+        remote = req.RemoteAddr
+    }
+
+    s.accessLog.write(&accessEntry {
+        Time: start,
+        RequestID: id,
+        Remote: remote,
+        Method: req.Method,
+        Path: req.URL.RequestURI(),
+        Proto: req.Proto,
+        Status: status,
+        Bytes: rec.size,
+        Duration: ms,
+        Handler: prefix,
+    })
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "os"
+    "path/filepath"
+    "regexp"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "strings"
+    "testing"
+    "time"
+)
+
+// Open an access log with `cfg` in a temporary directory, returning the
+// path to the log file.
+func newTestAccessLog(t *testing.T, s *runningServer, cfg srv_iface.AccessLogConfig) string {
+    cfg.Filename = filepath.Join(t.TempDir(), "access.log")
+
+    l, err := newAccessLog(cfg)
+    if err != nil {
+        t.Fatalf("Failed to open the access log: %+v", err)
+    }
+    s.accessLog = l
+    t.Cleanup(l.Close)
+
+    return cfg.Filename
+}
+
+// Read every line in the file `name`.
+func readLines(t *testing.T, name string) []string {
+    data, err := os.ReadFile(name)
+    if err != nil {
+        t.Fatalf("Failed to read '%s': %+v", name, err)
+    }
+    return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestRequestID(t *testing.T) {
+    long := strings.Repeat("a", maxRequestIDLen)
+
+    for _, tc := range []struct {
+        id string
+        valid bool
+    } {
+        { "abc-DEF_0.9", true },
+        { long, true },
+        { long + "a", false },
+        { "", false },
+        { "a b", false },
+        { "a\nb", false },
+        { "ação", false },
+    } {
+        if got := isValidRequestID(tc.id); got != tc.valid {
+            t.Errorf("'%s': expected valid to be %v, got %v", tc.id, tc.valid, got)
+        }
+    }
+
+    s := newTestServer(t, newTestHandler("/a"))
+
+    // Valid IDs are sent back, and invalid ones are replaced
+    w := serveTest(s, http.MethodGet, "/a", srv_iface.RequestIDHeader, "client-id.1")
+    if got := w.Header().Get(srv_iface.RequestIDHeader); got != "client-id.1" {
+        t.Errorf("Expected the client's ID to be reused, got '%s'", got)
+    }
+
+    generated := regexp.MustCompile("^[0-9a-f]{16}$")
+    first := ""
+    for _, hdr := range [][]string {
+        {},
+        { srv_iface.RequestIDHeader, "bad id" },
+        { srv_iface.RequestIDHeader, long + "a" },
+    } {
+        w = serveTest(s, http.MethodGet, "/a", hdr...)
+        got := w.Header().Get(srv_iface.RequestIDHeader)
+        if !generated.MatchString(got) {
+            t.Errorf("%v: expected a generated ID, got '%s'", hdr, got)
+        } else if got == first {
+            t.Errorf("%v: the generated ID '%s' was repeated", hdr, got)
+        }
+        first = got
+    }
+}
+
+func TestAccessLogFormats(t *testing.T) {
+    s := newTestServer(t, newTestHandler("/a"))
+
+    name := newTestAccessLog(t, s, srv_iface.AccessLogConfig{})
+    serveTest(s, http.MethodGet, "/a/b?c=d", srv_iface.RequestIDHeader, "id-1")
+    serveTest(s, http.MethodGet, "/missing", srv_iface.RequestIDHeader, "id-2")
+
+    common := []*regexp.Regexp {
+        regexp.MustCompile(`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\] "GET /a/b\?c=d HTTP/1\.1" 200 2 id-1 \d+$`),
+        regexp.MustCompile(`^192\.0\.2\.1 - - \[.+\] "GET /missing HTTP/1\.1" 404 \S+ id-2 \d+$`),
+    }
+    lines := readLines(t, name)
+    if len(lines) != len(common) {
+        t.Fatalf("Common: expected %d lines, got %d: %q", len(common), len(lines), lines)
+    }
+    for i, re := range common {
+        if !re.MatchString(lines[i]) {
+            t.Errorf("Common: line %d ('%s') doesn't match '%s'", i, lines[i], re)
+        }
+    }
+
+    name = newTestAccessLog(t, s, srv_iface.AccessLogConfig {
+        Format: srv_iface.JSONLogFormat,
+    })
+    start := time.Now()
+    serveTest(s, http.MethodGet, "/a/b?c=d", srv_iface.RequestIDHeader, "id-3")
+
+    lines = readLines(t, name)
+    if len(lines) != 1 {
+        t.Fatalf("JSON: expected a single line, got %q", lines)
+    }
+    var got accessEntry
+    err := json.Unmarshal([]byte(lines[0]), &got)
+    if err != nil {
+        t.Fatalf("JSON: failed to decode '%s': %+v", lines[0], err)
+    }
+
+    exp := accessEntry {
+        Time: got.Time,
+        RequestID: "id-3",
+        Remote: "192.0.2.1",
+        Method: http.MethodGet,
+        Path: "/a/b?c=d",
+        Proto: "HTTP/1.1",
+        Status: http.StatusOK,
+        Bytes: 2,
+        Duration: got.Duration,
+        Handler: "/a",
+    }
+    if got != exp {
+        t.Errorf("JSON: expected %+v, got %+v", exp, got)
+    } else if got.Time.Before(start.Add(-time.Second)) || got.Duration < 0 {
+        t.Errorf("JSON: unexpected time (%s) or duration (%f)", got.Time, got.Duration)
+    }
+}
+
+func TestAccessLogRotation(t *testing.T) {
+    s := newTestServer(t, newTestHandler("/a"))
+    entry := accessEntry {
+        Time: time.Now(),
+        RequestID: "id",
+        Remote: "192.0.2.1",
+        Method: http.MethodGet,
+        Path: "/a",
+        Proto: "HTTP/1.1",
+        Status: http.StatusOK,
+    }
+    size := int64(len(entry.common()))
+
+    // Two lines fit in each file, and only two backups are kept
+    name := newTestAccessLog(t, s, srv_iface.AccessLogConfig {
+        MaxSize: 2 * size,
+        MaxBackups: 2,
+    })
+    for i := 0; i < 7; i++ {
+        s.accessLog.write(&entry)
+    }
+
+    for _, tc := range []struct {
+        suffix string
+        lines int
+    } {
+        { "", 1 },
+        { ".1", 2 },
+        { ".2", 2 },
+    } {
+        if got := len(readLines(t, name + tc.suffix)); got != tc.lines {
+            t.Errorf("'%s': expected %d lines, got %d", tc.suffix, tc.lines, got)
+        }
+    }
+    if _, err := os.Stat(name + ".3"); !os.IsNotExist(err) {
+        t.Errorf("Expected only two backups, got %+v", err)
+    }
+
+    // Without any backup, the file is simply truncated
+    name = newTestAccessLog(t, s, srv_iface.AccessLogConfig {
+        MaxSize: 2 * size,
+    })
+    for i := 0; i < 3; i++ {
+        s.accessLog.write(&entry)
+    }
+    if got := len(readLines(t, name)); got != 1 {
+        t.Errorf("No backups: expected 1 line, got %d", got)
+    } else if _, err := os.Stat(name + ".1"); !os.IsNotExist(err) {
+        t.Errorf("No backups: expected no backup, got %+v", err)
+    }
+}
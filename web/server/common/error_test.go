@@ -1,7 +1,10 @@
 package common
 
 import (
+    "encoding/json"
     "errors"
+    "net/http"
+    "net/http/httptest"
     "testing"
 )
 
@@ -17,3 +20,60 @@ func TestPrintWrappedErrors(t *testing.T) {
     t.Logf("wrap http err: %+v\n", wrapHttpErr)
     t.Logf("double wrap http err: %+v\n", doubleWrapHttpErr)
 }
+
+// Dummy error with a machine-readable code.
+type codedErr struct{}
+func (codedErr) Error() string { return "coded error" }
+func (codedErr) Code() string { return "inner-code" }
+
+func TestErrorCode(t *testing.T) {
+    for _, tc := range []struct {
+        err HttpError
+        code string
+    } {
+        { NewHttpError(nil, "test", "no code", http.StatusNotFound), "not-found" },
+        { NewCodedHttpError(nil, "test", "explicit", "code", http.StatusBadRequest), "explicit" },
+        { NewHttpError(codedErr{}, "test", "wrap coded", http.StatusInternalServerError), "inner-code" },
+        { NewHttpError(NewCodedHttpError(nil, "test", "root", "code", 400), "test", "wrap http", 500), "root" },
+        { NewCodedHttpError(codedErr{}, "test", "outer", "code", 500), "outer" },
+    } {
+        if got := tc.err.GetCode(); got != tc.code {
+            t.Errorf("expected code '%s', got '%s'", tc.code, got)
+        }
+    }
+}
+
+func TestReplyJSON(t *testing.T) {
+    herr := NewCodedHttpError(nil, "web/test", "some-code", "Some reason", http.StatusTeapot)
+
+    req := httptest.NewRequest(http.MethodGet, "/", nil)
+    req = WithRequestID(req, "abc123")
+    req.Header.Set("Accept", "text/html, application/json;q=0.9")
+    w := httptest.NewRecorder()
+    ReplyHttpError(herr, w, req)
+
+    if w.Code != http.StatusTeapot {
+        t.Errorf("expected status %d, got %d", http.StatusTeapot, w.Code)
+    } else if ctype := w.Header().Get("Content-Type"); ctype != "application/json" {
+        t.Errorf("expected a JSON response, got '%s'", ctype)
+    }
+
+    var got jsonError
+    err := json.NewDecoder(w.Body).Decode(&got)
+    if err != nil {
+        t.Fatalf("failed to decode the response: %+v", err)
+    }
+    exp := jsonError { "web/test", "Some reason", http.StatusTeapot, "some-code", "abc123" }
+    if got != exp {
+        t.Errorf("expected %+v, got %+v", exp, got)
+    }
+
+    req.Header.Set("Accept", "text/html")
+    w = httptest.NewRecorder()
+    ReplyHttpError(herr, w, req)
+    if ctype := w.Header().Get("Content-Type"); ctype != "text/plain" {
+        t.Errorf("expected a plain text response, got '%s'", ctype)
+    } else if body := w.Body.String(); body != "Some reason" {
+        t.Errorf("expected the reason as the body, got '%s'", body)
+    }
+}
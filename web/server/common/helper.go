@@ -6,8 +6,11 @@
 package common
 
 import (
+    "context"
+    "encoding/json"
     "fmt"
     "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    "mime"
     "net/http"
     "runtime/debug"
     "strings"
@@ -19,6 +22,23 @@ type HttpError interface {
     IsHttpError() bool
     // Retrieve the error code sent in the reply
     GetHttpStatus() string
+    // Retrieve the stable, machine-readable code that identifies the
+    // error.
+    GetCode() string
+    // Associate the error with the request that caused it.
+    SetRequestID(id string)
+    // Retrieve the ID of the request that caused the error, if any.
+    GetRequestID() string
+    // Implement Go's error interface.
+    error
+}
+
+// Errors that may be identified by a stable, machine-readable code. Any
+// error wrapped by a `HttpError` may implement this to report its code.
+type CodedError interface {
+    // Retrieve the stable, machine-readable code that identifies the
+    // error.
+    Code() string
     // Implement Go's error interface.
     error
 }
@@ -31,8 +51,13 @@ type httpError struct {
     module string
     // Human-readable error reason, sent as the reply.
     reason string
+    // Machine-readable error code, sent alongside the reason. May be left
+    // empty, in which case it's retrieved from the wrapped error.
+    code string
     // Error code sent as the reply.
     httpStatus int
+    // ID of the request that caused the error, if any.
+    requestID string
     // Stack trace for the error. If the error is wrapping another
     // httpError, the stack trace will be left empty.
     stackTrace []byte
@@ -42,6 +67,11 @@ type httpError struct {
 
 // Create a new HTTPError.
 func NewHttpError(inner error, module string, reason string, httpStatus int) HttpError {
+    return NewCodedHttpError(inner, module, "", reason, httpStatus)
+}
+
+// Create a new HTTPError, identified by a stable, machine-readable `code`.
+func NewCodedHttpError(inner error, module, code, reason string, httpStatus int) HttpError {
     var stackTrace []byte
 
     if he, ok := inner.(*httpError); !ok {
@@ -57,6 +87,7 @@ func NewHttpError(inner error, module string, reason string, httpStatus int) Htt
         inner: inner,
         module: module,
         reason: reason,
+        code: code,
         httpStatus: httpStatus,
         stackTrace: stackTrace,
         wrapCount: 0,
@@ -73,6 +104,42 @@ func (e *httpError) GetHttpStatus() string {
     return http.StatusText(e.httpStatus)
 }
 
+// Retrieve the stable, machine-readable code that identifies the error.
+// If this error doesn't have an explicit code, the first code in the chain
+// of wrapped errors is used instead. If no error defines a code, one is
+// derived from the HTTP status (e.g., "not-found").
+func (e *httpError) GetCode() string {
+    var err error = e
+    for err != nil {
+        if he, ok := err.(*httpError); ok && he != nil {
+            if len(he.code) > 0 {
+                return he.code
+            }
+            err = he.inner
+        } else if ce, ok := err.(CodedError); ok {
+            return ce.Code()
+        } else {
+            break
+        }
+    }
+
+    code := strings.ToLower(http.StatusText(e.httpStatus))
+    if len(code) == 0 {
+        return "unknown"
+    }
+    return strings.Replace(code, " ", "-", -1)
+}
+
+// Associate the error with the request that caused it.
+func (e *httpError) SetRequestID(id string) {
+    e.requestID = id
+}
+
+// Retrieve the ID of the request that caused the error, if any.
+func (e *httpError) GetRequestID() string {
+    return e.requestID
+}
+
 // Set the wrap count for this error and every wrapped httpError.
 func (e *httpError) setWrapCount(count int) {
     if he, ok := e.inner.(*httpError); ok && he != nil {
@@ -95,6 +162,16 @@ func (e *httpError) Error() string {
     buf.WriteString("%s%s: %s (%d)")
     args = append(args, tabs, e.module, e.reason, e.httpStatus)
 
+    if len(e.code) > 0 {
+        buf.WriteString(" [%s]")
+        args = append(args, e.code)
+    }
+
+    if len(e.requestID) > 0 {
+        buf.WriteString(" (request %s)")
+        args = append(args, e.requestID)
+    }
+
     if e.inner != nil {
         buf.WriteString("\n%s%s:")
         args = append(args, tabs, "Base error")
@@ -132,10 +209,138 @@ func ReplyStatus(mod string, status int, msg string, w http.ResponseWriter) {
     }
 }
 
-// Reply a message with a `httpError`
-func ReplyHttpError(e HttpError, w http.ResponseWriter) {
-    fmt.Printf("%+v", e)
+// Check whether the client accepts a JSON encoded response. Wildcards
+// aren't considered, so clients must explicitly list either
+// "application/json" or a "+json" media type.
+func AcceptsJSON(req *http.Request) bool {
+    if req == nil {
+        return false
+    }
+
+    for _, accept := range req.Header.Values("Accept") {
+        for _, entry := range strings.Split(accept, ",") {
+            mtype, params, err := mime.ParseMediaType(entry)
+            if err != nil {
+                continue
+            } else if q, ok := params["q"]; ok && strings.Trim(q, "0.") == "" {
+                // Explicitly refused (q=0)
+                continue
+            }
+
+            if mtype == "application/json" || strings.HasSuffix(mtype, "+json") {
+                return true
+            }
+        }
+    }
+
+    return false
+}
+
+// Check whether the request's body is JSON encoded. Requests without a
+// Content-Type are also accepted, since browsers don't send one for
+// requests without a body (and adding it to cross-origin requests
+// triggers a preflight request).
+func IsJSONRequest(req *http.Request) bool {
+    ctype := req.Header.Get("Content-Type")
+    if len(ctype) == 0 {
+        return true
+    }
+
+    mtype, _, err := mime.ParseMediaType(ctype)
+    return err == nil && (mtype == "application/json" || strings.HasSuffix(mtype, "+json"))
+}
+
+// JSON representation of a `httpError`, sent to clients that accept JSON.
+type jsonError struct {
+    // Module where the error happened.
+    Module string `json:"module"`
+    // Human-readable error reason.
+    Reason string `json:"reason"`
+    // The HTTP status code.
+    Status int `json:"status"`
+    // Stable, machine-readable error code.
+    Code string `json:"code"`
+    // ID of the request that caused the error.
+    RequestID string `json:"request_id,omitempty"`
+}
+
+// Send a status code response, encoded as a JSON object.
+func replyJSON(he *httpError, w http.ResponseWriter) {
+    resp := jsonError {
+        Module: he.module,
+        Reason: he.reason,
+        Status: he.httpStatus,
+        Code: he.GetCode(),
+        RequestID: he.requestID,
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(he.httpStatus)
+    enc := json.NewEncoder(w)
+    err := enc.Encode(&resp)
+    if err != nil {
+        logger.Errorf("%s: Failed to send %d: %+v", he.module, he.httpStatus, err)
+    }
+}
+
+// Reply a message with a `httpError`. If the client accepts JSON, the
+// error is encoded as a JSON object with its module, reason, status and
+// code. Otherwise, only the reason is sent as plain text.
+//
+// The full error, including its stack trace, is only logged at the debug
+// level. If the request has an ID, it's attached to the error.
+func ReplyHttpError(e HttpError, w http.ResponseWriter, req *http.Request) {
+    if id := RequestID(req); len(id) > 0 {
+        e.SetRequestID(id)
+    }
+    logger.Debugf("%+v", e)
     if he, ok := e.(*httpError); ok && he != nil {
-        ReplyStatus(he.module, he.httpStatus, he.reason, w)
+        if AcceptsJSON(req) {
+            replyJSON(he, w)
+        } else {
+            ReplyStatus(he.module, he.httpStatus, he.reason, w)
+        }
+    }
+}
+
+// Key used to store the request ID in a request's context.
+type requestIDKey struct{}
+
+// Header used to receive and send the ID of a request.
+const RequestIDHeader = "X-Request-Id"
+
+// Retrieve a copy of `req` associated with the request ID `id`.
+func WithRequestID(req *http.Request, id string) *http.Request {
+    ctx := context.WithValue(req.Context(), requestIDKey{}, id)
+    return req.WithContext(ctx)
+}
+
+// Retrieve the ID assigned to the request by the server, if any.
+func RequestID(req *http.Request) string {
+    if req == nil {
+        return ""
+    }
+    id, _ := req.Context().Value(requestIDKey{}).(string)
+    return id
+}
+
+// Key used to store the path parameters in a request's context.
+type pathParamsKey struct{}
+
+// Retrieve a copy of `req` associated with the parameters matched by the
+// handler's prefix.
+func WithPathParams(req *http.Request, params map[string]string) *http.Request {
+    ctx := context.WithValue(req.Context(), pathParamsKey{}, params)
+    return req.WithContext(ctx)
+}
+
+// Retrieve the value of the parameter `name` in the handler's prefix
+// (e.g., "id" in "/users/:id"), or an empty string if there's no such
+// parameter.
+func PathParam(req *http.Request, name string) string {
+    if req == nil {
+        return ""
     }
+    params, _ := req.Context().Value(pathParamsKey{}).(map[string]string)
+    return params[name]
 }
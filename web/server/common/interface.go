@@ -7,10 +7,24 @@ package common
 
 import (
     "net/http"
+    "time"
 )
 
 // A `http.Server` that is accepting requests in a separated Goroutine.
 type ListeningServer interface {
+    // Add a new `Handler` to the running server. Every dependency of the
+    // handler must already be registered. If any method is supplied, the
+    // handler only accepts requests with one of those methods.
+    AddHandler(handler Handler, methods ...string) error
+    // Remove the `Handler` associated with `prefix`, closing it after
+    // every pending request using it finishes. Handlers used by another
+    // handler, or as the default one, cannot be removed.
+    RemoveHandler(prefix string) error
+    // Replace the `Handler` registered with the same prefix as `handler`,
+    // closing the old one after every pending request using it finishes.
+    // The new handler only accepts the supplied methods (or every method,
+    // if none is supplied).
+    ReplaceHandler(handler Handler, methods ...string) error
     // Halts the `http.Server`.
     Close()
 }
@@ -18,17 +32,31 @@ type ListeningServer interface {
 // Interface for handling HTTP request, on a given base path.
 type Handler interface {
     // Base path for the handler (e.g., '/gamepad'). It must start with a
-    // leading slash ('/')!
+    // leading slash ('/')! It may have multiple segments (e.g.,
+    // '/gfm/api'), and segments starting with a ':' (e.g., '/users/:id')
+    // match any segment, retrieved by the handler with `PathParam()`.
     Prefix() string
     // List every other service used by this handler.
     Dependencies() []string
     // Handle a single `http.Request`. `urlPath` is the sanitized path,
-    // with the first member being the handler's prefix.
+    // with the first member being the segments matched by the handler's
+    // prefix (without the leading slash, e.g. "gfm/api").
     Handle(w http.ResponseWriter, req *http.Request, urlPath []string) error
     // Release resources associated with this object.
     Close()
 }
 
+// Interface for handling HTTP request, on a given base path, that may
+// hold requests for a long time (e.g., streams or long-polls). `Stop()` is
+// called before the handler is removed (or replaced), so those requests
+// finish and the handler may be closed.
+type StoppableHandler interface {
+    // End every long-lived request. The handler is closed afterwards.
+    Stop()
+    // Also implements `Handler`
+    Handler
+}
+
 // Interface for handling HTTP request, on a given base path, that needs
 // to send requests to local services.
 type LoopbackHandler interface {
@@ -38,13 +66,90 @@ type LoopbackHandler interface {
     Handler
 }
 
+// A single sample of a metric exposed by a `MetricsHandler`.
+type Metric struct {
+    // Name of the metric (e.g., "run_active_tokens"). It's exported with
+    // a "gfm_" prefix.
+    Name string
+    // Human-readable description of the metric.
+    Help string
+    // Current value of the metric.
+    Value float64
+    // Labels that distinguish this sample from others with the same
+    // `Name` (e.g., {"topic": "keyboard"}). May be nil.
+    Labels map[string]string
+}
+
+// Interface for handling HTTP request, on a given base path, that also
+// exposes metrics about its internal state (e.g., how many entries are
+// stored in the handler).
+type MetricsHandler interface {
+    // Retrieve the current value of every metric exposed by the handler.
+    Metrics() []Metric
+    // Also implements `Handler`
+    Handler
+}
+
+// Configure Cross-Origin Resource Sharing (CORS), so pages hosted in
+// other origins (e.g., dashboards or OBS custom docks) may access the
+// server.
+type CORSConfig struct {
+    // Origins allowed to access the server (e.g.,
+    // "http://localhost:3000"). "*" allows any origin.
+    AllowedOrigins []string
+    // Methods allowed in cross-origin requests. Defaults to GET, HEAD,
+    // POST, PUT, PATCH and DELETE.
+    AllowedMethods []string
+    // Headers allowed in cross-origin requests. "*" allows any header.
+    // Defaults to Accept and Content-Type.
+    AllowedHeaders []string
+    // Headers, besides the CORS-safelisted ones, that clients may read.
+    ExposedHeaders []string
+    // How long the response to a preflight request may be cached. Not
+    // sent if zero.
+    MaxAge time.Duration
+}
+
+// Format used by the access log.
+type AccessLogFormat uint
+const (
+    // Apache's Common Log Format, followed by the request ID and the
+    // request's duration in microseconds.
+    CommonLogFormat AccessLogFormat = iota
+    // A JSON object per line.
+    JSONLogFormat
+)
+
+// Configure the access log, which records every request handled by the
+// server separately from the application log.
+type AccessLogConfig struct {
+    // File where requests are logged. "-" logs to the standard output.
+    Filename string
+    // Format of each logged request.
+    Format AccessLogFormat
+    // Size, in bytes, after which the file is rotated. If zero, the file
+    // is never rotated.
+    MaxSize int64
+    // How many rotated files are kept (as "Filename.1", "Filename.2",
+    // ...). If zero, the file is truncated when rotated.
+    MaxBackups int
+}
+
 // Public interface for configuring a `http.Server`.
 type Server interface {
-    // Add a new `Handler` to the list.
-    AddHandler(handler Handler) error
+    // Add a new `Handler` to the list. If any method is supplied, the
+    // handler only accepts requests with one of those methods. Other
+    // methods are rejected with a 405 (Method Not Allowed).
+    AddHandler(handler Handler, methods ...string) error
     // Configure the default handler, selected in case the requested URL
     // does not match any other handler.
     SetDefault(prefix string) error
+    // Configure Cross-Origin Resource Sharing (CORS) for every handler,
+    // including automatically replying to preflight requests.
+    SetCORS(cfg CORSConfig) error
+    // Log every request to an access log. The log is closed alongside the
+    // `ListeningServer`.
+    SetAccessLog(cfg AccessLogConfig) error
     // Start a new `ListeningServer`, on the requested "host:port", in a
     // separated Goroutine.
     //
@@ -0,0 +1,160 @@
+// Cross-Origin Resource Sharing (CORS) for the server. When configured,
+// every response to an allowed origin carries the CORS headers, and
+// preflight requests (OPTIONS with an `Access-Control-Request-Method`)
+// are replied automatically, without reaching any `Handler`.
+
+package server
+
+import (
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// Methods allowed in cross-origin requests, if none is configured.
+var defaultCORSMethods = []string {
+    http.MethodGet,
+    http.MethodHead,
+    http.MethodPost,
+    http.MethodPut,
+    http.MethodPatch,
+    http.MethodDelete,
+}
+
+// Headers allowed in cross-origin requests, if none is configured.
+var defaultCORSHeaders = []string {
+    "Accept",
+    "Content-Type",
+}
+
+// Pre-processed CORS configuration.
+type cors struct {
+    // Whether any origin is allowed.
+    anyOrigin bool
+    // Allowed origins, normalized to lower case.
+    origins map[string]bool
+    // Allowed methods, normalized to upper case.
+    methods map[string]bool
+    // Value of the `Access-Control-Allow-Methods` header.
+    allowMethods string
+    // Whether any header is allowed.
+    anyHeader bool
+    // Value of the `Access-Control-Allow-Headers` header.
+    allowHeaders string
+    // Value of the `Access-Control-Expose-Headers` header.
+    exposeHeaders string
+    // Value of the `Access-Control-Max-Age` header.
+    maxAge string
+}
+
+// Pre-process a CORS configuration.
+func newCORS(cfg srv_iface.CORSConfig) *cors {
+    c := cors {
+        origins: make(map[string]bool),
+        methods: make(map[string]bool),
+    }
+
+    for _, origin := range cfg.AllowedOrigins {
+        if origin == "*" {
+            c.anyOrigin = true
+        }
+        c.origins[strings.ToLower(origin)] = true
+    }
+
+    methods := cfg.AllowedMethods
+    if len(methods) == 0 {
+        methods = defaultCORSMethods
+    }
+    var allowMethods []string
+    for _, method := range methods {
+        method = strings.ToUpper(method)
+        c.methods[method] = true
+        allowMethods = append(allowMethods, method)
+    }
+    c.allowMethods = strings.Join(allowMethods, ", ")
+
+    headers := cfg.AllowedHeaders
+    if len(headers) == 0 {
+        headers = defaultCORSHeaders
+    }
+    for _, header := range headers {
+        if header == "*" {
+            c.anyHeader = true
+        }
+    }
+    c.allowHeaders = strings.Join(headers, ", ")
+    c.exposeHeaders = strings.Join(cfg.ExposedHeaders, ", ")
+
+    if cfg.MaxAge > 0 {
+        c.maxAge = strconv.FormatInt(int64(cfg.MaxAge.Seconds()), 10)
+    }
+
+    return &c
+}
+
+// Check whether a given origin may access the server.
+func (c *cors) allowOrigin(origin string) bool {
+    return c.anyOrigin || c.origins[strings.ToLower(origin)]
+}
+
+// Add the CORS headers to the response, if the request came from an
+// allowed origin. Preflight requests are replied directly, in which case
+// this returns true and the request must not be handled any further.
+func (c *cors) handle(w http.ResponseWriter, req *http.Request) (bool, error) {
+    origin := req.Header.Get("Origin")
+    if len(origin) == 0 {
+        // Not a cross-origin request
+        return false, nil
+    }
+
+    isPreflight := (req.Method == http.MethodOptions &&
+            len(req.Header.Get("Access-Control-Request-Method")) > 0)
+
+    hdr := w.Header()
+    hdr.Add("Vary", "Origin")
+    if !c.allowOrigin(origin) {
+        if isPreflight {
+            reason := "Origin not allowed: " + origin
+            return true, srv_iface.NewHttpError(nil, "web/server", reason, http.StatusForbidden)
+        }
+        // Let the browser block the response.
+        return false, nil
+    }
+
+    if c.anyOrigin {
+        hdr.Set("Access-Control-Allow-Origin", "*")
+    } else {
+        hdr.Set("Access-Control-Allow-Origin", origin)
+    }
+    if len(c.exposeHeaders) > 0 {
+        hdr.Set("Access-Control-Expose-Headers", c.exposeHeaders)
+    }
+
+    if !isPreflight {
+        return false, nil
+    }
+
+    method := strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+    if !c.methods[method] {
+        reason := "Method not allowed: " + method
+        return true, srv_iface.NewHttpError(nil, "web/server", reason, http.StatusForbidden)
+    }
+
+    hdr.Add("Vary", "Access-Control-Request-Method")
+    hdr.Add("Vary", "Access-Control-Request-Headers")
+    hdr.Set("Access-Control-Allow-Methods", c.allowMethods)
+    if reqHeaders := req.Header.Get("Access-Control-Request-Headers"); c.anyHeader && len(reqHeaders) > 0 {
+        // Echo the requested headers, since "*" isn't accepted by every
+        // browser.
+        hdr.Set("Access-Control-Allow-Headers", reqHeaders)
+    } else {
+        hdr.Set("Access-Control-Allow-Headers", c.allowHeaders)
+    }
+    if len(c.maxAge) > 0 {
+        hdr.Set("Access-Control-Max-Age", c.maxAge)
+    }
+    w.WriteHeader(http.StatusNoContent)
+
+    return true, nil
+}
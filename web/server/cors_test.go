@@ -0,0 +1,102 @@
+package server
+
+import (
+    "net/http"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestCORSPreflight(t *testing.T) {
+    s := newTestServer(t, newTestHandler("/a"))
+    s.cors = newCORS(srv_iface.CORSConfig {
+        AllowedOrigins: []string { "http://Allowed.com" },
+        AllowedMethods: []string { "get", "put" },
+        AllowedHeaders: []string { "Content-Type", "If-Match" },
+        MaxAge: time.Minute,
+    })
+
+    for _, tc := range []struct {
+        desc string
+        origin, method, headers string
+        status int
+        allowOrigin, allowHeaders string
+        vary []string
+    } {
+        {
+            "allowed", "http://allowed.com", "PUT", "if-match",
+            http.StatusNoContent, "http://allowed.com", "Content-Type, If-Match",
+            []string { "Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers" },
+        },
+        {
+            "disallowed origin", "http://other.com", "PUT", "",
+            http.StatusForbidden, "", "", []string { "Origin" },
+        },
+        {
+            "disallowed method", "http://allowed.com", "DELETE", "",
+            http.StatusForbidden, "http://allowed.com", "", []string { "Origin" },
+        },
+    } {
+        hdr := []string { "Origin", tc.origin, "Access-Control-Request-Method", tc.method }
+        if len(tc.headers) > 0 {
+            hdr = append(hdr, "Access-Control-Request-Headers", tc.headers)
+        }
+        w := serveTest(s, http.MethodOptions, "/a", hdr...)
+
+        if w.Code != tc.status {
+            t.Errorf("%s: expected status %d, got %d", tc.desc, tc.status, w.Code)
+        }
+        if got := w.Header().Get("Access-Control-Allow-Origin"); got != tc.allowOrigin {
+            t.Errorf("%s: expected allowed origin '%s', got '%s'", tc.desc, tc.allowOrigin, got)
+        }
+        if got := w.Header().Get("Access-Control-Allow-Headers"); got != tc.allowHeaders {
+            t.Errorf("%s: expected allowed headers '%s', got '%s'", tc.desc, tc.allowHeaders, got)
+        }
+        if got := w.Header()["Vary"]; !reflect.DeepEqual(got, tc.vary) {
+            t.Errorf("%s: expected Vary %v, got %v", tc.desc, tc.vary, got)
+        }
+        if tc.status == http.StatusNoContent {
+            if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET, PUT" {
+                t.Errorf("%s: expected allowed methods 'GET, PUT', got '%s'", tc.desc, got)
+            } else if got := w.Header().Get("Access-Control-Max-Age"); got != "60" {
+                t.Errorf("%s: expected max age '60', got '%s'", tc.desc, got)
+            } else if body := w.Body.String(); body != "" {
+                t.Errorf("%s: the preflight reached the handler ('%s')", tc.desc, body)
+            }
+        }
+    }
+}
+
+func TestCORSRequests(t *testing.T) {
+    s := newTestServer(t, newTestHandler("/a"))
+    s.cors = newCORS(srv_iface.CORSConfig {
+        AllowedOrigins: []string { "*" },
+        AllowedHeaders: []string { "*" },
+        ExposedHeaders: []string { "ETag" },
+    })
+
+    // Any header is allowed by echoing the requested ones
+    w := serveTest(s, http.MethodOptions, "/a", "Origin", "http://x.com",
+                   "Access-Control-Request-Method", "PATCH",
+                   "Access-Control-Request-Headers", "X-TTL, If-Match")
+    if got := w.Header().Get("Access-Control-Allow-Headers"); got != "X-TTL, If-Match" {
+        t.Errorf("Preflight: expected the requested headers, got '%s'", got)
+    }
+
+    // Simple requests reach the handler, with the CORS headers
+    w = serveTest(s, http.MethodGet, "/a", "Origin", "http://x.com")
+    if w.Code != http.StatusOK || w.Body.String() != "/a" {
+        t.Errorf("GET: expected the handler's response, got %d '%s'", w.Code, w.Body.String())
+    } else if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+        t.Errorf("GET: expected any origin to be allowed, got '%s'", got)
+    } else if got := w.Header().Get("Access-Control-Expose-Headers"); got != "ETag" {
+        t.Errorf("GET: expected 'ETag' to be exposed, got '%s'", got)
+    }
+
+    // Same-origin requests don't get any CORS header
+    w = serveTest(s, http.MethodGet, "/a")
+    if got := w.Header().Get("Vary"); got != "" {
+        t.Errorf("GET (same origin): expected no CORS header, got Vary '%s'", got)
+    }
+}
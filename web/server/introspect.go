@@ -0,0 +1,244 @@
+// Built-in handlers for monitoring the server:
+//
+//   * `/_health`: Whether the server is listening, and its handlers;
+//   * `/_routes`: Every registered prefix, with its dependencies and
+//     accepted methods;
+//   * `/_metrics`: Metrics in Prometheus' text format.
+//
+// The first two reply with a JSON object. These handlers are added
+// automatically by `Listen()`, unless the prefix is already used by
+// another handler.
+
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "net/http"
+    "sort"
+    "strings"
+)
+
+const (
+    HealthPrefix = "/_health"
+    RoutesPrefix = "/_routes"
+    MetricsPrefix = "/_metrics"
+)
+
+// A handler built into the server, with direct access to its state.
+type builtinHandler struct {
+    // Base path for the handler.
+    prefix string
+    // The server being inspected.
+    srv *runningServer
+    // Handle a GET request. The server isn't locked, so `get` must lock
+    // `closing` itself before accessing the handlers.
+    get func(s *runningServer, w http.ResponseWriter) error
+}
+
+// Retrieve the path handled by the `builtinHandler`.
+func (h *builtinHandler) Prefix() string {
+    return h.prefix
+}
+
+// List every other service used by this handler.
+func (*builtinHandler) Dependencies() []string {
+    return nil
+}
+
+// Handle requests to the built-in handler. Built-in handlers are
+// registered accepting only GETs, so the method needn't be checked.
+func (h *builtinHandler) Handle(w http.ResponseWriter, req *http.Request, urlPath []string) error {
+    if len(urlPath) != 1 {
+        reason := "URL must be only " + h.prefix
+        return srv_iface.NewHttpError(nil, "web/server", reason, http.StatusNotFound)
+    }
+
+    return h.get(h.srv, w)
+}
+
+// Close resources associated with the `builtinHandler` (i.e, nothing)
+func (*builtinHandler) Close() {
+}
+
+// Send `resp` encoded as a JSON object.
+func replyJSON(w http.ResponseWriter, resp interface{}) error {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    enc := json.NewEncoder(w)
+    err := enc.Encode(resp)
+    if err != nil {
+        logger.Errorf("web/server: Failed to encode the response: %+v (payload: %+v)", err, resp)
+    }
+    return nil
+}
+
+// Retrieve the sorted list of prefixes handled by the server. Must be
+// called with `closing` locked.
+func (s *runningServer) unsafeListPrefixes() []string {
+    prefixes := make([]string, 0, len(s.handlers))
+    for _, e := range s.handlers {
+        prefixes = append(prefixes, e.handler.Prefix())
+    }
+    sort.Strings(prefixes)
+    return prefixes
+}
+
+// Response of a GET `/_health`.
+type healthResponse struct {
+    // Either "ok" or "down".
+    Status string
+    // Whether the server is accepting connections.
+    Listening bool
+    // Every registered prefix.
+    Handlers []string
+    // Prefix of the default handler, if any.
+    Default string `json:",omitempty"`
+}
+
+// Report whether the server is listening, and its handlers.
+func getHealth(s *runningServer, w http.ResponseWriter) error {
+    s.closing.RLock()
+    resp := healthResponse {
+        Status: "down",
+        Listening: s.isListening(),
+        Handlers: s.unsafeListPrefixes(),
+    }
+    if s.defaultHandler != nil {
+        resp.Default = s.defaultHandler.handler.Prefix()
+    }
+    s.closing.RUnlock()
+    if resp.Listening {
+        resp.Status = "ok"
+    }
+
+    return replyJSON(w, &resp)
+}
+
+// A single route, as reported by a GET `/_routes`.
+type route struct {
+    // Base path for the handler.
+    Prefix string
+    // Every other service used by this handler.
+    Dependencies []string
+    // Methods accepted by the handler. Omitted if every method is
+    // accepted.
+    Methods []string `json:",omitempty"`
+    // Whether this is the default handler.
+    Default bool
+}
+
+// Report every registered prefix, with its dependencies and methods.
+func getRoutes(s *runningServer, w http.ResponseWriter) error {
+    var resp struct {
+        Routes []route
+    }
+
+    s.closing.RLock()
+    resp.Routes = make([]route, 0, len(s.handlers))
+    for _, e := range s.handlers {
+        h := e.handler
+        deps := h.Dependencies()
+        if deps == nil {
+            deps = []string{}
+        }
+
+        r := route {
+            Prefix: h.Prefix(),
+            Dependencies: deps,
+            Methods: e.methods,
+            Default: (e == s.defaultHandler),
+        }
+        resp.Routes = append(resp.Routes, r)
+    }
+    s.closing.RUnlock()
+    sort.Slice(resp.Routes, func(i, j int) bool {
+        return resp.Routes[i].Prefix < resp.Routes[j].Prefix
+    })
+
+    return replyJSON(w, &resp)
+}
+
+// Report the request metrics and the metrics of every `MetricsHandler`.
+func getMetrics(s *runningServer, w http.ResponseWriter) error {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    w.WriteHeader(http.StatusOK)
+
+    s.metrics.write(w)
+
+    up := 0
+    if s.isListening() {
+        up = 1
+    }
+    fmt.Fprintf(w, "# HELP gfm_up Whether the server is accepting connections.\n")
+    fmt.Fprintf(w, "# TYPE gfm_up gauge\n")
+    fmt.Fprintf(w, "gfm_up %d\n", up)
+
+    var metrics []srv_iface.Metric
+    s.closing.RLock()
+    for _, e := range s.handlers {
+        if mh, ok := e.handler.(srv_iface.MetricsHandler); ok {
+            metrics = append(metrics, mh.Metrics()...)
+        }
+    }
+    s.closing.RUnlock()
+
+    // Samples with the same name must be grouped under a single HELP and
+    // TYPE, even if reported by different handlers.
+    sort.SliceStable(metrics, func(i, j int) bool {
+        return metrics[i].Name < metrics[j].Name
+    })
+    for i, m := range metrics {
+        name := "gfm_" + m.Name
+        if i == 0 || metrics[i - 1].Name != m.Name {
+            fmt.Fprintf(w, "# HELP %s %s\n", name, m.Help)
+            fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+        }
+        fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(m.Labels), formatFloat(m.Value))
+    }
+
+    return nil
+}
+
+// Format a set of labels as expected by Prometheus (e.g., `{a="b"}`),
+// sorted by name. Returns an empty string if there are no labels.
+func formatLabels(labels map[string]string) string {
+    if len(labels) == 0 {
+        return ""
+    }
+
+    names := make([]string, 0, len(labels))
+    for name := range labels {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    list := make([]string, 0, len(names))
+    for _, name := range names {
+        list = append(list, fmt.Sprintf("%s=%q", name, labels[name]))
+    }
+    return "{" + strings.Join(list, ",") + "}"
+}
+
+// Retrieve every built-in handler for the server.
+func newBuiltinHandlers(s *runningServer) []srv_iface.Handler {
+    return []srv_iface.Handler {
+        &builtinHandler {
+            prefix: HealthPrefix,
+            srv: s,
+            get: getHealth,
+        },
+        &builtinHandler {
+            prefix: RoutesPrefix,
+            srv: s,
+            get: getRoutes,
+        },
+        &builtinHandler {
+            prefix: MetricsPrefix,
+            srv: s,
+            get: getMetrics,
+        },
+    }
+}
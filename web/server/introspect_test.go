@@ -0,0 +1,98 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "reflect"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "strings"
+    "testing"
+)
+
+func TestHealth(t *testing.T) {
+    s := newTestServer(t, newTestHandler("/a"))
+
+    w := serveTest(s, http.MethodGet, HealthPrefix)
+    var got healthResponse
+    err := json.NewDecoder(w.Body).Decode(&got)
+    if err != nil {
+        t.Fatalf("Failed to decode the response: %+v", err)
+    }
+
+    exp := healthResponse {
+        Status: "down",
+        Handlers: []string { HealthPrefix, MetricsPrefix, RoutesPrefix, "/a" },
+    }
+    if !reflect.DeepEqual(got, exp) {
+        t.Errorf("Expected %+v, got %+v", exp, got)
+    }
+
+    w = serveTest(s, http.MethodPost, HealthPrefix)
+    if w.Code != http.StatusMethodNotAllowed {
+        t.Errorf("POST: expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+    }
+}
+
+func TestRoutes(t *testing.T) {
+    s := newTestServer(t, newTestHandler("/a"))
+    err := s.AddHandler(newTestHandler("/b/:id", "/a"), http.MethodPut)
+    if err != nil {
+        t.Fatalf("Failed to add '/b/:id': %+v", err)
+    }
+
+    w := serveTest(s, http.MethodGet, RoutesPrefix)
+    var got struct {
+        Routes []route
+    }
+    err = json.NewDecoder(w.Body).Decode(&got)
+    if err != nil {
+        t.Fatalf("Failed to decode the response: %+v", err)
+    }
+
+    get := []string { http.MethodGet }
+    exp := []route {
+        { HealthPrefix, []string{}, get, false },
+        { MetricsPrefix, []string{}, get, false },
+        { RoutesPrefix, []string{}, get, false },
+        { "/a", []string{}, nil, false },
+        { "/b/:id", []string { "/a" }, []string { http.MethodPut }, false },
+    }
+    if !reflect.DeepEqual(got.Routes, exp) {
+        t.Errorf("Expected %+v, got %+v", exp, got.Routes)
+    }
+}
+
+func TestMetrics(t *testing.T) {
+    a := newTestHandler("/a")
+    a.metrics = []srv_iface.Metric {
+        { Name: "topic_messages", Help: "Messages.", Value: 1, Labels: map[string]string { "topic": "x" } },
+        { Name: "entries", Help: "Entries.", Value: 3 },
+    }
+    b := newTestHandler("/b")
+    b.metrics = []srv_iface.Metric {
+        { Name: "topic_messages", Help: "Messages.", Value: 2.5, Labels: map[string]string { "topic": "y", "a": "\"q\"" } },
+    }
+    s := newTestServer(t, a, b)
+
+    serveTest(s, http.MethodGet, "/a")
+    w := serveTest(s, http.MethodGet, MetricsPrefix)
+    body := w.Body.String()
+
+    for _, line := range []string {
+        `gfm_http_requests_total{prefix="/a",status="200"} 1`,
+        `gfm_http_request_duration_seconds_count{prefix="/a",status="200"} 1`,
+        "gfm_up 0",
+        "# TYPE gfm_entries gauge\ngfm_entries 3\n",
+        "# HELP gfm_topic_messages Messages.\n# TYPE gfm_topic_messages gauge\n" +
+            "gfm_topic_messages{topic=\"x\"} 1\n" +
+            "gfm_topic_messages{a=\"\\\"q\\\"\",topic=\"y\"} 2.5\n",
+    } {
+        if !strings.Contains(body, line) {
+            t.Errorf("Expected the metrics to contain:\n%s\ngot:\n%s", line, body)
+        }
+    }
+
+    if n := strings.Count(body, "# TYPE gfm_topic_messages "); n != 1 {
+        t.Errorf("Expected a single TYPE for 'gfm_topic_messages', got %d", n)
+    }
+}
@@ -0,0 +1,169 @@
+// Collect metrics about the requests handled by the server, exporting
+// them in Prometheus' text format.
+
+package server
+
+import (
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// Upper bounds, in seconds, of the buckets in the latency histogram.
+var latencyBuckets = []float64 {
+    0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// Identify a group of requests tracked by the metrics.
+type requestKey struct {
+    // Prefix of the handler that handled the request.
+    prefix string
+    // Status code of the response.
+    status int
+}
+
+// Latency histogram for a group of requests.
+type requestStats struct {
+    // Number of requests in each bucket of `latencyBuckets`, cumulatively.
+    buckets []uint64
+    // Total duration of every request, in seconds.
+    sum float64
+    // Number of requests.
+    count uint64
+}
+
+// Metrics collected by the server.
+type metrics struct {
+    // Statistics for every group of requests.
+    requests map[requestKey]*requestStats
+    // Synchronize access to the metrics.
+    mut sync.Mutex
+}
+
+// Record a single request.
+func (m *metrics) record(prefix string, status int, duration time.Duration) {
+    m.mut.Lock()
+    defer m.mut.Unlock()
+
+    if m.requests == nil {
+        m.requests = make(map[requestKey]*requestStats)
+    }
+
+    key := requestKey {
+        prefix: prefix,
+        status: status,
+    }
+    stats, ok := m.requests[key]
+    if !ok {
+        stats = &requestStats {
+            buckets: make([]uint64, len(latencyBuckets)),
+        }
+        m.requests[key] = stats
+    }
+
+    secs := duration.Seconds()
+    for i, bound := range latencyBuckets {
+        if secs <= bound {
+            stats.buckets[i]++
+        }
+    }
+    stats.sum += secs
+    stats.count++
+}
+
+// Format a float as expected by Prometheus.
+func formatFloat(f float64) string {
+    return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Write the request metrics in Prometheus' text format.
+func (m *metrics) write(w io.Writer) {
+    m.mut.Lock()
+    defer m.mut.Unlock()
+
+    // Sort the keys, so the output is stable.
+    var keys []requestKey
+    for k := range m.requests {
+        keys = append(keys, k)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        if keys[i].prefix != keys[j].prefix {
+            return keys[i].prefix < keys[j].prefix
+        }
+        return keys[i].status < keys[j].status
+    })
+
+    fmt.Fprintf(w, "# HELP gfm_http_requests_total Number of handled HTTP requests.\n")
+    fmt.Fprintf(w, "# TYPE gfm_http_requests_total counter\n")
+    for _, k := range keys {
+        fmt.Fprintf(w, "gfm_http_requests_total{prefix=%q,status=\"%d\"} %d\n", k.prefix, k.status, m.requests[k].count)
+    }
+
+    fmt.Fprintf(w, "# HELP gfm_http_request_duration_seconds Latency of handled HTTP requests.\n")
+    fmt.Fprintf(w, "# TYPE gfm_http_request_duration_seconds histogram\n")
+    for _, k := range keys {
+        stats := m.requests[k]
+        labels := fmt.Sprintf("prefix=%q,status=\"%d\"", k.prefix, k.status)
+
+        for i, bound := range latencyBuckets {
+            fmt.Fprintf(w, "gfm_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound), stats.buckets[i])
+        }
+        fmt.Fprintf(w, "gfm_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, stats.count)
+        fmt.Fprintf(w, "gfm_http_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(stats.sum))
+        fmt.Fprintf(w, "gfm_http_request_duration_seconds_count{%s} %d\n", labels, stats.count)
+    }
+}
+
+// Wrap a `http.ResponseWriter`, recording the status and the size of the
+// response.
+type responseRecorder struct {
+    // The wrapped writer.
+    http.ResponseWriter
+    // Status code sent in the response.
+    status int
+    // Number of bytes sent in the response's body.
+    size int64
+}
+
+// Record the status code and forward it to the wrapped writer.
+func (r *responseRecorder) WriteHeader(status int) {
+    if r.status == 0 {
+        r.status = status
+    }
+    r.ResponseWriter.WriteHeader(status)
+}
+
+// Record the size of the response and forward it to the wrapped writer.
+func (r *responseRecorder) Write(data []byte) (int, error) {
+    if r.status == 0 {
+        r.status = http.StatusOK
+    }
+    n, err := r.ResponseWriter.Write(data)
+    r.size += int64(n)
+    return n, err
+}
+
+// Flush the wrapped writer, if it supports flushing.
+func (r *responseRecorder) Flush() {
+    if f, ok := r.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+// Retrieve the wrapped writer, for `http.ResponseController`.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+    return r.ResponseWriter
+}
+
+// Retrieve the status sent in the response. If nothing was sent, Go's
+// http package replies with a 200.
+func (r *responseRecorder) getStatus() int {
+    if r.status == 0 {
+        return http.StatusOK
+    }
+    return r.status
+}
@@ -0,0 +1,221 @@
+// Routing tree used to select the `Handler` for a request.
+//
+// A prefix may have multiple segments (e.g., "/gfm/api"), and segments
+// starting with a ':' (e.g., "/users/:id") match any non-empty segment,
+// which is then passed to the handler as a path parameter. A request is
+// handled by the handler with the longest matching prefix, with literal
+// segments taking precedence over parameters.
+
+package server
+
+import (
+    "net/http"
+    "path"
+    "strings"
+)
+
+// A node in the routing tree. Each node represents a segment in a prefix.
+type routeNode struct {
+    // Children with a literal segment.
+    children map[string]*routeNode
+    // Child matching any segment, if any.
+    param *routeNode
+    // Name of the parameter matched by this node, if it's a `param` node.
+    paramName string
+    // Handler registered at this node, if any.
+    entry *handlerEntry
+}
+
+// A successful lookup in the routing tree.
+type routeMatch struct {
+    // The matched handler.
+    entry *handlerEntry
+    // How many segments of the URL were matched by the prefix.
+    depth int
+    // Value of every parameter in the prefix.
+    params map[string]string
+}
+
+// Split a prefix into its segments, checking that it's valid.
+func splitPrefix(prefix string) ([]string, error) {
+    if len(prefix) == 0 {
+        return nil, BadPrefix
+    } else if prefix[0] != '/' {
+        return nil, NonRootPrefix
+    } else if prefix == "/" || path.Clean(prefix) != prefix {
+        return nil, BadPrefix
+    }
+
+    segments := strings.Split(prefix[1:], "/")
+    for _, seg := range segments {
+        if seg == ":" {
+            return nil, BadPrefix
+        }
+    }
+    return segments, nil
+}
+
+// Normalize a list of methods, so they may be easily compared.
+func normalizeMethods(methods []string) []string {
+    var list []string
+    for _, m := range methods {
+        list = append(list, strings.ToUpper(m))
+    }
+    return list
+}
+
+// Check whether `entry` accepts requests with `method`. Handlers without
+// any method restriction accept every method, and HEAD is accepted by
+// every handler that accepts GET.
+func (entry *handlerEntry) acceptsMethod(method string) bool {
+    if len(entry.methods) == 0 {
+        return true
+    }
+
+    for _, m := range entry.methods {
+        if m == method || (m == http.MethodGet && method == http.MethodHead) {
+            return true
+        }
+    }
+    return false
+}
+
+// Retrieve the node for the segments of a prefix, optionally creating
+// every missing node.
+func (n *routeNode) walk(segments []string, create bool) *routeNode {
+    for _, seg := range segments {
+        var next *routeNode
+
+        if seg[0] == ':' {
+            next = n.param
+            if next == nil && create {
+                next = &routeNode {
+                    paramName: seg[1:],
+                }
+                n.param = next
+            }
+        } else {
+            next = n.children[seg]
+            if next == nil && create {
+                if n.children == nil {
+                    n.children = make(map[string]*routeNode)
+                }
+                next = &routeNode{}
+                n.children[seg] = next
+            }
+        }
+
+        if next == nil {
+            return nil
+        }
+        n = next
+    }
+    return n
+}
+
+// Add `entry` to the tree. Prefixes that only differ by the name of their
+// parameters are considered to be the same.
+func (n *routeNode) insert(entry *handlerEntry) error {
+    segments, err := splitPrefix(entry.handler.Prefix())
+    if err != nil {
+        return err
+    }
+
+    node := n.walk(segments, false)
+    if node != nil && node.entry != nil {
+        return RepeatedPrefix
+    }
+
+    // Parameters at the same position must have the same name, otherwise
+    // the name reported to the handler would depend on the order of
+    // registration.
+    cur := n
+    for _, seg := range segments {
+        if seg[0] == ':' {
+            if cur.param != nil && cur.param.paramName != seg[1:] {
+                return RepeatedPrefix
+            }
+            cur = cur.param
+        } else {
+            cur = cur.children[seg]
+        }
+        if cur == nil {
+            break
+        }
+    }
+
+    n.walk(segments, true).entry = entry
+    return nil
+}
+
+// Remove the handler associated with `prefix` from the tree. Nodes left
+// empty are pruned, so the name of a removed parameter may be reused.
+func (n *routeNode) remove(prefix string) {
+    segments, err := splitPrefix(prefix)
+    if err != nil {
+        return
+    }
+
+    n.unlink(segments)
+}
+
+// Remove the handler at the end of `segments`, returning whether `n` was
+// left empty.
+func (n *routeNode) unlink(segments []string) bool {
+    if len(segments) == 0 {
+        n.entry = nil
+    } else if seg := segments[0]; seg[0] == ':' {
+        if n.param != nil && n.param.unlink(segments[1:]) {
+            n.param = nil
+        }
+    } else if child, ok := n.children[seg]; ok && child.unlink(segments[1:]) {
+        delete(n.children, seg)
+    }
+
+    return n.entry == nil && n.param == nil && len(n.children) == 0
+}
+
+// Replace the handler associated with `entry`'s prefix.
+func (n *routeNode) replace(entry *handlerEntry) {
+    segments, err := splitPrefix(entry.handler.Prefix())
+    if err != nil {
+        return
+    }
+
+    if node := n.walk(segments, false); node != nil {
+        node.entry = entry
+    }
+}
+
+// Find the handler with the longest prefix matching `urlPath`, starting
+// at `depth`.
+func (n *routeNode) find(urlPath []string, depth int) *routeMatch {
+    var best *routeMatch
+
+    if depth < len(urlPath) {
+        seg := urlPath[depth]
+
+        if child, ok := n.children[seg]; ok {
+            best = child.find(urlPath, depth + 1)
+        }
+
+        if n.param != nil && len(seg) > 0 {
+            m := n.param.find(urlPath, depth + 1)
+            if m != nil && (best == nil || m.depth > best.depth) {
+                if m.params == nil {
+                    m.params = make(map[string]string)
+                }
+                m.params[n.param.paramName] = seg
+                best = m
+            }
+        }
+    }
+
+    if best == nil && n.entry != nil {
+        best = &routeMatch {
+            entry: n.entry,
+            depth: depth,
+        }
+    }
+    return best
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+    "net/http"
+    "reflect"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "strings"
+    "testing"
+)
+
+func TestSplitPrefix(t *testing.T) {
+    for _, tc := range []struct {
+        prefix string
+        err error
+    } {
+        { "/a/:id/b", nil },
+        { "", BadPrefix },
+        { "a", NonRootPrefix },
+        { "/", BadPrefix },
+        { "/a/", BadPrefix },
+        { "/a//b", BadPrefix },
+        { "/a/../b", BadPrefix },
+        { "/a/:", BadPrefix },
+    } {
+        if _, err := splitPrefix(tc.prefix); err != tc.err {
+            t.Errorf("'%s': expected %v, got %v", tc.prefix, tc.err, err)
+        }
+    }
+}
+
+func TestRouteLookup(t *testing.T) {
+    var root routeNode
+    for _, prefix := range []string {
+        "/a",
+        "/a/b/c",
+        "/users/:id",
+        "/users/me",
+        "/users/:id/posts/:post",
+        "/files/:name/raw",
+    } {
+        err := root.insert(&handlerEntry { handler: newTestHandler(prefix) })
+        if err != nil {
+            t.Fatalf("Failed to insert '%s': %+v", prefix, err)
+        }
+    }
+
+    for _, tc := range []struct {
+        url string
+        prefix string
+        depth int
+        params map[string]string
+    } {
+        { "a", "/a", 1, nil },
+        { "a/b", "/a", 1, nil },
+        { "a/b/c/d", "/a/b/c", 3, nil },
+        { "users/me", "/users/me", 2, nil },
+        { "users/me/x", "/users/me", 2, nil },
+        { "users/42", "/users/:id", 2, map[string]string { "id": "42" } },
+        { "users/me/posts/7", "/users/:id/posts/:post", 4, map[string]string { "id": "me", "post": "7" } },
+        { "users/42/posts", "/users/:id", 2, map[string]string { "id": "42" } },
+        { "files/x/raw/y", "/files/:name/raw", 3, map[string]string { "name": "x" } },
+        { "files/x", "", 0, nil },
+        { "users", "", 0, nil },
+        { "b", "", 0, nil },
+    } {
+        m := root.find(strings.Split(tc.url, "/"), 0)
+        if m == nil {
+            if tc.prefix != "" {
+                t.Errorf("'%s': expected '%s', got no match", tc.url, tc.prefix)
+            }
+            continue
+        } else if tc.prefix == "" {
+            t.Errorf("'%s': expected no match, got '%s'", tc.url, m.entry.handler.Prefix())
+            continue
+        }
+
+        if got := m.entry.handler.Prefix(); got != tc.prefix || m.depth != tc.depth {
+            t.Errorf("'%s': expected '%s' (depth %d), got '%s' (depth %d)", tc.url, tc.prefix, tc.depth, got, m.depth)
+        } else if !reflect.DeepEqual(m.params, tc.params) {
+            t.Errorf("'%s': expected params %v, got %v", tc.url, tc.params, m.params)
+        }
+    }
+}
+
+func TestRouteConflicts(t *testing.T) {
+    var root routeNode
+    insert := func(prefix string) error {
+        return root.insert(&handlerEntry { handler: newTestHandler(prefix) })
+    }
+
+    if err := insert("/users/:id"); err != nil {
+        t.Fatalf("Failed to insert '/users/:id': %+v", err)
+    }
+
+    for _, tc := range []struct {
+        prefix string
+        err error
+    } {
+        { "/users/:id", RepeatedPrefix },
+        { "/users/:name", RepeatedPrefix },
+        { "/users/:name/posts", RepeatedPrefix },
+        { "/users/:id/posts", nil },
+        { "/users/:id/posts/:post", nil },
+        { "/users/:id/posts/:p", RepeatedPrefix },
+        { "/users/me", nil },
+    } {
+        if err := insert(tc.prefix); err != tc.err {
+            t.Errorf("'%s': expected %v, got %v", tc.prefix, tc.err, err)
+        }
+    }
+}
+
+func TestRouteMethods(t *testing.T) {
+    s := newTestServer(t, newTestHandler("/any"))
+    err := s.AddHandler(newTestHandler("/items/:id"), "get", "put")
+    if err != nil {
+        t.Fatalf("Failed to add '/items/:id': %+v", err)
+    }
+
+    for _, tc := range []struct {
+        method string
+        target string
+        status int
+    } {
+        { http.MethodGet, "/items/1", http.StatusOK },
+        { http.MethodHead, "/items/1", http.StatusOK },
+        { http.MethodPut, "/items/1", http.StatusOK },
+        { http.MethodDelete, "/items/1", http.StatusMethodNotAllowed },
+        { http.MethodPost, "/items/1", http.StatusMethodNotAllowed },
+        { http.MethodDelete, "/any", http.StatusOK },
+    } {
+        w := serveTest(s, tc.method, tc.target)
+        if w.Code != tc.status {
+            t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.target, tc.status, w.Code)
+        } else if allow := w.Header().Get("Allow"); tc.status == http.StatusMethodNotAllowed && allow != "GET, PUT" {
+            t.Errorf("%s %s: expected 'Allow: GET, PUT', got '%s'", tc.method, tc.target, allow)
+        }
+    }
+}
+
+func TestRouteRemoval(t *testing.T) {
+    var params map[string]string
+    h := newTestHandler("/users/:id")
+    h.handle = func(w http.ResponseWriter, req *http.Request, urlPath []string) error {
+        params = map[string]string { "id": srv_iface.PathParam(req, "id") }
+        w.Write([]byte(strings.Join(urlPath, ",")))
+        return nil
+    }
+    s := newTestServer(t, h, newTestHandler("/users"), newTestHandler("/users/me"))
+
+    if w := serveTest(s, http.MethodGet, "/users/42/x"); w.Body.String() != "users/42,x" {
+        t.Errorf("GET /users/42/x: expected 'users/42,x', got '%s'", w.Body.String())
+    } else if params["id"] != "42" {
+        t.Errorf("GET /users/42/x: expected the parameter '42', got %v", params)
+    }
+
+    // The next best prefix handles the request once the other is removed
+    for _, tc := range []struct {
+        remove string
+        target string
+        exp string
+    } {
+        { "/users/me", "/users/me", "users/me" },
+        { "/users/:id", "/users/42", "/users" },
+    } {
+        if err := s.RemoveHandler(tc.remove); err != nil {
+            t.Fatalf("Failed to remove '%s': %+v", tc.remove, err)
+        } else if w := serveTest(s, http.MethodGet, tc.target); w.Body.String() != tc.exp {
+            t.Errorf("GET %s after removing '%s': expected '%s', got '%s'", tc.target, tc.remove, tc.exp, w.Body.String())
+        }
+    }
+
+    // A removed prefix may be registered again, even with another name
+    err := s.AddHandler(newTestHandler("/users/:name"))
+    if err != nil {
+        t.Errorf("Failed to add '/users/:name' after removing '/users/:id': %+v", err)
+    }
+}
@@ -0,0 +1,193 @@
+// Runtime management of the `Handler`s in a `runningServer`.
+//
+// Modifying the list of handlers locks `closing` for writing, so requests
+// that are looking up a handler are never affected by a partial change.
+// Each handler tracks its pending requests, so a removed (or replaced)
+// handler is only closed after every request using it finishes, without
+// blocking requests to other handlers. Handlers with long-lived requests
+// should implement `StoppableHandler`, so those requests end before the
+// handler is closed. Otherwise, the handler is closed after
+// `drainTimeout`, even if requests are still pending.
+
+package server
+
+import (
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "time"
+)
+
+// Longest time a removed (or replaced) handler waits for its pending
+// requests before being closed.
+var drainTimeout = 10 * time.Second
+
+// Retrieve the index of the entry associated with `prefix`, or -1 if it
+// isn't registered. Must be called with `closing` locked.
+func (s *runningServer) unsafeIndex(prefix string) int {
+    for i, e := range s.handlers {
+        if e.handler.Prefix() == prefix {
+            return i
+        }
+    }
+    return -1
+}
+
+// Check that every dependency of `handler` is registered. Must be called
+// with `closing` locked.
+func (s *runningServer) unsafeCheckDependencies(handler srv_iface.Handler) error {
+    for _, dep := range handler.Dependencies() {
+        if s.unsafeIndex(dep) == -1 {
+            logger.Errorf("web/server: Missing dependency \"%s\" for service \"%s\"", dep, handler.Prefix())
+            return MissingDependency
+        }
+    }
+    return nil
+}
+
+// Stop every long-lived request in `entry`, wait until every pending
+// request finishes (for at most `drainTimeout`) and close it.
+func drainAndClose(entry *handlerEntry) {
+    if sh, ok := entry.handler.(srv_iface.StoppableHandler); ok {
+        sh.Stop()
+    }
+
+    drained := make(chan struct{})
+    go func() {
+        entry.pending.Wait()
+        close(drained)
+    } ()
+
+    timer := time.NewTimer(drainTimeout)
+    defer timer.Stop()
+    select {
+    case <-drained:
+    case <-timer.C:
+        logger.Warnf("web/server: Closing handler \"%s\" with pending requests", entry.handler.Prefix())
+    }
+
+    entry.handler.Close()
+    logger.Debugf("web/server: Closed handler \"%s\"", entry.handler.Prefix())
+}
+
+// Assign the listening port to `handler`, if needed.
+func (s *runningServer) setPort(handler srv_iface.Handler) {
+    if lh, ok := handler.(srv_iface.LoopbackHandler); ok && lh != nil {
+        lh.SetListeningPort(s.port)
+    }
+}
+
+// Add a new `Handler` to the running server. If any method is supplied,
+// the handler only accepts requests with one of those methods.
+func (s *runningServer) AddHandler(handler srv_iface.Handler, methods ...string) error {
+    prefix := handler.Prefix()
+    if _, err := splitPrefix(prefix); err != nil {
+        return err
+    }
+
+    s.closing.Lock()
+    defer s.closing.Unlock()
+
+    if s.closed {
+        return ServerClosed
+    } else if err := s.unsafeCheckDependencies(handler); err != nil {
+        return err
+    }
+
+    entry := &handlerEntry {
+        handler: handler,
+        methods: normalizeMethods(methods),
+    }
+    if err := s.routes.insert(entry); err != nil {
+        return err
+    }
+
+    s.setPort(handler)
+    s.handlers = append(s.handlers, entry)
+
+    logger.Infof("web/server: Added handler \"%s\"", prefix)
+    return nil
+}
+
+// Remove the `Handler` associated with `prefix`, closing it after every
+// pending request finishes.
+func (s *runningServer) RemoveHandler(prefix string) error {
+    s.closing.Lock()
+
+    if s.closed {
+        s.closing.Unlock()
+        return ServerClosed
+    }
+
+    idx := s.unsafeIndex(prefix)
+    if idx == -1 {
+        s.closing.Unlock()
+        return InvalidHandler
+    }
+
+    entry := s.handlers[idx]
+    if entry == s.defaultHandler {
+        s.closing.Unlock()
+        return HandlerInUse
+    }
+    for _, e := range s.handlers {
+        for _, dep := range e.handler.Dependencies() {
+            if dep == prefix {
+                s.closing.Unlock()
+                logger.Errorf("web/server: Service \"%s\" is used by \"%s\"", prefix, e.handler.Prefix())
+                return HandlerInUse
+            }
+        }
+    }
+
+    s.handlers = append(s.handlers[:idx], s.handlers[idx+1:]...)
+    s.routes.remove(prefix)
+    s.closing.Unlock()
+
+    logger.Infof("web/server: Removed handler \"%s\"", prefix)
+    drainAndClose(entry)
+    return nil
+}
+
+// Replace the `Handler` registered with the same prefix as `handler`,
+// closing the old one after every pending request finishes. The new
+// handler only accepts the supplied methods (or every method, if none is
+// supplied).
+func (s *runningServer) ReplaceHandler(handler srv_iface.Handler, methods ...string) error {
+    prefix := handler.Prefix()
+    if _, err := splitPrefix(prefix); err != nil {
+        return err
+    }
+
+    s.closing.Lock()
+
+    if s.closed {
+        s.closing.Unlock()
+        return ServerClosed
+    }
+
+    idx := s.unsafeIndex(prefix)
+    if idx == -1 {
+        s.closing.Unlock()
+        return InvalidHandler
+    } else if err := s.unsafeCheckDependencies(handler); err != nil {
+        s.closing.Unlock()
+        return err
+    }
+
+    s.setPort(handler)
+    old := s.handlers[idx]
+    entry := &handlerEntry {
+        handler: handler,
+        methods: normalizeMethods(methods),
+    }
+    s.handlers[idx] = entry
+    s.routes.replace(entry)
+    if old == s.defaultHandler {
+        s.defaultHandler = entry
+    }
+    s.closing.Unlock()
+
+    logger.Infof("web/server: Replaced handler \"%s\"", prefix)
+    drainAndClose(old)
+    return nil
+}
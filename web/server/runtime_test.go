@@ -0,0 +1,182 @@
+package server
+
+import (
+    "net/http"
+    "testing"
+    "time"
+)
+
+// A `testHandler` whose requests block until it's stopped.
+type blockingHandler struct {
+    *testHandler
+    // Closed when the handler is stopped.
+    stop chan struct{}
+    // Signaled whenever a request starts blocking.
+    started chan struct{}
+}
+
+// Create a new `blockingHandler` for `prefix`.
+func newBlockingHandler(prefix string) *blockingHandler {
+    h := &blockingHandler {
+        testHandler: newTestHandler(prefix),
+        stop: make(chan struct{}),
+        started: make(chan struct{}, 1),
+    }
+    h.handle = func(w http.ResponseWriter, req *http.Request, urlPath []string) error {
+        h.started <- struct{}{}
+        <-h.stop
+        w.WriteHeader(http.StatusOK)
+        return nil
+    }
+    return h
+}
+
+func (h *blockingHandler) Stop() {
+    close(h.stop)
+}
+
+// Check whether `h` was closed within `timeout`.
+func waitClosed(h *testHandler, timeout time.Duration) bool {
+    select {
+    case <-h.closed:
+        return true
+    case <-time.After(timeout):
+        return false
+    }
+}
+
+func TestAddRemoveHandler(t *testing.T) {
+    a := newTestHandler("/a")
+    s := newTestServer(t, a)
+
+    b := newTestHandler("/b", "/a")
+    if err := s.AddHandler(b); err != nil {
+        t.Fatalf("Failed to add '/b': %+v", err)
+    } else if w := serveTest(s, http.MethodGet, "/b/x"); w.Body.String() != "/b" {
+        t.Errorf("GET /b/x: expected '/b' to handle it, got %d '%s'", w.Code, w.Body.String())
+    }
+
+    if err := s.AddHandler(newTestHandler("/c", "/missing")); err != MissingDependency {
+        t.Errorf("Add '/c': expected %v, got %v", MissingDependency, err)
+    } else if err := s.AddHandler(newTestHandler("/a")); err != RepeatedPrefix {
+        t.Errorf("Add '/a' again: expected %v, got %v", RepeatedPrefix, err)
+    } else if err := s.RemoveHandler("/a"); err != HandlerInUse {
+        t.Errorf("Remove '/a': expected %v, got %v", HandlerInUse, err)
+    } else if err := s.RemoveHandler("/missing"); err != InvalidHandler {
+        t.Errorf("Remove '/missing': expected %v, got %v", InvalidHandler, err)
+    }
+
+    if err := s.RemoveHandler("/b"); err != nil {
+        t.Fatalf("Failed to remove '/b': %+v", err)
+    } else if !waitClosed(b, time.Second) {
+        t.Errorf("Remove '/b': the handler wasn't closed")
+    } else if w := serveTest(s, http.MethodGet, "/b"); w.Code != http.StatusNotFound {
+        t.Errorf("GET /b: expected status %d after removing it, got %d", http.StatusNotFound, w.Code)
+    }
+
+    b2 := newTestHandler("/b")
+    b2.handle = func(w http.ResponseWriter, req *http.Request, urlPath []string) error {
+        w.Write([]byte("new"))
+        return nil
+    }
+    s.AddHandler(newTestHandler("/b"))
+    if err := s.ReplaceHandler(b2); err != nil {
+        t.Fatalf("Failed to replace '/b': %+v", err)
+    } else if w := serveTest(s, http.MethodGet, "/b"); w.Body.String() != "new" {
+        t.Errorf("GET /b: expected the new handler, got '%s'", w.Body.String())
+    }
+
+    s.Close()
+    if err := s.AddHandler(newTestHandler("/d")); err != ServerClosed {
+        t.Errorf("Add after closing: expected %v, got %v", ServerClosed, err)
+    } else if !waitClosed(a, time.Second) || !waitClosed(b2, time.Second) {
+        t.Errorf("Close: every handler should have been closed")
+    }
+}
+
+func TestRemoveBlockedHandler(t *testing.T) {
+    h := newBlockingHandler("/stream")
+    s := newTestServer(t, h)
+
+    done := make(chan int)
+    go func() {
+        done <- serveTest(s, http.MethodGet, "/stream").Code
+    }()
+    <-h.started
+
+    // The handler is stopped, ending the blocked request, and then closed
+    removed := make(chan error)
+    go func() {
+        removed <- s.RemoveHandler("/stream")
+    }()
+    select {
+    case err := <-removed:
+        if err != nil {
+            t.Errorf("Failed to remove '/stream': %+v", err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatalf("RemoveHandler blocked while a request was pending")
+    }
+    if code := <-done; code != http.StatusOK {
+        t.Errorf("GET /stream: expected status %d, got %d", http.StatusOK, code)
+    } else if !waitClosed(h.testHandler, time.Second) {
+        t.Errorf("The handler wasn't closed")
+    }
+}
+
+func TestRemoveHandlerTimeout(t *testing.T) {
+    defer func(timeout time.Duration) {
+        drainTimeout = timeout
+    } (drainTimeout)
+    drainTimeout = 50 * time.Millisecond
+
+    // A handler that can't be stopped is closed after `drainTimeout`
+    release := make(chan struct{})
+    started := make(chan struct{})
+    h := newTestHandler("/slow")
+    h.handle = func(w http.ResponseWriter, req *http.Request, urlPath []string) error {
+        close(started)
+        <-release
+        return nil
+    }
+    s := newTestServer(t, h)
+
+    go serveTest(s, http.MethodGet, "/slow")
+    <-started
+
+    start := time.Now()
+    if err := s.ReplaceHandler(newTestHandler("/slow")); err != nil {
+        t.Errorf("Failed to replace '/slow': %+v", err)
+    } else if !waitClosed(h, time.Second) {
+        t.Errorf("The old handler wasn't closed")
+    } else if elapsed := time.Since(start); elapsed < drainTimeout {
+        t.Errorf("The old handler was closed after only %s", elapsed)
+    }
+    close(release)
+}
+
+func TestPanickingHandler(t *testing.T) {
+    h := newTestHandler("/panic")
+    h.handle = func(w http.ResponseWriter, req *http.Request, urlPath []string) error {
+        panic("test")
+    }
+    s := newTestServer(t, h)
+
+    func() {
+        // net/http recovers from panics in the handlers
+        defer func() {
+            recover()
+        } ()
+        serveTest(s, http.MethodGet, "/panic")
+    } ()
+
+    removed := make(chan error)
+    go func() {
+        removed <- s.RemoveHandler("/panic")
+    }()
+    select {
+    case <-removed:
+    case <-time.After(drainTimeout / 2):
+        t.Fatalf("RemoveHandler blocked after a handler panicked")
+    }
+}
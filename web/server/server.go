@@ -4,9 +4,17 @@
 // a `Listen()` call. After this point, the server won't accept any other
 // call!
 //
+// Handlers may be mounted on prefixes with multiple segments and path
+// parameters (see `router.go`).
+//
 // `Server.Listen()` returns a `ListeningServer`, which closes every
 // handler alongside the HTTP server. `ListeningServer` waits until there's
-// no pending request before closing its associated `Handler`s.
+// no pending request before closing its associated `Handler`s. Handlers
+// may also be added, removed and replaced while the server is running
+// (see `runtime.go`).
+//
+// Every server also has a few built-in handlers for monitoring it (see
+// `introspect.go`).
 
 package server
 
@@ -14,14 +22,21 @@ import (
     "fmt"
     "github.com/SirGFM/gfm-speedrun-overlay/logger"
     srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "net"
     "net/http"
     "net/url"
     "path"
     "strconv"
     "strings"
     "sync"
+    "sync/atomic"
+    "time"
 )
 
+// Machine-readable code for requests with a method not accepted by the
+// handler.
+const CodeBadMethod = "bad-method"
+
 // `error` used by this package.
 type ErrorCode uint
 const (
@@ -35,6 +50,14 @@ const (
     BadPort
     // Handler not registered (yet)
     InvalidHandler
+    // Couldn't listen on the requested address
+    ListenFailed
+    // The server has already been closed
+    ServerClosed
+    // A dependency of the handler isn't registered
+    MissingDependency
+    // The handler is used by another handler, or as the default one
+    HandlerInUse
 )
 
 // `Error()` implements the `error` interface for `ErrorCode`.
@@ -50,20 +73,59 @@ func (e ErrorCode) Error() string {
         return "Invalid port"
     case InvalidHandler:
         return "Handler not registered (yet)"
+    case ListenFailed:
+        return "Couldn't listen on the requested address"
+    case ServerClosed:
+        return "The server has already been closed"
+    case MissingDependency:
+        return "A dependency of the handler isn't registered"
+    case HandlerInUse:
+        return "The handler is used by another handler, or as the default one"
     default:
         return "Unknown error"
     }
 }
 
+// A `Handler` registered in a `runningServer`.
+type handlerEntry struct {
+    // The actual handler.
+    handler srv_iface.Handler
+    // Methods accepted by the handler. If empty, every method is accepted.
+    methods []string
+    // Requests currently being handled by `handler`.
+    pending sync.WaitGroup
+}
+
 type runningServer struct {
     // Go's default server that handles requests from clients.
     httpServer *http.Server
     // Default handler, used in case the URL doesn't match anything.
-    defaultHandler srv_iface.Handler
+    defaultHandler *handlerEntry
     // List of accepted `Handler`s.
-    handlers []srv_iface.Handler
-    // Synchronize access to handlers while closing
+    handlers []*handlerEntry
+    // Routing tree used to select the `Handler` for each request.
+    routes routeNode
+    // Synchronize access to handlers while closing, or while modifying
+    // the list of handlers.
     closing sync.RWMutex
+    // Whether the server has already been closed.
+    closed bool
+    // Port where the server is listening.
+    port int
+    // Metrics about the handled requests.
+    metrics metrics
+    // Whether the server is accepting connections. Must be accessed
+    // atomically!
+    listening int32
+    // Cross-Origin Resource Sharing configuration, if any.
+    cors *cors
+    // Log of every handled request, if any.
+    accessLog *accessLog
+}
+
+// Check whether the server is accepting connections.
+func (s *runningServer) isListening() bool {
+    return atomic.LoadInt32(&s.listening) != 0
 }
 
 // Check if a given `url` has `prefix` as its base path.
@@ -74,6 +136,37 @@ func comparePrefix(prefix, url string) bool {
 
 // ServeHTTP is called by Go's http package whenever a new HTTP request arrives
 func (s *runningServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+    start := time.Now()
+
+    id := getRequestID(req)
+    w.Header().Set(srv_iface.RequestIDHeader, id)
+    req = srv_iface.WithRequestID(req, id)
+
+    rec := &responseRecorder {
+        ResponseWriter: w,
+    }
+    prefix := s.serve(rec, req)
+
+    duration := time.Since(start)
+    s.metrics.record(prefix, rec.getStatus(), duration)
+    s.logAccess(req, id, rec, prefix, start, duration)
+}
+
+// Handle a single request, returning the prefix of the `Handler` that
+// handled it (or "none", if no handler matched the request).
+func (s *runningServer) serve(w http.ResponseWriter, req *http.Request) string {
+    prefix := "none"
+
+    // Add the CORS headers, replying directly to preflight requests
+    if s.cors != nil {
+        if done, err := s.cors.handle(w, req); done {
+            if herr, ok := err.(srv_iface.HttpError); ok {
+                srv_iface.ReplyHttpError(herr, w, req)
+            }
+            return "cors"
+        }
+    }
+
     // Normalize and strip the URL from its leading prefix (and slash)
     resUrl := path.Clean(req.URL.EscapedPath())
     if len(resUrl) > 0 && resUrl[0] == '/' {
@@ -92,7 +185,7 @@ func (s *runningServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
             reason := fmt.Sprintf("Couldn't normalize the requested resource (%s) - %+v", resUrl, err)
             status := http.StatusInternalServerError
             srv_iface.ReplyStatus("web/server", status, reason, w)
-            return
+            return prefix
         }
         urlPath = append(urlPath, cleanPath)
     }
@@ -104,93 +197,151 @@ func (s *runningServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
     status := http.StatusNotFound
     err404 = srv_iface.NewHttpError(nil, "web/server", reason, status)
 
-    // Look for, and execute, the associated prefix
+    // Look for the associated prefix. The lock is only held while looking
+    // up the handler, which is then kept alive by its pending counter.
     s.closing.RLock()
-    defer s.closing.RUnlock()
-    err = err404
-    for i := range s.handlers {
-        // Compare to the prefix skipping the leading '/'
-        if s.handlers[i].Prefix()[1:] == urlPath[0] {
-            err = s.handlers[i].Handle(w, req, urlPath)
-            break
+    var entry *handlerEntry
+    var handlerPath []string
+    if m := s.routes.find(urlPath, 0); m != nil {
+        entry = m.entry
+
+        // Merge the matched segments into the first member of the path
+        handlerPath = []string{strings.Join(urlPath[:m.depth], "/")}
+        handlerPath = append(handlerPath, urlPath[m.depth:]...)
+        if m.params != nil {
+            req = srv_iface.WithPathParams(req, m.params)
         }
-    }
+    } else if s.defaultHandler != nil {
+        // If the URL didn't match anything, try the default handler
+        entry = s.defaultHandler
 
-    // If the URL didn't match anything, try the default handler
-    if err == err404 && s.defaultHandler != nil {
         // Remove the leading '/' from the prefix
-        prefix := s.defaultHandler.Prefix()[1:]
+        handlerPath = []string{entry.handler.Prefix()[1:]}
+        handlerPath = append(handlerPath, urlPath...)
+    }
+    if entry != nil {
+        entry.pending.Add(1)
+    }
+    s.closing.RUnlock()
 
-        var defPath []string
-        defPath = append(defPath, prefix)
-        defPath = append(defPath, urlPath...)
-        err = s.defaultHandler.Handle(w, req, defPath)
+    err = err404
+    if entry != nil {
+        prefix = entry.handler.Prefix()
+        err = func() error {
+            // Deferred so a panicking handler may still be drained
+            defer entry.pending.Done()
+
+            if entry.acceptsMethod(req.Method) {
+                return entry.handler.Handle(w, req, handlerPath)
+            }
+            allow := strings.Join(entry.methods, ", ")
+            w.Header().Set("Allow", allow)
+            reason := "Invalid method: wanted one of " + allow
+            return srv_iface.NewCodedHttpError(nil, "web/server", CodeBadMethod, reason, http.StatusMethodNotAllowed)
+        }()
     }
 
-    if err == nil {
-        logger.Infof("%s: %s - OK", req.Method, resUrl)
-    } else if herr, ok := err.(srv_iface.HttpError); ok {
-        logger.Errorf("%s: %s - %s", req.Method, resUrl, herr.GetHttpStatus())
-        logger.Debugf("%+v", herr)
-        srv_iface.ReplyHttpError(herr, w)
-    } else {
+    if herr, ok := err.(srv_iface.HttpError); ok {
+        logger.Debugf("%s: %s - %s (%s)", req.Method, resUrl, herr.GetHttpStatus(), herr.GetCode())
+        srv_iface.ReplyHttpError(herr, w, req)
+    } else if err != nil {
         // Shouldn't happend
-        logger.Errorf("%s: %s - ERROR", req.Method, resUrl)
+        logger.Errorf("%s: %s - Unexpected error: %+v", req.Method, resUrl, err)
     }
+
+    return prefix
 }
 
 // Halts the `http.Server`, if still running
 func (s *runningServer) Close() {
+    s.closing.Lock()
     if s.httpServer != nil {
         s.httpServer.Close()
         s.httpServer = nil
     }
+    handlers := s.handlers
+    s.handlers = nil
+    s.defaultHandler = nil
+    s.closed = true
+    s.closing.Unlock()
+
     // Ensure no request is being handled before closing everything
-    s.closing.Lock()
-    defer s.closing.Unlock()
-    for len(s.handlers) > 0 {
-        s.handlers[0].Close()
-        s.handlers[0] = nil
-        s.handlers = s.handlers[1:]
+    for _, e := range handlers {
+        drainAndClose(e)
+    }
+
+    if s.accessLog != nil {
+        s.accessLog.Close()
     }
 }
 
 // Tracks the handlers to be used when starting a new `ListeningServer`.
 type setupServer struct {
     // Default handler, used in case the URL doesn't match anything.
-    defaultHandler srv_iface.Handler
+    defaultHandler *handlerEntry
+    // Cross-Origin Resource Sharing configuration, if any.
+    cors *cors
+    // Log of every handled request, if any.
+    accessLog *accessLog
     // List of `Handler`s, with their associated prefix for an easy and
     // fast lookup.
-    handlers map[string]srv_iface.Handler
+    handlers map[string]*handlerEntry
+    // Routing tree with every `Handler`.
+    routes routeNode
     // Whether this `setupServer` is still valid, or it cannot be used
     // anymore.
     valid bool
 }
 
-// Add a new `Handler` to the list.
-func (s *setupServer) AddHandler(handler srv_iface.Handler) error {
-    prefix := handler.Prefix()
-    if _, ok := s.handlers[prefix]; ok {
-        return RepeatedPrefix
-    } else if len(prefix) == 0 {
-        return BadPrefix
-    } else if prefix[0] != '/' {
-        return NonRootPrefix
+// Add a new `Handler` to the list. If any method is supplied, the
+// handler only accepts requests with one of those methods.
+func (s *setupServer) AddHandler(handler srv_iface.Handler, methods ...string) error {
+    entry := &handlerEntry {
+        handler: handler,
+        methods: normalizeMethods(methods),
     }
 
-    s.handlers[prefix] = handler
+    err := s.routes.insert(entry)
+    if err != nil {
+        return err
+    }
+
+    s.handlers[handler.Prefix()] = entry
     return nil
 }
 
 // Configure the default handler, selected in case the requested URL
 // does not match any other handler.
 func (s *setupServer) SetDefault(prefix string) error {
-    handler, ok := s.handlers[prefix]
+    entry, ok := s.handlers[prefix]
     if !ok {
         return InvalidHandler
     }
 
-    s.defaultHandler = handler
+    s.defaultHandler = entry
+    return nil
+}
+
+// Configure Cross-Origin Resource Sharing (CORS) for every handler,
+// including automatically replying to preflight requests.
+func (s *setupServer) SetCORS(cfg srv_iface.CORSConfig) error {
+    s.cors = newCORS(cfg)
+    return nil
+}
+
+// Log every request to an access log. The log is closed alongside the
+// `ListeningServer`.
+func (s *setupServer) SetAccessLog(cfg srv_iface.AccessLogConfig) error {
+    l, err := newAccessLog(cfg)
+    if err != nil {
+        logger.Errorf("web/server: Failed to open the access log: %+v", err)
+        return err
+    }
+
+    if s.accessLog != nil {
+        s.accessLog.Close()
+    }
+    s.accessLog = l
     return nil
 }
 
@@ -206,39 +357,61 @@ func (s *setupServer) Listen(host string, port int) (srv_iface.ListeningServer,
     }
 
     // Check that every dependency is met in the handlers.
-    for _, h := range s.handlers {
-        for _, dep := range h.Dependencies() {
+    for p, e := range s.handlers {
+        for _, dep := range e.handler.Dependencies() {
             if _, ok := s.handlers[dep]; !ok {
-                logger.Fatalf("Missing dependency \"%s\" for service \"%s\"!", dep, h.Prefix())
+                logger.Fatalf("Missing dependency \"%s\" for service \"%s\"!", dep, p)
             }
         }
     }
 
+    // Bind the address before anything else, so the `Server` may still be
+    // used if it fails.
+    addr := host + ":" + strconv.Itoa(port)
+    ln, err := net.Listen("tcp", addr)
+    if err != nil {
+        logger.Errorf("web/server: Failed to listen on %s: %+v", addr, err)
+        return nil, ListenFailed
+    }
+
     var srv runningServer
+    srv.cors = s.cors
+    srv.accessLog = s.accessLog
+    srv.port = port
     srv.defaultHandler = s.defaultHandler
 
+    // Add the built-in handlers, unless their prefix was already used.
+    for _, h := range newBuiltinHandlers(&srv) {
+        s.AddHandler(h, http.MethodGet)
+    }
+
     // Convert `setupServer`'s maps of `Handlers` in a list, for
     // `runningServer`. Also assign the listening port, if needed.
-    for p, h := range s.handlers {
-        srv.handlers = append(srv.handlers, h)
+    for p, e := range s.handlers {
+        srv.handlers = append(srv.handlers, e)
         delete(s.handlers, p)
-
-        if lh, ok := h.(srv_iface.LoopbackHandler); ok && lh != nil {
-            lh.SetListeningPort(port)
-        }
+        srv.setPort(e.handler)
     }
+    srv.routes = s.routes
+    s.routes = routeNode{}
     s.handlers = nil
 
     // Configure and start the `http.Server`
-    sport := strconv.Itoa(port)
     srv.httpServer = &http.Server {
-        Addr: host + ":" + sport,
+        Addr: addr,
         Handler: &srv,
     }
 
+    atomic.StoreInt32(&srv.listening, 1)
+
+    httpServer := srv.httpServer
     go func() {
         logger.Debugf("Waiting...")
-        srv.httpServer.ListenAndServe()
+        err := httpServer.Serve(ln)
+        atomic.StoreInt32(&srv.listening, 0)
+        if err != http.ErrServerClosed {
+            logger.Errorf("web/server: Stopped listening: %+v", err)
+        }
     } ()
 
     // Invalidate the `Server`, so it may not be used anymore.
@@ -249,7 +422,7 @@ func (s *setupServer) Listen(host string, port int) (srv_iface.ListeningServer,
 // Retrieve a new, empty `srv_iface.Server`.
 func New() srv_iface.Server {
     return &setupServer{
-        handlers: make(map[string]srv_iface.Handler),
+        handlers: make(map[string]*handlerEntry),
         valid: true,
     }
 }
@@ -0,0 +1,83 @@
+package server
+
+import (
+    "net/http"
+    "net/http/httptest"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "testing"
+)
+
+// A `Handler` used by the tests.
+type testHandler struct {
+    // Base path for the handler.
+    prefix string
+    // Every other service used by this handler.
+    deps []string
+    // Metrics exposed by the handler.
+    metrics []srv_iface.Metric
+    // Handle a request. If nil, the handler replies with its prefix.
+    handle func(w http.ResponseWriter, req *http.Request, urlPath []string) error
+    // Closed when the handler is closed.
+    closed chan struct{}
+}
+
+// Create a new `testHandler` for `prefix`.
+func newTestHandler(prefix string, deps ...string) *testHandler {
+    return &testHandler {
+        prefix: prefix,
+        deps: deps,
+        closed: make(chan struct{}),
+    }
+}
+
+func (h *testHandler) Prefix() string {
+    return h.prefix
+}
+
+func (h *testHandler) Dependencies() []string {
+    return h.deps
+}
+
+func (h *testHandler) Handle(w http.ResponseWriter, req *http.Request, urlPath []string) error {
+    if h.handle != nil {
+        return h.handle(w, req, urlPath)
+    }
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte(h.prefix))
+    return nil
+}
+
+func (h *testHandler) Metrics() []srv_iface.Metric {
+    return h.metrics
+}
+
+func (h *testHandler) Close() {
+    close(h.closed)
+}
+
+// Create a `runningServer` that isn't listening, with every built-in
+// handler and the supplied ones.
+func newTestServer(t *testing.T, handlers ...srv_iface.Handler) *runningServer {
+    s := &runningServer{}
+    for _, h := range newBuiltinHandlers(s) {
+        s.AddHandler(h, http.MethodGet)
+    }
+    for _, h := range handlers {
+        err := s.AddHandler(h)
+        if err != nil {
+            t.Fatalf("Failed to add '%s': %+v", h.Prefix(), err)
+        }
+    }
+    return s
+}
+
+// Send a request to `s`, retrieving its response.
+func serveTest(s http.Handler, method, target string, hdr ...string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, target, nil)
+    for i := 0; i + 1 < len(hdr); i += 2 {
+        req.Header.Set(hdr[i], hdr[i + 1])
+    }
+    w := httptest.NewRecorder()
+    s.ServeHTTP(w, req)
+    return w
+}
@@ -32,7 +32,7 @@ func GetSplits(name, address string, port int) ([]string, error) {
     if err != nil {
         reason := "Failed to prepare the split request"
         code := http.StatusInternalServerError
-        return nil, newError(err, reason, code)
+        return nil, newError(err, CodeClientRequest, reason, code)
     }
     req.Header.Add("Content-Type", "application/json")
 
@@ -41,12 +41,12 @@ func GetSplits(name, address string, port int) ([]string, error) {
     if err != nil {
         reason := "Failed to retrieve the requested split"
         code := http.StatusServiceUnavailable
-        return nil, newError(err, reason, code)
+        return nil, newError(err, CodeClientUnavailable, reason, code)
     }
     defer resp.Body.Close()
     if code := resp.StatusCode; code != http.StatusOK {
         reason := "Bad response getting the split"
-        return nil, newError(nil, reason, code)
+        return nil, newError(nil, CodeClientBadResponse, reason, code)
     }
 
     var sp splits
@@ -55,7 +55,7 @@ func GetSplits(name, address string, port int) ([]string, error) {
     if err != nil {
         reason := "Failed to decode the splits response"
         code := http.StatusInternalServerError
-        return nil, newError(err, reason, code)
+        return nil, newError(err, CodeClientDecode, reason, code)
     }
 
     return sp.Entries, nil
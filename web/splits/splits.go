@@ -80,6 +80,44 @@ import (
 
 const Prefix = "/splits"
 
+// Stable, machine-readable codes for the errors reported by this service.
+const (
+    // Couldn't access the file for the splits
+    CodeOpenFile = "open-file"
+    // Couldn't encode the splits as a JSON
+    CodeEncodeSplits = "encode-splits"
+    // Couldn't decode the splits from a JSON
+    CodeDecodeSplits = "decode-splits"
+    // Couldn't save the splits to a file
+    CodeSaveSplits = "save-splits"
+    // Couldn't list the stored splits
+    CodeListSplits = "list-splits"
+    // Couldn't remove the splits
+    CodeRemoveSplits = "remove-splits"
+    // The requested splits don't exist
+    CodeSplitsNotFound = "splits-not-found"
+    // The splits already exist
+    CodeSplitsExist = "splits-exist"
+    // The request is missing its command
+    CodeMissingCommand = "missing-command"
+    // The request has missing or too many arguments
+    CodeBadArguments = "bad-arguments"
+    // The requested operation doesn't exist
+    CodeInvalidOperation = "invalid-operation"
+    // The request didn't have the expected Content-Type
+    CodeBadContentType = "bad-content-type"
+    // The request used an unsupported method
+    CodeBadMethod = "bad-method"
+    // Couldn't prepare the request to the service
+    CodeClientRequest = "client-request"
+    // Couldn't reach the service
+    CodeClientUnavailable = "client-unavailable"
+    // The service replied with an error
+    CodeClientBadResponse = "client-bad-response"
+    // Couldn't decode the service's response
+    CodeClientDecode = "client-decode"
+)
+
 // Context for the splits service
 type splitsCtx struct {
     baseDir string
@@ -98,9 +136,9 @@ type splits struct {
     Entries []string `json:",omitempty"`
 }
 
-// Build a new error
-func newError(err error, res string, status int) error {
-    return srv_iface.NewHttpError(err, "web"+Prefix, res, status)
+// Build a new error, identified by one of the `Code*` constants.
+func newError(err error, code, res string, status int) error {
+    return srv_iface.NewCodedHttpError(err, "web"+Prefix, code, res, status)
 }
 
 // Retrieve the path handled by `splits`.
@@ -139,7 +177,7 @@ func (ctx *splitsCtx) unsafeFileExists(path string) (bool, error) {
     if os.IsNotExist(err) {
         return false, nil
     } else if err != nil {
-        return false, newError(err, "Failed to open the requested file", http.StatusInternalServerError)
+        return false, newError(err, CodeOpenFile, "Failed to open the requested file", http.StatusInternalServerError)
     }
     return true, nil
 }
@@ -151,7 +189,7 @@ func (ctx *splitsCtx) unsafeSaveSplit(sp splits) error {
         enc := json.NewEncoder(w)
         err := enc.Encode(&sp)
         if err != nil {
-            return newError(err, "Failed to encode the splits file", http.StatusInternalServerError)
+            return newError(err, CodeEncodeSplits, "Failed to encode the splits file", http.StatusInternalServerError)
         }
         return nil
     }
@@ -159,7 +197,7 @@ func (ctx *splitsCtx) unsafeSaveSplit(sp splits) error {
     filename := ctx.getFileName(sp.Name)
     err := common.AtomicSaveFile(ctx.baseDir, filename, writefn)
     if err != nil {
-        return newError(err, "Failed to create the splits file", http.StatusInternalServerError)
+        return newError(err, CodeSaveSplits, "Failed to create the splits file", http.StatusInternalServerError)
     }
     return nil
 }
@@ -171,7 +209,7 @@ func (ctx *splitsCtx) listSplits() ([]string, error) {
 
     fis, err := ioutil.ReadDir(ctx.baseDir)
     if err != nil {
-        return nil, newError(err, "Failed to retrieve the stored splits", http.StatusInternalServerError)
+        return nil, newError(err, CodeListSplits, "Failed to retrieve the stored splits", http.StatusInternalServerError)
     }
 
     names := make([]string, 0)
@@ -183,7 +221,7 @@ func (ctx *splitsCtx) listSplits() ([]string, error) {
         name = name[:len(name) - len(".json")]
         name, err := url.PathUnescape(name)
         if err != nil {
-            return nil, newError(err, "Failed to retrieve name of stored split", http.StatusInternalServerError)
+            return nil, newError(err, CodeListSplits, "Failed to retrieve name of stored split", http.StatusInternalServerError)
         }
         names = append(names, name)
     }
@@ -201,12 +239,12 @@ func (ctx *splitsCtx) getSplits(name string) (splits, error) {
     if err != nil {
         return splits{}, err
     } else if !hasFile {
-        return splits{}, newError(err, "Splits does not exist!", http.StatusNotFound)
+        return splits{}, newError(err, CodeSplitsNotFound, "Splits does not exist!", http.StatusNotFound)
     }
 
     fp, err := os.Open(fpath)
     if err != nil {
-        return splits{}, newError(err, "Failed to retrieve the requested splits", http.StatusInternalServerError)
+        return splits{}, newError(err, CodeOpenFile, "Failed to retrieve the requested splits", http.StatusInternalServerError)
     }
     defer fp.Close()
 
@@ -214,7 +252,7 @@ func (ctx *splitsCtx) getSplits(name string) (splits, error) {
     dec := json.NewDecoder(fp)
     err = dec.Decode(&sp)
     if err != nil {
-        err = newError(err, "Failed to decode the requested splits", http.StatusInternalServerError)
+        err = newError(err, CodeDecodeSplits, "Failed to decode the requested splits", http.StatusInternalServerError)
     }
     return sp, err
 }
@@ -229,7 +267,7 @@ func (ctx *splitsCtx) addSplits(sp splits) error {
     if err != nil {
         return err
     } else if hasFile {
-        return newError(err, "Splits already exist! Must use 'PUT'!", http.StatusBadRequest)
+        return newError(err, CodeSplitsExist, "Splits already exist! Must use 'PUT'!", http.StatusBadRequest)
     }
 
     return ctx.unsafeSaveSplit(sp)
@@ -245,7 +283,7 @@ func (ctx *splitsCtx) updateSplits(sp splits) error {
     if err != nil {
         return err
     } else if !hasFile {
-        return newError(err, "Splits does not exist! Must use 'POST'!", http.StatusBadRequest)
+        return newError(err, CodeSplitsNotFound, "Splits does not exist! Must use 'POST'!", http.StatusBadRequest)
     }
 
     err = ctx.unsafeSaveSplit(sp)
@@ -266,12 +304,12 @@ func (ctx *splitsCtx) delSplits(name string) error {
     if err != nil {
         return err
     } else if !hasFile {
-        return newError(err, "Splits does not exist!", http.StatusNotFound)
+        return newError(err, CodeSplitsNotFound, "Splits does not exist!", http.StatusNotFound)
     }
 
     err = os.Remove(fpath)
     if err != nil {
-        return newError(err, "Couldn't remove the splits", http.StatusInternalServerError)
+        return newError(err, CodeRemoveSplits, "Couldn't remove the splits", http.StatusInternalServerError)
     }
 
     return nil
@@ -280,13 +318,13 @@ func (ctx *splitsCtx) delSplits(name string) error {
 // Handle GET requests.
 func (ctx *splitsCtx) get(w http.ResponseWriter, req *http.Request, urlPath []string) error {
     if len(urlPath) == 0 {
-        return newError(nil, "Missing command", http.StatusBadRequest)
+        return newError(nil, CodeMissingCommand, "Missing command", http.StatusBadRequest)
     }
 
     switch urlPath[0] {
     case "load":
         if len(urlPath) != 2 {
-            return newError(nil, "Splits name missing or too many arguments", http.StatusBadRequest)
+            return newError(nil, CodeBadArguments, "Splits name missing or too many arguments", http.StatusBadRequest)
         }
 
         resp, err := ctx.getSplits(urlPath[1])
@@ -303,7 +341,7 @@ func (ctx *splitsCtx) get(w http.ResponseWriter, req *http.Request, urlPath []st
         }
     case "list":
         if len(urlPath) != 1 {
-            return newError(nil, "Too many arguments", http.StatusBadRequest)
+            return newError(nil, CodeBadArguments, "Too many arguments", http.StatusBadRequest)
         }
 
         var resp listResp
@@ -321,7 +359,7 @@ func (ctx *splitsCtx) get(w http.ResponseWriter, req *http.Request, urlPath []st
             logger.Errorf("web%s: Failed to encode the responde: %+v (payload: %+v)", Prefix, err, resp)
         }
     default:
-        return newError(nil, "Invalid operation", http.StatusBadRequest)
+        return newError(nil, CodeInvalidOperation, "Invalid operation", http.StatusBadRequest)
     }
 
     return nil
@@ -330,14 +368,14 @@ func (ctx *splitsCtx) get(w http.ResponseWriter, req *http.Request, urlPath []st
 // Handle POST request.
 func (ctx *splitsCtx) post(w http.ResponseWriter, req *http.Request, urlPath []string) error {
     if len(urlPath) != 0 {
-        return newError(nil, "Too many arguments", http.StatusBadRequest)
+        return newError(nil, CodeBadArguments, "Too many arguments", http.StatusBadRequest)
     }
 
     var sp splits
     dec := json.NewDecoder(req.Body)
     err := dec.Decode(&sp)
     if err != nil {
-        return newError(err, "Failed to decode the received splits", http.StatusBadRequest)
+        return newError(err, CodeDecodeSplits, "Failed to decode the received splits", http.StatusBadRequest)
     }
 
     err = ctx.addSplits(sp)
@@ -352,14 +390,14 @@ func (ctx *splitsCtx) post(w http.ResponseWriter, req *http.Request, urlPath []s
 // Handle PUT request.
 func (ctx *splitsCtx) put(w http.ResponseWriter, req *http.Request, urlPath []string) error {
     if len(urlPath) != 0 {
-        return newError(nil, "Too many arguments", http.StatusBadRequest)
+        return newError(nil, CodeBadArguments, "Too many arguments", http.StatusBadRequest)
     }
 
     var sp splits
     dec := json.NewDecoder(req.Body)
     err := dec.Decode(&sp)
     if err != nil {
-        return newError(err, "Failed to decode the received splits", http.StatusBadRequest)
+        return newError(err, CodeDecodeSplits, "Failed to decode the received splits", http.StatusBadRequest)
     }
 
     err = ctx.updateSplits(sp)
@@ -374,7 +412,7 @@ func (ctx *splitsCtx) put(w http.ResponseWriter, req *http.Request, urlPath []st
 // Handle DELETE request.
 func (ctx *splitsCtx) del(w http.ResponseWriter, req *http.Request, urlPath []string) error {
     if len(urlPath) != 1 {
-        return newError(nil, "Splits name missing or too many arguments", http.StatusBadRequest)
+        return newError(nil, CodeBadArguments, "Splits name missing or too many arguments", http.StatusBadRequest)
     }
 
     err := ctx.delSplits(urlPath[0])
@@ -389,9 +427,9 @@ func (ctx *splitsCtx) del(w http.ResponseWriter, req *http.Request, urlPath []st
 // Handle requests to the `splits` service, filtering and redirecting as
 // necessary.
 func (ctx *splitsCtx) Handle(w http.ResponseWriter, req *http.Request, urlPath []string) error {
-    if req.Header.Get("Content-Type") != "application/json" {
-        reason := "Content-Type must be \"application/json\""
-        return newError(nil, reason, http.StatusUnsupportedMediaType)
+    if !srv_iface.IsJSONRequest(req) {
+        reason := "Content-Type must be either empty or \"application/json\""
+        return newError(nil, CodeBadContentType, reason, http.StatusUnsupportedMediaType)
     }
 
     var err error
@@ -407,7 +445,7 @@ func (ctx *splitsCtx) Handle(w http.ResponseWriter, req *http.Request, urlPath [
     case "DELETE":
         return ctx.del(w, req, urlPath)
     default:
-        return newError(err, "Invalid method: wanted either GET, POST, PUT or DELETE", http.StatusMethodNotAllowed)
+        return newError(err, CodeBadMethod, "Invalid method: wanted either GET, POST, PUT or DELETE", http.StatusMethodNotAllowed)
     }
 }
 
@@ -0,0 +1,374 @@
+// `theme` manages theme packs, which change the look of the overlay
+// without changing its URLs. Each theme is either a zip archive (e.g.,
+// `themes/marathon2026.zip`) or a directory (e.g., `themes/marathon2026/`)
+// in the themes directory. Zip archives are loaded fully into memory when
+// the theme is activated, so the archive may be replaced while it's in
+// use. If every file in an archive is inside a directory with the theme's
+// name (e.g., `marathon2026/`), that directory is used as the theme's root.
+//
+// The `Manager` implements `fs.FS`, serving files from the active theme.
+// If no theme is active, the `Manager` behaves as an empty file system.
+// Usually, a theme has a `res/` and a `tmpl/` directory, which may be
+// supplied as the `Theme` of `res` and `tmpl` with `Manager.Sub()`. If a
+// `Notifier` (see `web/reload`) is supplied, connected overlays are
+// reloaded whenever the active theme changes.
+//
+// The active theme is switched at runtime through this service:
+//
+//   * GET `/themes`: List every installed theme, and the active one;
+//   * POST `/themes/<name>`: Activate the theme `<name>`;
+//   * DELETE `/themes`: Deactivate the active theme.
+//
+// The list of themes is sent as the following JSON object:
+//
+//     {
+//         "Active": "marathon2026",
+//         "Themes": ["default-dark", "marathon2026"]
+//     }
+
+package theme
+
+import (
+    "archive/zip"
+    "bytes"
+    "encoding/json"
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    "github.com/SirGFM/gfm-speedrun-overlay/web/reload"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "io/fs"
+    "net/http"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+const Prefix = "/themes"
+
+// Extension of themes packed as zip archives.
+const zipExt = ".zip"
+
+// Stable, machine-readable codes for the errors reported by this service.
+const (
+    // The requested theme isn't installed
+    CodeThemeNotFound = "theme-not-found"
+    // Couldn't load the requested theme
+    CodeLoadTheme = "load-theme"
+    // Couldn't list the installed themes
+    CodeListThemes = "list-themes"
+    // Invalid path for the requested operation
+    CodeInvalidPath = "invalid-path"
+    // The request's method isn't accepted by the service
+    CodeBadMethod = "bad-method"
+)
+
+// Build a new error, identified by one of the `Code*` constants.
+func newError(err error, code, res string, status int) error {
+    return srv_iface.NewCodedHttpError(err, "web"+Prefix, code, res, status)
+}
+
+// Manages the installed themes and serves files from the active one.
+type Manager struct {
+    // Directory with the installed themes.
+    dir string
+    // Name of the active theme, if any.
+    active string
+    // Files of the active theme, if any.
+    fs fs.FS
+    // Incremented whenever the active theme changes.
+    version uint64
+    // Notified whenever the active theme changes. May be nil.
+    notifier reload.Notifier
+    // Synchronize access to the active theme.
+    rwmut sync.RWMutex
+}
+
+// Open a file from the active theme, implementing `fs.FS`.
+func (m *Manager) Open(name string) (fs.File, error) {
+    m.rwmut.RLock()
+    fsys := m.fs
+    m.rwmut.RUnlock()
+
+    if fsys == nil {
+        return nil, &fs.PathError {
+            Op: "open",
+            Path: name,
+            Err: fs.ErrNotExist,
+        }
+    }
+    return fsys.Open(name)
+}
+
+// A directory in the active theme.
+type subFS struct {
+    // The theme's manager.
+    m *Manager
+    // Directory in the theme.
+    dir string
+}
+
+// Open a file from the directory in the active theme, implementing
+// `fs.FS`.
+func (s *subFS) Open(name string) (fs.File, error) {
+    if !fs.ValidPath(name) {
+        return nil, &fs.PathError {
+            Op: "open",
+            Path: name,
+            Err: fs.ErrInvalid,
+        }
+    }
+    return s.m.Open(path.Join(s.dir, name))
+}
+
+// Retrieve an identifier that changes whenever the active theme changes.
+func (s *subFS) Version() string {
+    return s.m.Version()
+}
+
+// Retrieve a file system with the files in the directory `dir` of the
+// active theme (which may change at any time).
+func (m *Manager) Sub(dir string) fs.FS {
+    return &subFS {
+        m: m,
+        dir: dir,
+    }
+}
+
+// Retrieve an identifier that changes whenever the active theme changes,
+// so files cached from a previous theme may be detected.
+func (m *Manager) Version() string {
+    m.rwmut.RLock()
+    defer m.rwmut.RUnlock()
+    return strconv.FormatUint(m.version, 10)
+}
+
+// Retrieve the name of the active theme, or an empty string if no theme is
+// active.
+func (m *Manager) Active() string {
+    m.rwmut.RLock()
+    defer m.rwmut.RUnlock()
+    return m.active
+}
+
+// List the name of every installed theme.
+func (m *Manager) List() ([]string, error) {
+    entries, err := os.ReadDir(m.dir)
+    if err != nil {
+        return nil, err
+    }
+
+    themes := []string{}
+    for _, e := range entries {
+        name := e.Name()
+        if strings.HasPrefix(name, ".") {
+            continue
+        } else if e.IsDir() {
+            themes = append(themes, name)
+        } else if strings.HasSuffix(name, zipExt) && len(name) > len(zipExt) {
+            themes = append(themes, strings.TrimSuffix(name, zipExt))
+        }
+    }
+
+    sort.Strings(themes)
+    return themes, nil
+}
+
+// Check that a theme's name doesn't reference any other directory.
+func isValidName(name string) bool {
+    return len(name) > 0 &&
+           !strings.HasPrefix(name, ".") &&
+           !strings.ContainsAny(name, "/\\")
+}
+
+// Load the zip archive `filename`, for the theme `name`, into memory.
+func loadZip(filename, name string) (fs.FS, error) {
+    data, err := os.ReadFile(filename)
+    if err != nil {
+        return nil, err
+    }
+
+    zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+    if err != nil {
+        return nil, err
+    }
+
+    // If every file is inside a directory named after the theme, use it
+    // as the root.
+    for _, f := range zr.File {
+        if !strings.HasPrefix(f.Name, name + "/") {
+            return zr, nil
+        }
+    }
+    return fs.Sub(zr, name)
+}
+
+// Activate the theme `name`, loading it from the themes directory.
+func (m *Manager) Activate(name string) error {
+    if !isValidName(name) {
+        return newError(nil, CodeThemeNotFound, "Invalid theme name '" + name + "'", http.StatusNotFound)
+    }
+
+    var fsys fs.FS
+    base := filepath.Join(m.dir, name)
+    if fi, err := os.Stat(base); err == nil && fi.IsDir() {
+        fsys = os.DirFS(base)
+    } else if _, err := os.Stat(base + zipExt); err == nil {
+        fsys, err = loadZip(base + zipExt, name)
+        if err != nil {
+            return newError(err, CodeLoadTheme, "Couldn't load theme '" + name + "'", http.StatusInternalServerError)
+        }
+    } else {
+        return newError(nil, CodeThemeNotFound, "Couldn't find theme '" + name + "'", http.StatusNotFound)
+    }
+
+    m.rwmut.Lock()
+    m.active = name
+    m.fs = fsys
+    m.version++
+    m.rwmut.Unlock()
+
+    logger.Infof("web%s: Activated theme '%s'", Prefix, name)
+    m.notify()
+    return nil
+}
+
+// Deactivate the active theme, if any.
+func (m *Manager) Deactivate() {
+    m.rwmut.Lock()
+    m.active = ""
+    m.fs = nil
+    m.version++
+    m.rwmut.Unlock()
+
+    logger.Infof("web%s: Deactivated the theme", Prefix)
+    m.notify()
+}
+
+// Report that every file may have changed, if there's a `Notifier`.
+func (m *Manager) notify() {
+    if m.notifier != nil {
+        m.notifier.Notify(nil)
+    }
+}
+
+// Retrieve the path handled by `theme`
+func (*Manager) Prefix() string {
+    return Prefix
+}
+
+// List every other service used by this handler.
+func (*Manager) Dependencies() []string {
+    return nil
+}
+
+// Response of a GET `/themes`.
+type listResponse struct {
+    // Name of the active theme, or an empty string if none is active.
+    Active string
+    // Name of every installed theme.
+    Themes []string
+}
+
+// Send the list of installed themes.
+func (m *Manager) serveList(w http.ResponseWriter) error {
+    themes, err := m.List()
+    if err != nil {
+        return newError(err, CodeListThemes, "Couldn't list the installed themes", http.StatusInternalServerError)
+    }
+
+    resp := listResponse {
+        Active: m.Active(),
+        Themes: themes,
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    enc := json.NewEncoder(w)
+    err = enc.Encode(&resp)
+    if err != nil {
+        logger.Errorf("web%s: Failed to encode the response: %+v (payload: %+v)", Prefix, err, resp)
+    }
+    return nil
+}
+
+// Handle a request to list, activate or deactivate the themes.
+func (m *Manager) Handle(w http.ResponseWriter, req *http.Request, urlPath []string) error {
+    switch req.Method {
+    case http.MethodGet:
+        if len(urlPath) != 1 {
+            return newError(nil, CodeInvalidPath, "URL must be only " + Prefix, http.StatusNotFound)
+        }
+        return m.serveList(w)
+    case http.MethodPost:
+        if len(urlPath) != 2 {
+            return newError(nil, CodeInvalidPath, "URL must be " + Prefix + "/<theme>", http.StatusNotFound)
+        }
+        err := m.Activate(urlPath[1])
+        if err != nil {
+            return err
+        }
+    case http.MethodDelete:
+        if len(urlPath) > 2 || (len(urlPath) == 2 && urlPath[1] != m.Active()) {
+            return newError(nil, CodeInvalidPath, "URL must be " + Prefix + " or " + Prefix + "/<active theme>", http.StatusNotFound)
+        }
+        m.Deactivate()
+    default:
+        return newError(nil, CodeBadMethod, "Invalid method: wanted one of GET, POST or DELETE", http.StatusMethodNotAllowed)
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+    return nil
+}
+
+// Close resources associated with the `Manager` (i.e., the active theme)
+func (m *Manager) Close() {
+    m.rwmut.Lock()
+    defer m.rwmut.Unlock()
+
+    m.active = ""
+    m.fs = nil
+}
+
+// Configure the theme `Manager`.
+type Config struct {
+    // Directory with the installed themes.
+    Dir string
+    // Theme activated on start, if any.
+    Active string
+    // Notified whenever the active theme changes. May be nil.
+    Notifier reload.Notifier
+}
+
+// Register a theme `Manager` in the `Server`. The returned `Manager` should
+// be supplied as the `Theme` of `res` and `tmpl`.
+func GetHandleFromConfig(srv srv_iface.Server, cfg Config) (*Manager, error) {
+    dir, err := filepath.Abs(cfg.Dir)
+    if err != nil {
+        reason := "Failed to get the absolute path to the themes directory"
+        return nil, newError(err, CodeListThemes, reason, http.StatusInternalServerError)
+    } else if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+        reason := "Couldn't find the themes directory '" + cfg.Dir + "'"
+        return nil, newError(err, CodeListThemes, reason, http.StatusInternalServerError)
+    }
+
+    m := &Manager {
+        dir: dir,
+        notifier: cfg.Notifier,
+    }
+
+    if len(cfg.Active) > 0 {
+        err = m.Activate(cfg.Active)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    err = srv.AddHandler(m)
+    if err != nil {
+        return nil, err
+    }
+    return m, nil
+}
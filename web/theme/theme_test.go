@@ -0,0 +1,184 @@
+package theme
+
+import (
+    "archive/zip"
+    "encoding/json"
+    "io/fs"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "reflect"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "strings"
+    "testing"
+)
+
+// Counts how many times the clients were notified.
+type testNotifier struct {
+    notified int
+}
+
+func (*testNotifier) Watch(dirs []string, onChange func(changed []string)) {}
+
+func (n *testNotifier) Notify(changed []string) {
+    n.notified++
+}
+
+// Create a zip archive `filename` with the supplied files.
+func writeZip(t *testing.T, filename string, files map[string]string) {
+    f, err := os.Create(filename)
+    if err != nil {
+        t.Fatalf("Failed to create '%s': %+v", filename, err)
+    }
+    defer f.Close()
+
+    zw := zip.NewWriter(f)
+    for name, content := range files {
+        w, err := zw.Create(name)
+        if err != nil {
+            t.Fatalf("Failed to add '%s' to '%s': %+v", name, filename, err)
+        }
+        w.Write([]byte(content))
+    }
+    if err := zw.Close(); err != nil {
+        t.Fatalf("Failed to write '%s': %+v", filename, err)
+    }
+}
+
+// Create a themes directory with a directory theme ("dir"), a zip theme
+// ("flat") and a zip theme with a root directory ("nested").
+func newTestManager(t *testing.T) (*Manager, *testNotifier) {
+    dir := t.TempDir()
+
+    os.MkdirAll(filepath.Join(dir, "dir", "res"), 0755)
+    err := os.WriteFile(filepath.Join(dir, "dir", "res", "style.css"), []byte("dir"), 0644)
+    if err != nil {
+        t.Fatalf("Failed to create the directory theme: %+v", err)
+    }
+    writeZip(t, filepath.Join(dir, "flat.zip"), map[string]string {
+        "res/style.css": "flat",
+        "tmpl/page.html": "page",
+    })
+    writeZip(t, filepath.Join(dir, "nested.zip"), map[string]string {
+        "nested/res/style.css": "nested",
+    })
+    os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644)
+    os.Mkdir(filepath.Join(dir, ".hidden"), 0755)
+
+    n := &testNotifier{}
+    return &Manager {
+        dir: dir,
+        notifier: n,
+    }, n
+}
+
+// Read the file `name` from `fsys`, or return an empty string on error.
+func readTheme(fsys fs.FS, name string) string {
+    data, err := fs.ReadFile(fsys, name)
+    if err != nil {
+        return ""
+    }
+    return string(data)
+}
+
+func TestThemeLoading(t *testing.T) {
+    m, n := newTestManager(t)
+    res := m.Sub("res")
+
+    themes, err := m.List()
+    if err != nil {
+        t.Fatalf("Failed to list the themes: %+v", err)
+    } else if exp := []string { "dir", "flat", "nested" }; !reflect.DeepEqual(themes, exp) {
+        t.Errorf("Expected themes %v, got %v", exp, themes)
+    }
+
+    if got := readTheme(res, "style.css"); got != "" {
+        t.Errorf("No theme: expected an empty file system, got '%s'", got)
+    }
+
+    versions := map[string]bool { m.Version(): true }
+    for _, name := range []string { "dir", "flat", "nested" } {
+        if err := m.Activate(name); err != nil {
+            t.Errorf("Failed to activate '%s': %+v", name, err)
+            continue
+        }
+
+        if got := readTheme(res, "style.css"); got != name {
+            t.Errorf("'%s': expected 'style.css' to be '%s', got '%s'", name, name, got)
+        } else if m.Active() != name {
+            t.Errorf("'%s': expected it to be active, got '%s'", name, m.Active())
+        } else if versions[m.Version()] {
+            t.Errorf("'%s': expected a new version, got '%s'", name, m.Version())
+        }
+        versions[m.Version()] = true
+    }
+    if got := readTheme(m.Sub("tmpl"), "page.html"); got != "" {
+        t.Errorf("'nested': expected no file from 'flat', got '%s'", got)
+    } else if _, err := res.Open("../res/style.css"); err == nil {
+        t.Errorf("Expected invalid paths to be rejected")
+    }
+
+    m.Deactivate()
+    if got := readTheme(res, "style.css"); got != "" || m.Active() != "" {
+        t.Errorf("Deactivated: expected no theme, got '%s' ('%s')", m.Active(), got)
+    } else if n.notified != 4 {
+        t.Errorf("Expected the clients to be notified 4 times, got %d", n.notified)
+    }
+
+    for _, name := range []string { "missing", "notes", "../dir", ".hidden", "" } {
+        err := m.Activate(name)
+        if herr, ok := err.(srv_iface.HttpError); !ok || herr.GetCode() != CodeThemeNotFound {
+            t.Errorf("'%s': expected %s, got %+v", name, CodeThemeNotFound, err)
+        }
+    }
+
+    os.WriteFile(filepath.Join(m.dir, "bad.zip"), []byte("not a zip"), 0644)
+    err = m.Activate("bad")
+    if herr, ok := err.(srv_iface.HttpError); !ok || herr.GetCode() != CodeLoadTheme {
+        t.Errorf("'bad': expected %s, got %+v", CodeLoadTheme, err)
+    }
+}
+
+func TestThemeSwitching(t *testing.T) {
+    m, _ := newTestManager(t)
+
+    serve := func(method, target string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(method, target, nil)
+        w := httptest.NewRecorder()
+        err := m.Handle(w, req, strings.Split(strings.Trim(target, "/"), "/"))
+        if herr, ok := err.(srv_iface.HttpError); ok {
+            srv_iface.ReplyHttpError(herr, w, req)
+        }
+        return w
+    }
+
+    for _, tc := range []struct {
+        method string
+        target string
+        status int
+        active string
+    } {
+        { http.MethodPost, "/themes/flat", http.StatusNoContent, "flat" },
+        { http.MethodPost, "/themes/missing", http.StatusNotFound, "flat" },
+        { http.MethodDelete, "/themes/dir", http.StatusNotFound, "flat" },
+        { http.MethodPost, "/themes/dir", http.StatusNoContent, "dir" },
+        { http.MethodDelete, "/themes/dir", http.StatusNoContent, "" },
+        { http.MethodPost, "/themes/nested", http.StatusNoContent, "nested" },
+        { http.MethodDelete, "/themes", http.StatusNoContent, "" },
+        { http.MethodPut, "/themes/dir", http.StatusMethodNotAllowed, "" },
+    } {
+        w := serve(tc.method, tc.target)
+        if w.Code != tc.status {
+            t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.target, tc.status, w.Code)
+        }
+
+        var got listResponse
+        err := json.NewDecoder(serve(http.MethodGet, "/themes").Body).Decode(&got)
+        if err != nil {
+            t.Errorf("%s %s: failed to list the themes: %+v", tc.method, tc.target, err)
+        } else if got.Active != tc.active {
+            t.Errorf("%s %s: expected '%s' to be active, got '%s'", tc.method, tc.target, tc.active, got.Active)
+        }
+    }
+}
@@ -252,8 +252,8 @@ func (ctx *timer) Handle(w http.ResponseWriter, req *http.Request, urlPath []str
     if len(urlPath) != 1 {
         reason := "URL must be only " + ctx.Prefix()
         return newError(nil, reason, http.StatusBadRequest)
-    } else if req.Header.Get("Content-Type") != "application/json" {
-        reason := "Content-Type must be \"application/json\""
+    } else if !srv_iface.IsJSONRequest(req) {
+        reason := "Content-Type must be either empty or \"application/json\""
         return newError(nil, reason, http.StatusUnsupportedMediaType)
     }
 
@@ -0,0 +1,98 @@
+// Templates are executed by either `html/template` or `text/template`,
+// depending on their extension. `html/template` escapes the data based on
+// where it's used in the document, which is needed by HTML and SVG pages,
+// but which breaks stylesheets and plain text files (e.g., files read by
+// OBS' text sources). Thus, templates with any of the extensions in
+// `DefaultTextExtensions` (or `Config.TextExtensions`) are executed by
+// `text/template`, and every other template by `html/template`.
+//
+// Scripts and CSV files are escaped by default, since they may embed data
+// received from anyone allowed to update it, and must be explicitly listed
+// in `Config.TextExtensions` to be executed by `text/template`. Markup
+// (i.e., HTML and SVG pages) is always escaped, even if listed.
+//
+// Partials are parsed by the same engine as the page that uses them.
+
+package tmpl
+
+import (
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    html_template "html/template"
+    "io"
+    "path"
+    "strings"
+    text_template "text/template"
+)
+
+// Extensions of the templates executed by `text/template`, if not
+// configured otherwise.
+var DefaultTextExtensions = []string { ".txt", ".css", ".json" }
+
+// Extensions of the templates that are always executed by `html/template`.
+var markupExtensions = map[string]bool {
+    ".html": true,
+    ".htm": true,
+    ".svg": true,
+}
+
+// A parsed template, from either engine.
+type executor interface {
+    // Execute the template with `data`, writing the result to `w`.
+    Execute(w io.Writer, data interface{}) error
+}
+
+// Check whether the template `filePath` is executed by `text/template`.
+func (ctx *tmpl) isText(filePath string) bool {
+    return ctx.textExts[strings.ToLower(path.Ext(filePath))]
+}
+
+// Parse the template `filePath`, alongside every partial, with the engine
+// associated with its extension. Templates are named after their files, so
+// errors report where they happened (e.g., "template: gfm/overlay.html:12:
+// unexpected EOF").
+func (ctx *tmpl) parse(filePath string, content []byte, partials []partial) (executor, error) {
+    if ctx.isText(filePath) {
+        page := text_template.New(filePath).Funcs(text_template.FuncMap(ctx.funcs))
+        for _, p := range partials {
+            if _, err := page.New(p.name).Parse(string(p.content)); err != nil {
+                return nil, err
+            }
+        }
+        if _, err := page.Parse(string(content)); err != nil {
+            return nil, err
+        }
+        return page, nil
+    }
+
+    page := html_template.New(filePath).Funcs(ctx.funcs)
+    for _, p := range partials {
+        if _, err := page.New(p.name).Parse(string(p.content)); err != nil {
+            return nil, err
+        }
+    }
+    if _, err := page.Parse(string(content)); err != nil {
+        return nil, err
+    }
+    return page, nil
+}
+
+// Build the set of extensions executed by `text/template`.
+func textExtensions(exts []string) map[string]bool {
+    if exts == nil {
+        exts = DefaultTextExtensions
+    }
+
+    set := make(map[string]bool)
+    for _, ext := range exts {
+        ext = strings.ToLower(ext)
+        if !strings.HasPrefix(ext, ".") {
+            ext = "." + ext
+        }
+        if markupExtensions[ext] {
+            logger.Warnf("web%s: Ignoring text extension '%s', since markup must be escaped", Prefix, ext)
+            continue
+        }
+        set[ext] = true
+    }
+    return set
+}
@@ -0,0 +1,48 @@
+package tmpl
+
+import (
+    "testing"
+)
+
+func TestEngine(t *testing.T) {
+    dir := t.TempDir()
+    writeFiles(t, dir, map[string]string {
+        "name.txt": "{{ .Name }}",
+        "style.css": `.name::after { content: "{{ .Name }}"; }`,
+        "page.html": "<p>{{ .Name }}</p>",
+        "logo.svg": `<svg xmlns="http://www.w3.org/2000/svg"><text>{{ .Name }}</text></svg>`,
+        "data.js": "{{ .Name }}",
+        "data.csv": "{{ .Name }}",
+    })
+    data := map[string]string { "Name": `<b>"A&B"</b>` }
+    const escaped = "&lt;b&gt;&#34;A&amp;B&#34;&lt;/b&gt;"
+
+    for _, tc := range []struct {
+        textExts []string
+        filePath string
+        exp string
+    } {
+        { nil, "name.txt", data["Name"] },
+        { nil, "style.css", `.name::after { content: "` + data["Name"] + `"; }` },
+        { nil, "page.html", "<p>" + escaped + "</p>" },
+        { nil, "logo.svg", `<svg xmlns="http://www.w3.org/2000/svg"><text>` + escaped + "</text></svg>" },
+        { nil, "data.js", escaped },
+        { nil, "data.csv", escaped },
+        // Scripts may be opted in, but markup is always escaped
+        { []string { "js", ".SVG" }, "data.js", data["Name"] },
+        { []string { "js", ".SVG" }, "logo.svg", `<svg xmlns="http://www.w3.org/2000/svg"><text>` + escaped + "</text></svg>" },
+        { []string { "js", ".SVG" }, "name.txt", escaped },
+    } {
+        ctx := newTestTmpl(t, Config {
+            Dirs: []string { dir },
+            TextExtensions: tc.textExts,
+        })
+
+        got, err := execPage(ctx, tc.filePath, data)
+        if err != nil {
+            t.Errorf("%v: failed to execute '%s': %+v", tc.textExts, tc.filePath, err)
+        } else if got != tc.exp {
+            t.Errorf("%v: expected '%s' to be '%s', got '%s'", tc.textExts, tc.filePath, tc.exp, got)
+        }
+    }
+}
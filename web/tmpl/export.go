@@ -0,0 +1,227 @@
+// Preview and export of pages, so layouts may be checked before going
+// live:
+//
+//   * POST `/tmpl/_preview/<page>`: Render `<page>` with the data in the
+//     request's body, without storing it. The data is validated against
+//     the page's schema, if any;
+//   * POST `/tmpl/_export`: Render every page that has data into static
+//     files in `Config.ExportDir`, keeping their paths (e.g.,
+//     `/tmpl/gfm/overlay.html` is saved as `<ExportDir>/gfm/overlay.html`).
+//
+// Exporting reports which pages were exported, which were skipped (because
+// they don't have any data) and the errors of every page that failed,
+// including pages whose data couldn't be read:
+//
+//     {
+//         "Exported": ["gfm/overlay.html"],
+//         "Skipped": ["gfm/dashboard.html"],
+//         "Errors": {"gfm/card.html": "template: gfm/card.html:3: ..."}
+//     }
+//
+// Note that, since these are handled by `tmpl`, no data may be stored for
+// pages in a directory named `_preview` or `_export`.
+
+package tmpl
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "github.com/SirGFM/gfm-speedrun-overlay/common"
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "io"
+    "io/fs"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// Path (inside `Prefix`) used to preview pages.
+const previewPath = "_preview"
+
+// Path (inside `Prefix`) used to export every page.
+const exportPath = "_export"
+
+// Render the requested page with the data in the request's body.
+func (r *request) preview() error {
+    if len(r.urlPath) < 3 {
+        reason := "URL must be " + Prefix + "/" + previewPath + "/<page>"
+        return newError(nil, reason, http.StatusNotFound)
+    }
+
+    // Handle the request as if it were for the page itself
+    page := *r
+    page.urlPath = append([]string { r.urlPath[0] }, r.urlPath[2:]...)
+
+    err := page.validateBody()
+    if err != nil {
+        return err
+    }
+
+    var data interface{}
+    err = json.NewDecoder(r.req.Body).Decode(&data)
+    if err != nil {
+        reason := "The received data isn't valid JSON"
+        return newCodedError(err, CodeInvalidData, reason, http.StatusBadRequest)
+    }
+
+    filePath, file, ctype, err := page.openTemplate()
+    if err != nil {
+        return err
+    }
+    parsed, err := r.ctx.getPage(filePath, file)
+    if err != nil {
+        return err
+    }
+    return page.render(parsed, ctype, data)
+}
+
+// List every template, in every location, except for partials and
+// schemas.
+func (ctx *tmpl) listTemplates() ([]string, error) {
+    found := make(map[string]bool)
+
+    for _, fsys := range ctx.templateSources() {
+        err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+            if err != nil {
+                if errors.Is(err, fs.ErrNotExist) {
+                    return nil
+                }
+                return err
+            } else if p == "." {
+                return nil
+            } else if strings.HasPrefix(d.Name(), ".") || ctx.isPartial(p) {
+                if d.IsDir() {
+                    return fs.SkipDir
+                }
+                return nil
+            } else if !d.IsDir() && !strings.HasSuffix(p, schemaExt) {
+                found[p] = true
+            }
+            return nil
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    var list []string
+    for p := range found {
+        list = append(list, p)
+    }
+    sort.Strings(list)
+    return list, nil
+}
+
+// Result of exporting every page.
+type exportResult struct {
+    // Pages successfully exported.
+    Exported []string
+    // Pages skipped because they don't have any data.
+    Skipped []string
+    // Error of every page that couldn't be exported.
+    Errors map[string]string
+}
+
+// Check whether an error returned by `DataCRUD.Read` means that the
+// resource doesn't have any data, i.e., whether its code is "not-found" or
+// ends in "-not-found" (e.g., a `HttpError` with a 404 status, or the
+// `FileStore`'s `CodeDataNotFound`).
+func hasNoData(err error) bool {
+    var code string
+    if herr, ok := err.(srv_iface.HttpError); ok {
+        code = herr.GetCode()
+    } else if cerr, ok := err.(srv_iface.CodedError); ok {
+        code = cerr.Code()
+    }
+
+    return code == "not-found" || strings.HasSuffix(code, "-not-found")
+}
+
+// Render the template `filePath` with its stored data, saving it in the
+// export directory. Returns `false` if the page doesn't have any data.
+func (ctx *tmpl) exportPage(filePath string) (bool, error) {
+    file, _, err := ctx.getFile(filePath)
+    if err != nil {
+        return false, errors.New("couldn't open the template")
+    }
+    page, err := ctx.loadPage(filePath, file)
+    if err != nil {
+        return false, err
+    }
+
+    urlPath := append([]string { strings.TrimPrefix(Prefix, "/") }, strings.Split(filePath, "/")...)
+    ctx.rwmut.RLock()
+    data, err := ctx.data.Read(urlPath)
+    ctx.rwmut.RUnlock()
+    if hasNoData(err) {
+        return false, nil
+    } else if err != nil {
+        return false, err
+    }
+
+    var buf bytes.Buffer
+    err = page.Execute(&buf, data)
+    if err != nil {
+        return false, err
+    }
+
+    filename := filepath.Join(ctx.exportDir, filepath.FromSlash(filePath))
+    err = os.MkdirAll(filepath.Dir(filename), 0755)
+    if err != nil {
+        return false, err
+    }
+
+    writefn := func(w io.Writer) error {
+        _, err := w.Write(buf.Bytes())
+        return err
+    }
+    err = common.AtomicSaveFile(filepath.Dir(filename), filename, writefn)
+    return true, err
+}
+
+// Render every page that has data into the export directory.
+func (r *request) export() error {
+    if len(r.urlPath) != 2 {
+        reason := "URL must be " + Prefix + "/" + exportPath
+        return newError(nil, reason, http.StatusNotFound)
+    } else if len(r.ctx.exportDir) == 0 {
+        reason := "Exporting pages isn't enabled"
+        return newCodedError(nil, CodeExportDisabled, reason, http.StatusNotFound)
+    }
+
+    pages, err := r.ctx.listTemplates()
+    if err != nil {
+        reason := "Couldn't list the templates"
+        return newError(err, reason, http.StatusInternalServerError)
+    }
+
+    res := exportResult {
+        Exported: []string{},
+        Skipped: []string{},
+        Errors: make(map[string]string),
+    }
+    for _, p := range pages {
+        ok, err := r.ctx.exportPage(p)
+        if err != nil {
+            res.Errors[p] = err.Error()
+        } else if ok {
+            res.Exported = append(res.Exported, p)
+        } else {
+            res.Skipped = append(res.Skipped, p)
+        }
+    }
+
+    logger.Infof("web%s: Exported %d pages to '%s' (%d failed)", Prefix, len(res.Exported), r.ctx.exportDir, len(res.Errors))
+
+    r.w.Header().Set("Content-Type", "application/json")
+    r.w.WriteHeader(http.StatusOK)
+    err = json.NewEncoder(r.w).Encode(&res)
+    if err != nil {
+        logger.Errorf("web%s: Failed to encode the response: %+v (payload: %+v)", Prefix, err, res)
+    }
+    return nil
+}
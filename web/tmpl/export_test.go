@@ -0,0 +1,143 @@
+package tmpl
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// A `DataCRUD` that fails to read the resources in `broken`.
+type brokenStore struct {
+    *FileStore
+    broken string
+}
+
+func (s *brokenStore) Read(resource []string) (interface{}, error) {
+    if strings.Join(resource, "/") == s.broken {
+        return nil, errors.New("storage unavailable")
+    }
+    return s.FileStore.Read(resource)
+}
+
+// Check that `w` is an error reply with `status` and `code`.
+func expectReply(t *testing.T, desc string, w *httptest.ResponseRecorder, status int, code string) {
+    t.Helper()
+
+    resp := w.Result()
+    var got struct {
+        Code string `json:"code"`
+    }
+    json.NewDecoder(resp.Body).Decode(&got)
+    if resp.StatusCode != status || got.Code != code {
+        t.Errorf("%s: expected %d (%s), got %d (%s)", desc, status, code, resp.StatusCode, got.Code)
+    }
+}
+
+func TestPreview(t *testing.T) {
+    dir := t.TempDir()
+    writeFiles(t, dir, map[string]string {
+        "card.html": "<p>{{ .Title }}</p>",
+        "card.schema.json": `{"type": "object", "required": ["Title"]}`,
+    })
+
+    store, _ := NewFileStore(FileStoreConfig{})
+    defer store.Close()
+    ctx := newTestTmpl(t, Config {
+        Dirs: []string { dir },
+        Data: store,
+    })
+
+    w := serve(ctx, http.MethodPost, "/tmpl/_preview/card.html", strings.NewReader(`{"Title": "<Finals>"}`))
+    if w.Code != http.StatusOK || w.Body.String() != "<p>&lt;Finals&gt;</p>" {
+        t.Errorf("Preview: expected the rendered page, got %d ('%s')", w.Code, w.Body.String())
+    } else if _, err := store.Read([]string { "tmpl", "card.html" }); !hasNoData(err) {
+        t.Errorf("Preview: expected the data not to be stored, got %+v", err)
+    }
+
+    for _, tc := range []struct {
+        desc string
+        target string
+        body string
+        status int
+        code string
+    } {
+        { "Invalid JSON", "/tmpl/_preview/card.html", `{"Title": `, http.StatusBadRequest, CodeInvalidData },
+        { "Schema violation", "/tmpl/_preview/card.html", `{"Round": 1}`, http.StatusBadRequest, CodeInvalidData },
+        { "Missing page", "/tmpl/_preview", `{}`, http.StatusNotFound, "not-found" },
+        { "Missing template", "/tmpl/_preview/missing.html", `{}`, http.StatusNotFound, "not-found" },
+    } {
+        w := serve(ctx, http.MethodPost, tc.target, strings.NewReader(tc.body), "Accept", "application/json")
+        expectReply(t, tc.desc, w, tc.status, tc.code)
+    }
+}
+
+func TestExport(t *testing.T) {
+    dir := t.TempDir()
+    exportDir := t.TempDir()
+    writeFiles(t, dir, map[string]string {
+        "gfm/overlay.html": `{{ template "title" . }}`,
+        "gfm/overlay.schema.json": `{"type": "object"}`,
+        "gfm/dashboard.html": "dashboard",
+        "card.html": "{{ len .Title }}{{ len 3 }}",
+        "broken.html": "broken",
+        "partials/title.html": `{{ define "title" }}<h1>{{ .Title }}</h1>{{ end }}`,
+    })
+
+    store, _ := NewFileStore(FileStoreConfig{})
+    defer store.Close()
+    storeJSON(store, []string { "tmpl", "gfm", "overlay.html" }, `{"Title": "Finals"}`)
+    storeJSON(store, []string { "tmpl", "card.html" }, `{"Title": "Card"}`)
+    storeJSON(store, []string { "tmpl", "broken.html" }, `{}`)
+
+    ctx := newTestTmpl(t, Config {
+        Dirs: []string { dir },
+        Data: &brokenStore { store, "tmpl/broken.html" },
+        ExportDir: exportDir,
+    })
+
+    w := serve(ctx, http.MethodPost, "/tmpl/_export", nil)
+    var got exportResult
+    if w.Code != http.StatusOK {
+        t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+    } else if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+        t.Fatalf("Failed to decode the result: %+v", err)
+    }
+
+    if exp := []string { "gfm/overlay.html" }; !reflect.DeepEqual(got.Exported, exp) {
+        t.Errorf("Expected %v to be exported, got %v", exp, got.Exported)
+    }
+    // Only the pages without data are skipped, even if reading the data
+    // of other pages fails
+    if exp := []string { "gfm/dashboard.html" }; !reflect.DeepEqual(got.Skipped, exp) {
+        t.Errorf("Expected %v to be skipped, got %v", exp, got.Skipped)
+    }
+    if len(got.Errors) != 2 || len(got.Errors["card.html"]) == 0 || len(got.Errors["broken.html"]) == 0 {
+        t.Errorf("Expected 'card.html' and 'broken.html' to fail, got %v", got.Errors)
+    }
+
+    data, err := os.ReadFile(filepath.Join(exportDir, "gfm", "overlay.html"))
+    if err != nil || string(data) != "<h1>Finals</h1>" {
+        t.Errorf("Expected the exported page to be '<h1>Finals</h1>', got '%s' (%+v)", data, err)
+    }
+    for _, name := range []string { "card.html", "broken.html", "gfm/dashboard.html" } {
+        if _, err := os.Stat(filepath.Join(exportDir, filepath.FromSlash(name))); err == nil {
+            t.Errorf("Expected '%s' not to be exported", name)
+        }
+    }
+
+    w = serve(ctx, http.MethodPost, "/tmpl/_export/x", nil, "Accept", "application/json")
+    expectReply(t, "Invalid URL", w, http.StatusNotFound, "not-found")
+
+    ctx = newTestTmpl(t, Config {
+        Dirs: []string { dir },
+        Data: store,
+    })
+    w = serve(ctx, http.MethodPost, "/tmpl/_export", nil, "Accept", "application/json")
+    expectReply(t, "Disabled", w, http.StatusNotFound, CodeExportDisabled)
+}
@@ -0,0 +1,238 @@
+// Functions available to every template, so layouts don't have to format
+// times and numbers in JavaScript.
+//
+//   * `duration <time>`: Format a time as "h:mm:ss.cc" (e.g.,
+//     "1:02:03.45");
+//   * `delta <time>`: Format a difference between times with its sign,
+//     omitting the hours and minutes when they are zero (e.g., "+12.34" or
+//     "-1:02.34");
+//   * `ordinal <n>`: Format a place (e.g., "1st", "2nd", "11th");
+//   * `percent <part> <total>`: Format a ratio as a percentage (e.g.,
+//     "42.5%");
+//   * `plural <n> <singular> <plural>`: Select a word based on a quantity
+//     (e.g., `{{ plural .Attempts "attempt" "attempts" }}`);
+//   * `json <value>`: Encode a value as JSON, which may be safely embedded
+//     into a script;
+//   * `default <default> <value>`: Retrieve `value`, or `default` if
+//     `value` is empty (e.g., `{{ default "TBD" .Runner }}`).
+//
+// Times may be either a `time.Duration`, a number of milliseconds (as is
+// used by every service in this repository) or a string accepted by
+// `time.ParseDuration()`.
+
+package tmpl
+
+import (
+    "encoding/json"
+    "fmt"
+    "html/template"
+    "reflect"
+    "strconv"
+    "time"
+)
+
+// Retrieve the functions available to every template. Extra functions may
+// be registered through `Config.Funcs`.
+func Funcs() template.FuncMap {
+    return template.FuncMap {
+        "duration": formatDuration,
+        "delta": formatDelta,
+        "ordinal": formatOrdinal,
+        "percent": formatPercent,
+        "plural": plural,
+        "json": toJSON,
+        "default": defaultValue,
+    }
+}
+
+// Convert a number (of any numeric type) into a float64.
+func toFloat(v interface{}) (float64, error) {
+    switch n := v.(type) {
+    case json.Number:
+        return n.Float64()
+    case string:
+        return strconv.ParseFloat(n, 64)
+    }
+
+    rv := reflect.ValueOf(v)
+    switch rv.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return float64(rv.Int()), nil
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return float64(rv.Uint()), nil
+    case reflect.Float32, reflect.Float64:
+        return rv.Float(), nil
+    default:
+        return 0, fmt.Errorf("expected a number, got %T", v)
+    }
+}
+
+// Convert a time into a `time.Duration`.
+func toDuration(v interface{}) (time.Duration, error) {
+    switch d := v.(type) {
+    case time.Duration:
+        return d, nil
+    case interface{ Milliseconds() int64 }:
+        // Types that embed a `time.Duration` (e.g., `run.DurationMs`)
+        return time.Duration(d.Milliseconds()) * time.Millisecond, nil
+    case string:
+        if dur, err := time.ParseDuration(d); err == nil {
+            return dur, nil
+        }
+    }
+
+    ms, err := toFloat(v)
+    if err != nil {
+        return 0, fmt.Errorf("expected a time, got %T", v)
+    }
+    return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// Split a duration into its sign and each of its components, with
+// centisecond precision.
+func splitDuration(d time.Duration) (neg bool, h, m, s, cs int64) {
+    if d < 0 {
+        neg = true
+        d = -d
+    }
+
+    total := int64(d / (10 * time.Millisecond))
+    cs = total % 100
+    total /= 100
+    s = total % 60
+    total /= 60
+    m = total % 60
+    h = total / 60
+    return
+}
+
+// Format a time as "h:mm:ss.cc".
+func formatDuration(v interface{}) (string, error) {
+    d, err := toDuration(v)
+    if err != nil {
+        return "", err
+    }
+
+    neg, h, m, s, cs := splitDuration(d)
+    sign := ""
+    if neg {
+        sign = "-"
+    }
+    return fmt.Sprintf("%s%d:%02d:%02d.%02d", sign, h, m, s, cs), nil
+}
+
+// Format a difference between times, always with its sign, omitting the
+// hours and minutes when they are zero.
+func formatDelta(v interface{}) (string, error) {
+    d, err := toDuration(v)
+    if err != nil {
+        return "", err
+    }
+
+    neg, h, m, s, cs := splitDuration(d)
+    sign := "+"
+    if neg {
+        sign = "-"
+    }
+
+    switch {
+    case h > 0:
+        return fmt.Sprintf("%s%d:%02d:%02d.%02d", sign, h, m, s, cs), nil
+    case m > 0:
+        return fmt.Sprintf("%s%d:%02d.%02d", sign, m, s, cs), nil
+    default:
+        return fmt.Sprintf("%s%d.%02d", sign, s, cs), nil
+    }
+}
+
+// Format a place as an English ordinal (e.g., "1st", "2nd", "11th").
+func formatOrdinal(v interface{}) (string, error) {
+    f, err := toFloat(v)
+    if err != nil {
+        return "", err
+    }
+
+    n := int64(f)
+    abs := n
+    if abs < 0 {
+        abs = -abs
+    }
+
+    suffix := "th"
+    if abs % 100 < 11 || abs % 100 > 13 {
+        switch abs % 10 {
+        case 1:
+            suffix = "st"
+        case 2:
+            suffix = "nd"
+        case 3:
+            suffix = "rd"
+        }
+    }
+    return strconv.FormatInt(n, 10) + suffix, nil
+}
+
+// Format `part / total` as a percentage, with a single decimal place.
+func formatPercent(part, total interface{}) (string, error) {
+    p, err := toFloat(part)
+    if err != nil {
+        return "", err
+    }
+    t, err := toFloat(total)
+    if err != nil {
+        return "", err
+    }
+
+    if t == 0 {
+        return "0.0%", nil
+    }
+    return strconv.FormatFloat(p / t * 100, 'f', 1, 64) + "%", nil
+}
+
+// Select `singular` if `n` is exactly one, and `plural` otherwise.
+func plural(n interface{}, singular, plural string) (string, error) {
+    f, err := toFloat(n)
+    if err != nil {
+        return "", err
+    }
+
+    if f == 1 {
+        return singular, nil
+    }
+    return plural, nil
+}
+
+// Encode a value as JSON. Characters with a special meaning in HTML are
+// escaped, so the value may be safely embedded into a script.
+func toJSON(v interface{}) (template.JS, error) {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return "", err
+    }
+    return template.JS(data), nil
+}
+
+// Retrieve `v`, or `def` if `v` is empty (i.e., nil, zero or an empty
+// string, slice or map).
+func defaultValue(def, v interface{}) interface{} {
+    if v == nil {
+        return def
+    }
+
+    rv := reflect.ValueOf(v)
+    switch rv.Kind() {
+    case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+        if rv.Len() == 0 {
+            return def
+        }
+    case reflect.Ptr, reflect.Interface:
+        if rv.IsNil() {
+            return def
+        }
+    default:
+        if rv.IsZero() {
+            return def
+        }
+    }
+    return v
+}
@@ -0,0 +1,82 @@
+package tmpl
+
+import (
+    "bytes"
+    "html/template"
+    "testing"
+    "time"
+)
+
+func TestFormatDuration(t *testing.T) {
+    for _, tc := range []struct {
+        in interface{}
+        duration string
+        delta string
+    } {
+        { time.Duration(0), "0:00:00.00", "+0.00" },
+        { 1234, "0:00:01.23", "+1.23" },
+        { float64(62345), "0:01:02.34", "+1:02.34" },
+        { int64(-62345), "-0:01:02.34", "-1:02.34" },
+        { time.Hour + 2*time.Minute + 3450*time.Millisecond, "1:02:03.45", "+1:02:03.45" },
+        { "-1.5s", "-0:00:01.50", "-1.50" },
+    } {
+        if got, err := formatDuration(tc.in); err != nil || got != tc.duration {
+            t.Errorf("duration(%v): expected '%s', got '%s' (err: %v)", tc.in, tc.duration, got, err)
+        }
+        if got, err := formatDelta(tc.in); err != nil || got != tc.delta {
+            t.Errorf("delta(%v): expected '%s', got '%s' (err: %v)", tc.in, tc.delta, got, err)
+        }
+    }
+
+    if _, err := formatDuration(struct{}{}); err == nil {
+        t.Errorf("duration: expected an error for a non-time value")
+    }
+}
+
+func TestFormatOrdinal(t *testing.T) {
+    for n, expected := range map[int]string {
+        1: "1st", 2: "2nd", 3: "3rd", 4: "4th", 11: "11th", 12: "12th",
+        13: "13th", 21: "21st", 102: "102nd", 111: "111th", -1: "-1st",
+    } {
+        if got, err := formatOrdinal(n); err != nil || got != expected {
+            t.Errorf("ordinal(%d): expected '%s', got '%s' (err: %v)", n, expected, got, err)
+        }
+    }
+}
+
+func TestTemplateFuncs(t *testing.T) {
+    extra := template.FuncMap {
+        "shout": func(s string) string { return s + "!" },
+    }
+
+    funcs := Funcs()
+    for name, fn := range extra {
+        funcs[name] = fn
+    }
+
+    page, err := template.New("").Funcs(funcs).Parse(
+        `{{ percent .Done .Total }} {{ plural .Done "split" "splits" }} ` +
+        `{{ default "TBD" .Runner }} {{ shout "go" }} ` +
+        `<script>var d = {{ json .Data }};</script>`)
+    if err != nil {
+        t.Fatalf("Failed to parse the template: %+v", err)
+    }
+
+    data := map[string]interface{} {
+        "Done": 1,
+        "Total": 8,
+        "Runner": "",
+        "Data": map[string]string { "a": "</script>" },
+    }
+
+    var buf bytes.Buffer
+    err = page.Execute(&buf, data)
+    if err != nil {
+        t.Fatalf("Failed to execute the template: %+v", err)
+    }
+
+    expected := `12.5% split TBD go! <script>var d = {"a":"\u003c/script\u003e"};</script>`
+    if got := buf.String(); got != expected {
+        t.Errorf("expected '%s', got '%s'", expected, got)
+    }
+}
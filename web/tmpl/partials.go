@@ -0,0 +1,128 @@
+// Partials are templates shared by every page (e.g., a player card or a
+// timer widget). Every file in the partials directory (`partials/`, by
+// default) of every location where templates may be found is parsed
+// before the requested page, so pages may use any template defined in
+// them:
+//
+//   * `{{ template "player-card" . }}` uses the template defined by
+//     `{{ define "player-card" }}...{{ end }}` in any partial;
+//   * `{{ template "partials/card.html" . }}` uses the entire file
+//     `partials/card.html` (i.e., templates are named after their path).
+//
+// Partials may also be used as layouts. A partial may define a default
+// content with `{{ block "name" . }}...{{ end }}`, which is replaced if
+// the page defines its own `{{ define "name" }}...{{ end }}`.
+//
+// If the same template is defined in more than one location, the one with
+// higher priority (i.e., the theme, then the directories in order, then
+// the `fs.FS`) is used. Partials themselves can't be requested as pages.
+
+package tmpl
+
+import (
+    "crypto/sha256"
+    "errors"
+    "io/fs"
+    "os"
+    "path"
+    "strings"
+)
+
+// Directory with the partials, if not configured otherwise.
+const DefaultPartialsDir = "partials"
+
+// A template shared among every page.
+type partial struct {
+    // Path to the partial (e.g., "partials/card.html").
+    name string
+    // Content of the partial.
+    content []byte
+}
+
+// List every file system where templates (and partials) may be located,
+// from the lowest to the highest priority.
+func (ctx *tmpl) templateSources() []fs.FS {
+    var sources []fs.FS
+
+    if ctx.fs != nil {
+        sources = append(sources, ctx.fs)
+    }
+    for i := len(ctx.entryPoints) - 1; i >= 0; i-- {
+        sources = append(sources, os.DirFS(ctx.entryPoints[i]))
+    }
+    if ctx.theme != nil {
+        sources = append(sources, ctx.theme)
+    }
+
+    return sources
+}
+
+// Read every partial, from the lowest to the highest priority, so
+// templates defined in the later ones override the earlier ones.
+func (ctx *tmpl) getPartials() ([]partial, error) {
+    var partials []partial
+
+    for _, fsys := range ctx.templateSources() {
+        err := fs.WalkDir(fsys, ctx.partialsDir, func(p string, d fs.DirEntry, err error) error {
+            if err != nil {
+                if errors.Is(err, fs.ErrNotExist) {
+                    return nil
+                }
+                return err
+            } else if p != ctx.partialsDir && strings.HasPrefix(d.Name(), ".") {
+                if d.IsDir() {
+                    return fs.SkipDir
+                }
+                return nil
+            } else if d.IsDir() {
+                return nil
+            }
+
+            content, err := fs.ReadFile(fsys, p)
+            if err != nil {
+                return err
+            }
+
+            partials = append(partials, partial {
+                name: p,
+                content: content,
+            })
+            return nil
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    return partials, nil
+}
+
+// Hash a page alongside every partial, so the cached page is invalidated
+// whenever any of them change.
+func hashPage(content []byte, partials []partial) []byte {
+    h := sha256.New()
+    h.Write(content)
+    for _, p := range partials {
+        h.Write([]byte{0})
+        h.Write([]byte(p.name))
+        h.Write([]byte{0})
+        h.Write(p.content)
+    }
+    return h.Sum(nil)
+}
+
+// Check whether a path is inside the partials directory.
+func (ctx *tmpl) isPartial(filePath string) bool {
+    return filePath == ctx.partialsDir ||
+           strings.HasPrefix(filePath, ctx.partialsDir + "/")
+}
+
+// Clean the name of the partials directory, so it may be compared against
+// the requested paths.
+func cleanPartialsDir(dir string) string {
+    dir = strings.Trim(path.Clean("/" + dir), "/")
+    if len(dir) == 0 {
+        return DefaultPartialsDir
+    }
+    return dir
+}
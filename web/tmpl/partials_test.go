@@ -0,0 +1,70 @@
+package tmpl
+
+import (
+    "net/http"
+    "os"
+    "testing"
+    "testing/fstest"
+)
+
+func TestPartialsLookup(t *testing.T) {
+    dir1 := t.TempDir()
+    dir2 := t.TempDir()
+    themeDir := t.TempDir()
+
+    embedded := fstest.MapFS {
+        "partials/defs.html": { Data: []byte(`{{ define "x" }}fs{{ end }}{{ define "y" }}fs{{ end }}{{ define "z" }}fs{{ end }}`) },
+        "partials/layout.html": { Data: []byte(`{{ define "layout" }}<h1>{{ block "title" . }}Default{{ end }}</h1>{{ end }}`) },
+    }
+    writeFiles(t, dir2, map[string]string {
+        "partials/defs2.html": `{{ define "x" }}dir2{{ end }}{{ define "y" }}dir2{{ end }}`,
+    })
+    writeFiles(t, dir1, map[string]string {
+        "partials/nested/defs.html": `{{ define "y" }}dir1{{ end }}`,
+        "partials/card.html": `[{{ . }}]`,
+        "partials/.hidden.html": `{{ define "z" }}hidden{{ end }}`,
+        "lookup.html": `{{ template "x" }} {{ template "y" }} {{ template "z" }}`,
+        "card.html": `{{ template "partials/card.html" "c" }}`,
+        "default.html": `{{ template "layout" . }}`,
+        "custom.html": `{{ define "title" }}Custom{{ end }}{{ template "layout" . }}`,
+    })
+    writeFiles(t, themeDir, map[string]string {
+        "partials/theme.html": `{{ define "x" }}theme{{ end }}`,
+    })
+
+    ctx := newTestTmpl(t, Config {
+        Dirs: []string { dir1, dir2 },
+        FS: embedded,
+        Theme: os.DirFS(themeDir),
+    })
+
+    // The theme, then the directories in order, then the fs.FS
+    expectPage(t, ctx, "Lookup", "lookup.html", "theme dir1 fs")
+    expectPage(t, ctx, "Whole file", "card.html", "[c]")
+    // Blocks in layouts are only replaced by the page that defines them
+    expectPage(t, ctx, "Custom block", "custom.html", "<h1>Custom</h1>")
+    expectPage(t, ctx, "Default block", "default.html", "<h1>Default</h1>")
+
+    // Partials can't be requested as pages
+    for _, target := range []string { "/tmpl/partials/card.html", "/tmpl/partials" } {
+        if w := serve(ctx, http.MethodGet, target, nil); w.Code != http.StatusNotFound {
+            t.Errorf("GET %s: expected status %d, got %d", target, http.StatusNotFound, w.Code)
+        }
+    }
+
+    // A custom partials directory
+    ctx = newTestTmpl(t, Config {
+        Dirs: []string { dir1 },
+        Partials: "/partials/nested/",
+    })
+    if ctx.partialsDir != "partials/nested" {
+        t.Errorf("Expected the partials directory to be cleaned, got '%s'", ctx.partialsDir)
+    }
+    if _, err := execPage(ctx, "lookup.html", nil); err == nil {
+        t.Errorf("Custom partials: expected 'x' to be undefined")
+    }
+    writeFiles(t, dir1, map[string]string {
+        "nested.html": `{{ template "y" }}`,
+    })
+    expectPage(t, ctx, "Custom partials", "nested.html", "dir1")
+}
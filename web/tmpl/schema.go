@@ -0,0 +1,384 @@
+// Validation of the data received for a page, based on the JSON Schema
+// stored next to its template. The schema of `dashboard.html` must be
+// named `dashboard.schema.json` (i.e., the template's last extension is
+// replaced by `.schema.json`), and it may be located in any place where
+// templates are searched.
+//
+// Only the following subset of JSON Schema is supported, which should be
+// enough to describe a page's data. Other keywords (e.g., `title` and
+// `description`) are ignored by the validator, but may still be used by
+// dashboards to build forms:
+//
+//   * `type`: Either a single type or a list of types, from "object",
+//     "array", "string", "number", "integer", "boolean" and "null";
+//   * `properties`, `required` and `additionalProperties` (either a boolean
+//     or a schema), for objects;
+//   * `items`, `minItems` and `maxItems`, for arrays;
+//   * `minLength`, `maxLength` and `pattern`, for strings;
+//   * `minimum` and `maximum`, for numbers;
+//   * `enum`, for any value.
+
+package tmpl
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "math"
+    "mime"
+    "net/http"
+    "path"
+    "reflect"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+// Extension of the files with the schemas, replacing the template's.
+const schemaExt = ".schema.json"
+
+// Media type of JSON Schemas.
+const schemaMediaType = "application/schema+json"
+
+// Retrieve the path to the schema of the template in `filePath`.
+func schemaPath(filePath string) string {
+    return strings.TrimSuffix(filePath, path.Ext(filePath)) + schemaExt
+}
+
+// The types accepted by a schema, which may be either a single string or a
+// list of strings.
+type schemaType []string
+
+// Decode either a single type or a list of types.
+func (t *schemaType) UnmarshalJSON(data []byte) error {
+    var single string
+    if err := json.Unmarshal(data, &single); err == nil {
+        *t = schemaType { single }
+        return nil
+    }
+
+    var list []string
+    err := json.Unmarshal(data, &list)
+    if err != nil {
+        return fmt.Errorf("'type' must be a string or a list of strings")
+    }
+    *t = list
+    return nil
+}
+
+// A JSON Schema, limited to the supported keywords.
+type schema struct {
+    Type schemaType `json:"type"`
+    Properties map[string]*schema `json:"properties"`
+    Required []string `json:"required"`
+    AdditionalProperties json.RawMessage `json:"additionalProperties"`
+    Items *schema `json:"items"`
+    MinItems *int `json:"minItems"`
+    MaxItems *int `json:"maxItems"`
+    MinLength *int `json:"minLength"`
+    MaxLength *int `json:"maxLength"`
+    Pattern string `json:"pattern"`
+    Minimum *float64 `json:"minimum"`
+    Maximum *float64 `json:"maximum"`
+    Enum []interface{} `json:"enum"`
+
+    // Whether properties not listed in `Properties` are forbidden.
+    noAdditional bool
+    // Schema of the properties not listed in `Properties`, if any.
+    additional *schema
+    // The compiled `Pattern`.
+    pattern *regexp.Regexp
+}
+
+// Parse a JSON Schema, checking that every supported keyword is valid.
+func parseSchema(content []byte) (*schema, error) {
+    var s schema
+
+    err := json.Unmarshal(content, &s)
+    if err != nil {
+        return nil, err
+    }
+
+    err = s.compile()
+    if err != nil {
+        return nil, err
+    }
+    return &s, nil
+}
+
+// Prepare the schema (and every sub-schema) for validating values.
+func (s *schema) compile() error {
+    for _, t := range s.Type {
+        switch t {
+        case "object", "array", "string", "number", "integer", "boolean", "null":
+        default:
+            return fmt.Errorf("unsupported type '%s'", t)
+        }
+    }
+
+    if len(s.Pattern) > 0 {
+        re, err := regexp.Compile(s.Pattern)
+        if err != nil {
+            return fmt.Errorf("invalid pattern '%s': %w", s.Pattern, err)
+        }
+        s.pattern = re
+    }
+
+    if len(s.AdditionalProperties) > 0 {
+        var allowed bool
+        if err := json.Unmarshal(s.AdditionalProperties, &allowed); err == nil {
+            s.noAdditional = !allowed
+        } else {
+            var sub schema
+            err = json.Unmarshal(s.AdditionalProperties, &sub)
+            if err != nil {
+                return fmt.Errorf("'additionalProperties' must be a boolean or a schema")
+            }
+            s.additional = &sub
+        }
+    }
+
+    subs := []*schema { s.Items, s.additional }
+    for _, sub := range s.Properties {
+        subs = append(subs, sub)
+    }
+    for _, sub := range subs {
+        if sub == nil {
+            continue
+        } else if err := sub.compile(); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// Retrieve the JSON type of a value decoded by `encoding/json`.
+func jsonType(v interface{}) string {
+    switch n := v.(type) {
+    case nil:
+        return "null"
+    case bool:
+        return "boolean"
+    case string:
+        return "string"
+    case float64:
+        if n == math.Trunc(n) {
+            return "integer"
+        }
+        return "number"
+    case []interface{}:
+        return "array"
+    case map[string]interface{}:
+        return "object"
+    default:
+        return fmt.Sprintf("%T", v)
+    }
+}
+
+// Check whether a value of type `got` is accepted as the type `want`.
+func matchesType(got, want string) bool {
+    return got == want || (got == "integer" && want == "number")
+}
+
+// Name the field `key` inside the field `parent`.
+func fieldName(parent, key string) string {
+    if len(parent) == 0 {
+        return key
+    }
+    return parent + "." + key
+}
+
+// Validate a value decoded by `encoding/json`, retrieving a description of
+// every offending field (e.g., "Players[1].Name: expected string, got
+// number"). The value is valid if the list is empty.
+func (s *schema) validate(v interface{}) []string {
+    var errs []string
+    s.validateField(v, "", &errs)
+    return errs
+}
+
+// Validate the value of the field `field`, appending every error to
+// `errs`.
+func (s *schema) validateField(v interface{}, field string, errs *[]string) {
+    name := field
+    if len(name) == 0 {
+        name = "(root)"
+    }
+    fail := func(format string, args ...interface{}) {
+        *errs = append(*errs, name + ": " + fmt.Sprintf(format, args...))
+    }
+
+    got := jsonType(v)
+    if len(s.Type) > 0 {
+        ok := false
+        for _, want := range s.Type {
+            ok = ok || matchesType(got, want)
+        }
+        if !ok {
+            fail("expected %s, got %s", strings.Join(s.Type, " or "), got)
+            return
+        }
+    }
+
+    if len(s.Enum) > 0 {
+        ok := false
+        for _, e := range s.Enum {
+            ok = ok || reflect.DeepEqual(e, v)
+        }
+        if !ok {
+            fail("must be one of %v", s.Enum)
+        }
+    }
+
+    switch val := v.(type) {
+    case string:
+        length := len([]rune(val))
+        if s.MinLength != nil && length < *s.MinLength {
+            fail("must have at least %d characters", *s.MinLength)
+        }
+        if s.MaxLength != nil && length > *s.MaxLength {
+            fail("must have at most %d characters", *s.MaxLength)
+        }
+        if s.pattern != nil && !s.pattern.MatchString(val) {
+            fail("must match '%s'", s.Pattern)
+        }
+    case float64:
+        if s.Minimum != nil && val < *s.Minimum {
+            fail("must be at least %v", *s.Minimum)
+        }
+        if s.Maximum != nil && val > *s.Maximum {
+            fail("must be at most %v", *s.Maximum)
+        }
+    case []interface{}:
+        if s.MinItems != nil && len(val) < *s.MinItems {
+            fail("must have at least %d items", *s.MinItems)
+        }
+        if s.MaxItems != nil && len(val) > *s.MaxItems {
+            fail("must have at most %d items", *s.MaxItems)
+        }
+        if s.Items != nil {
+            for i, item := range val {
+                s.Items.validateField(item, fmt.Sprintf("%s[%d]", field, i), errs)
+            }
+        }
+    case map[string]interface{}:
+        for _, key := range s.Required {
+            if _, ok := val[key]; !ok {
+                *errs = append(*errs, fieldName(field, key) + ": is required")
+            }
+        }
+
+        // Sort the keys so errors are reported in a stable order
+        keys := make([]string, 0, len(val))
+        for key := range val {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+
+        for _, key := range keys {
+            if sub, ok := s.Properties[key]; ok {
+                sub.validateField(val[key], fieldName(field, key), errs)
+            } else if s.additional != nil {
+                s.additional.validateField(val[key], fieldName(field, key), errs)
+            } else if s.noAdditional {
+                *errs = append(*errs, fieldName(field, key) + ": isn't allowed")
+            }
+        }
+    }
+}
+
+// Check whether the client requested the page's schema.
+func acceptsSchema(req *http.Request) bool {
+    for _, accept := range req.Header.Values("Accept") {
+        for _, entry := range strings.Split(accept, ",") {
+            mtype, _, err := mime.ParseMediaType(entry)
+            if err == nil && mtype == schemaMediaType {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// Retrieve the schema of the template in `filePath`, both as it's stored
+// and parsed. If the template doesn't have a schema, nil is returned
+// instead.
+func (ctx *tmpl) getSchema(filePath string) ([]byte, *schema, error) {
+    file, _, err := ctx.getFile(schemaPath(filePath))
+    if err != nil {
+        return nil, nil, nil
+    }
+
+    content, err := ioutil.ReadAll(file)
+    file.Close()
+    if err != nil {
+        reason := "Couldn't read the page's schema"
+        return nil, nil, newCodedError(err, CodeInvalidSchema, reason, http.StatusInternalServerError)
+    }
+
+    s, err := parseSchema(content)
+    if err != nil {
+        reason := "Couldn't parse the page's schema"
+        return nil, nil, newCodedError(err, CodeInvalidSchema, reason, http.StatusInternalServerError)
+    }
+    return content, s, nil
+}
+
+// Retrieve the schema of the requested page's template, if any.
+func (r *request) getSchema() ([]byte, *schema, error) {
+    filePath, file, _, err := r.openTemplate()
+    if err != nil {
+        // Pages without a template may still store data
+        return nil, nil, nil
+    }
+    file.Close()
+
+    return r.ctx.getSchema(filePath)
+}
+
+// Send the schema of the requested page.
+func (r *request) serveSchema() error {
+    content, _, err := r.getSchema()
+    if err != nil {
+        return err
+    } else if content == nil {
+        reason := "The requested page doesn't have a schema"
+        return newError(nil, reason, http.StatusNotFound)
+    }
+
+    r.w.Header().Set("Content-Type", schemaMediaType)
+    r.w.WriteHeader(http.StatusOK)
+    r.w.Write(content)
+    return nil
+}
+
+// Validate the received data against the page's schema, if any. On
+// success, the request's body is replaced so it may be read once again.
+func (r *request) validateBody() error {
+    _, s, err := r.getSchema()
+    if err != nil || s == nil {
+        return err
+    }
+
+    body, err := ioutil.ReadAll(r.req.Body)
+    if err != nil {
+        reason := "Couldn't read the received data"
+        return newError(err, reason, http.StatusBadRequest)
+    }
+    r.req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+    var data interface{}
+    err = json.Unmarshal(body, &data)
+    if err != nil {
+        reason := "The received data isn't valid JSON"
+        return newCodedError(err, CodeInvalidData, reason, http.StatusBadRequest)
+    }
+
+    if errs := s.validate(data); len(errs) > 0 {
+        reason := "Invalid data: " + strings.Join(errs, "; ")
+        return newCodedError(nil, CodeInvalidData, reason, http.StatusBadRequest)
+    }
+    return nil
+}
@@ -0,0 +1,99 @@
+package tmpl
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+)
+
+const testSchema = `{
+    "type": "object",
+    "required": ["Title", "Players"],
+    "additionalProperties": false,
+    "properties": {
+        "Title": { "type": "string", "minLength": 1 },
+        "Round": { "type": "integer", "minimum": 1, "maximum": 5 },
+        "Layout": { "enum": ["1v1", "2v2"] },
+        "Players": {
+            "type": "array",
+            "maxItems": 2,
+            "items": {
+                "type": "object",
+                "required": ["Name"],
+                "properties": {
+                    "Name": { "type": "string", "pattern": "^[A-Za-z]+$" },
+                    "Score": { "type": ["number", "null"] }
+                }
+            }
+        }
+    }
+}`
+
+func TestSchemaValidate(t *testing.T) {
+    s, err := parseSchema([]byte(testSchema))
+    if err != nil {
+        t.Fatalf("Failed to parse the schema: %+v", err)
+    }
+
+    for _, tc := range []struct {
+        data string
+        errs []string
+    } {
+        {
+            `{"Title": "Finals", "Round": 2, "Layout": "1v1", "Players": [{"Name": "gfm", "Score": 1.5}, {"Name": "abc", "Score": null}]}`,
+            nil,
+        },
+        {
+            `{"Title": "", "Round": 1.5, "Layout": "3v3", "Players": [{"Score": "1"}, {"Name": "a b"}, {"Name": "c"}], "Extra": 1}`,
+            []string {
+                "Extra: isn't allowed",
+                "Layout: must be one of [1v1 2v2]",
+                "Players: must have at most 2 items",
+                "Players[0].Name: is required",
+                "Players[0].Score: expected number or null, got string",
+                "Players[1].Name: must match '^[A-Za-z]+$'",
+                "Round: expected integer, got number",
+                "Title: must have at least 1 characters",
+            },
+        },
+        {
+            `[]`,
+            []string { "(root): expected object, got array" },
+        },
+    } {
+        var data interface{}
+        err := json.Unmarshal([]byte(tc.data), &data)
+        if err != nil {
+            t.Fatalf("Invalid test data '%s': %+v", tc.data, err)
+        }
+
+        if got := s.validate(data); !reflect.DeepEqual(got, tc.errs) {
+            t.Errorf("%s: expected %q, got %q", tc.data, tc.errs, got)
+        }
+    }
+}
+
+func TestSchemaParseErrors(t *testing.T) {
+    for _, content := range []string {
+        `{"type": "thing"}`,
+        `{"type": 1}`,
+        `{"properties": {"A": {"pattern": "("}}}`,
+        `{"additionalProperties": 1}`,
+    } {
+        if _, err := parseSchema([]byte(content)); err == nil {
+            t.Errorf("%s: expected an error", content)
+        }
+    }
+}
+
+func TestSchemaPath(t *testing.T) {
+    for in, expected := range map[string]string {
+        "dashboard.html": "dashboard.schema.json",
+        "gfm/overlay.go.html": "gfm/overlay.go.schema.json",
+        "noext": "noext.schema.json",
+    } {
+        if got := schemaPath(in); got != expected {
+            t.Errorf("schemaPath(%s): expected '%s', got '%s'", in, expected, got)
+        }
+    }
+}
@@ -0,0 +1,270 @@
+// `FileStore` is a generic `DataCRUD`, which stores the data of each page
+// as a JSON file, so it survives restarts (and crashes).
+//
+// Each resource is stored in its own file, named after the hash of the
+// resource's path, and written atomically with `common.AtomicSaveFile`.
+// Every stored file is loaded when the `FileStore` is created. Files whose
+// name doesn't match their resource (e.g., temporary files left behind by
+// a crash) are ignored.
+//
+// The received data is validated against the page's schema by `tmpl`
+// itself (see `schema.go`), before it reaches the `FileStore`. Handlers
+// that need custom behaviour may either supply a hook (to modify the data
+// sent to templates) or wrap the `FileStore` in their own `DataCRUD`.
+// Handlers with their own data structures (instead of a page's JSON) may
+// store them with `Save` and `Load`.
+
+package tmpl
+
+import (
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "github.com/SirGFM/gfm-speedrun-overlay/common"
+    "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+)
+
+// Extension of the files where resources are stored.
+const storeExt = ".json"
+
+// A resource, as it's stored in its file.
+type storedResource struct {
+    // Path to the resource, so it may be recovered from the file.
+    Resource []string
+    // Data associated with the resource.
+    Data interface{}
+}
+
+// Configure a `FileStore`.
+type FileStoreConfig struct {
+    // Directory where the resources are stored. It's created if it doesn't
+    // exist. If empty, resources are only kept in memory.
+    Dir string
+    // Modify the data retrieved for a resource before it's sent to the
+    // template (e.g., merging data from somewhere else). The stored data
+    // must not be modified. May be nil.
+    ReadHook func(resource []string, data interface{}) (interface{}, error)
+}
+
+// A `DataCRUD` that stores every resource's data in a JSON file.
+type FileStore struct {
+    // Directory where the resources are stored, or empty if resources are
+    // only kept in memory.
+    dir string
+    // Every stored resource, keyed by its name.
+    data map[string]storedResource
+    // Modify the data retrieved for a resource.
+    readHook func(resource []string, data interface{}) (interface{}, error)
+    // Synchronize access to the store.
+    rwmut sync.RWMutex
+}
+
+// Convert a resource's path into a unique name, usable as a file name.
+func resourceName(resource []string) string {
+    hash := sha256.Sum256([]byte(strings.Join(resource, "/")))
+    return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// Create a new `FileStore`, loading every resource previously stored in
+// `cfg.Dir`.
+func NewFileStore(cfg FileStoreConfig) (*FileStore, error) {
+    s := &FileStore {
+        data: make(map[string]storedResource),
+        readHook: cfg.ReadHook,
+    }
+
+    if len(cfg.Dir) == 0 {
+        return s, nil
+    }
+
+    s.dir = filepath.Clean(cfg.Dir)
+    err := os.MkdirAll(s.dir, 0755)
+    if err != nil {
+        reason := "Failed to create the data directory"
+        return nil, newError(err, reason, http.StatusInternalServerError)
+    }
+
+    entries, err := os.ReadDir(s.dir)
+    if err != nil {
+        reason := "Failed to list the stored data"
+        return nil, newError(err, reason, http.StatusInternalServerError)
+    }
+
+    for _, e := range entries {
+        if e.IsDir() || !strings.HasSuffix(e.Name(), storeExt) {
+            continue
+        }
+
+        filename := filepath.Join(s.dir, e.Name())
+        content, err := os.ReadFile(filename)
+        if err != nil {
+            logger.Warnf("web%s: Ignoring unreadable data file '%s': %+v", Prefix, filename, err)
+            continue
+        }
+
+        var res storedResource
+        err = json.Unmarshal(content, &res)
+        if err != nil || len(res.Resource) == 0 {
+            logger.Warnf("web%s: Ignoring invalid data file '%s': %+v", Prefix, filename, err)
+            continue
+        }
+
+        name := resourceName(res.Resource)
+        if e.Name() != name + storeExt {
+            // Probably a temporary file left behind by a crash
+            logger.Warnf("web%s: Ignoring data file '%s', which should be named '%s'", Prefix, filename, name + storeExt)
+            continue
+        }
+        s.data[name] = res
+    }
+
+    logger.Infof("web%s: Loaded %d resources from '%s'", Prefix, len(s.data), s.dir)
+    return s, nil
+}
+
+// Decode and store the data received for a resource.
+func (s *FileStore) store(resource []string, data DataReader) error {
+    var val interface{}
+
+    dec := json.NewDecoder(data)
+    err := dec.Decode(&val)
+    if err != nil {
+        reason := "Failed to decode the received data"
+        return newCodedError(err, CodeInvalidData, reason, http.StatusBadRequest)
+    }
+
+    return s.put(resource, val)
+}
+
+// Store the decoded data `val` for a resource, saving it to its file.
+func (s *FileStore) put(resource []string, val interface{}) error {
+    res := storedResource {
+        Resource: append([]string(nil), resource...),
+        Data: val,
+    }
+    name := resourceName(resource)
+
+    s.rwmut.Lock()
+    defer s.rwmut.Unlock()
+
+    if len(s.dir) > 0 {
+        writefn := func(w io.Writer) error {
+            return json.NewEncoder(w).Encode(&res)
+        }
+
+        filename := filepath.Join(s.dir, name + storeExt)
+        err := common.AtomicSaveFile(s.dir, filename, writefn)
+        if err != nil {
+            reason := "Failed to save the data"
+            return newError(err, reason, http.StatusInternalServerError)
+        }
+    }
+
+    s.data[name] = res
+    return nil
+}
+
+// Store `v`, which must be encodable as JSON, as the data of `resource`.
+// The value is stored as it would be loaded from its file, so it's
+// detached from `v`.
+func (s *FileStore) Save(resource []string, v interface{}) error {
+    content, err := json.Marshal(v)
+    if err != nil {
+        reason := "Failed to encode the data"
+        return newCodedError(err, CodeInvalidData, reason, http.StatusInternalServerError)
+    }
+
+    var val interface{}
+    err = json.Unmarshal(content, &val)
+    if err != nil {
+        reason := "Failed to decode the data"
+        return newCodedError(err, CodeInvalidData, reason, http.StatusInternalServerError)
+    }
+
+    return s.put(resource, val)
+}
+
+// Decode the data of `resource` into `v`, ignoring the `ReadHook`. If the
+// resource isn't stored, `v` isn't modified and false is returned.
+func (s *FileStore) Load(resource []string, v interface{}) (bool, error) {
+    s.rwmut.RLock()
+    res, ok := s.data[resourceName(resource)]
+    s.rwmut.RUnlock()
+    if !ok {
+        return false, nil
+    }
+
+    content, err := json.Marshal(res.Data)
+    if err == nil {
+        err = json.Unmarshal(content, v)
+    }
+    if err != nil {
+        reason := "Failed to decode the stored data"
+        return true, newCodedError(err, CodeInvalidData, reason, http.StatusInternalServerError)
+    }
+    return true, nil
+}
+
+// Create a new resource. Identical to `Update`.
+func (s *FileStore) Create(resource []string, data DataReader) error {
+    return s.store(resource, data)
+}
+
+// Retrieve the data associated with a given resource.
+func (s *FileStore) Read(resource []string) (interface{}, error) {
+    s.rwmut.RLock()
+    res, ok := s.data[resourceName(resource)]
+    s.rwmut.RUnlock()
+
+    if !ok {
+        reason := "Didn't find any data associated with the resource"
+        return nil, newCodedError(nil, CodeDataNotFound, reason, http.StatusNotFound)
+    } else if s.readHook != nil {
+        return s.readHook(resource, res.Data)
+    }
+    return res.Data, nil
+}
+
+// Update an already existing resource. Identical to `Create`.
+func (s *FileStore) Update(resource []string, data DataReader) error {
+    return s.store(resource, data)
+}
+
+// Remove the resource, also removing its file.
+func (s *FileStore) Delete(resource []string) error {
+    name := resourceName(resource)
+
+    s.rwmut.Lock()
+    defer s.rwmut.Unlock()
+
+    if _, ok := s.data[name]; !ok {
+        reason := "Didn't find any data associated with the resource"
+        return newCodedError(nil, CodeDataNotFound, reason, http.StatusNotFound)
+    }
+
+    if len(s.dir) > 0 {
+        err := os.Remove(filepath.Join(s.dir, name + storeExt))
+        if err != nil && !os.IsNotExist(err) {
+            reason := "Failed to remove the data"
+            return newError(err, reason, http.StatusInternalServerError)
+        }
+    }
+
+    delete(s.data, name)
+    return nil
+}
+
+// Release the data kept in memory. Stored files are kept, so they are
+// loaded once again by the next `FileStore`.
+func (s *FileStore) Close() {
+    s.rwmut.Lock()
+    defer s.rwmut.Unlock()
+
+    s.data = make(map[string]storedResource)
+}
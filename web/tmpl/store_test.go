@@ -0,0 +1,144 @@
+package tmpl
+
+import (
+    "net/http"
+    "os"
+    "path/filepath"
+    "reflect"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "strings"
+    "testing"
+)
+
+// A `DataReader` for the JSON in a string.
+type testReader struct {
+    *strings.Reader
+    resource []string
+}
+
+func (*testReader) ContentType() string {
+    return "application/json"
+}
+
+func (r *testReader) URLPath() []string {
+    return r.resource
+}
+
+// Store the JSON `data` for `resource` in `s`.
+func storeJSON(s *FileStore, resource []string, data string) error {
+    return s.Update(resource, &testReader {
+        Reader: strings.NewReader(data),
+        resource: resource,
+    })
+}
+
+// Check that `err` is an `HttpError` with `code`.
+func expectCode(t *testing.T, desc string, err error, code string) {
+    t.Helper()
+    if herr, ok := err.(srv_iface.HttpError); !ok || herr.GetCode() != code {
+        t.Errorf("%s: expected an error with code '%s', got %+v", desc, code, err)
+    }
+}
+
+func TestFileStore(t *testing.T) {
+    dir := filepath.Join(t.TempDir(), "data")
+    page := []string { "tmpl", "overlay.html" }
+    other := []string { "tmpl", "other.html" }
+
+    s, err := NewFileStore(FileStoreConfig { Dir: dir })
+    if err != nil {
+        t.Fatalf("Failed to create the store: %+v", err)
+    }
+    if err := storeJSON(s, page, `{"Title": "Finals", "Round": 2}`); err != nil {
+        t.Fatalf("Failed to store the page: %+v", err)
+    } else if err := storeJSON(s, other, `{"Title": "Other"}`); err != nil {
+        t.Fatalf("Failed to store the other page: %+v", err)
+    }
+
+    expectCode(t, "Invalid JSON", storeJSON(s, page, `{"Title": `), CodeInvalidData)
+    _, err = s.Read([]string { "tmpl", "missing.html" })
+    expectCode(t, "Read missing", err, CodeDataNotFound)
+    expectCode(t, "Delete missing", s.Delete([]string { "tmpl", "missing.html" }), CodeDataNotFound)
+
+    if err := s.Delete(other); err != nil {
+        t.Errorf("Failed to delete the other page: %+v", err)
+    }
+
+    type race struct {
+        Game string
+        Players []string
+    }
+    if err := s.Save([]string { "race" }, race { "Game", []string { "a", "b" } }); err != nil {
+        t.Errorf("Failed to save the race: %+v", err)
+    }
+
+    // A corrupt file and a temporary file left behind by a crash (with
+    // outdated data for `page`) are ignored
+    tmpData, _ := os.ReadFile(filepath.Join(dir, resourceName(page) + storeExt))
+    os.WriteFile(filepath.Join(dir, "atomic_file123.json"), tmpData, 0644)
+    os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte(`{"Resource": [`), 0644)
+    if err := storeJSON(s, page, `{"Title": "Grand Finals"}`); err != nil {
+        t.Fatalf("Failed to update the page: %+v", err)
+    }
+    s.Close()
+
+    hookCalls := 0
+    s, err = NewFileStore(FileStoreConfig {
+        Dir: dir,
+        ReadHook: func(resource []string, data interface{}) (interface{}, error) {
+            hookCalls++
+            return map[string]interface{} { "Hooked": data }, nil
+        },
+    })
+    if err != nil {
+        t.Fatalf("Failed to reload the store: %+v", err)
+    }
+
+    got, err := s.Read(page)
+    exp := map[string]interface{} {
+        "Hooked": map[string]interface{} { "Title": "Grand Finals" },
+    }
+    if err != nil || !reflect.DeepEqual(got, exp) {
+        t.Errorf("Reload: expected %+v, got %+v (%+v)", exp, got, err)
+    }
+    _, err = s.Read(other)
+    expectCode(t, "Reload deleted", err, CodeDataNotFound)
+
+    var r race
+    if ok, err := s.Load([]string { "race" }, &r); !ok || err != nil {
+        t.Errorf("Failed to load the race: %v, %+v", ok, err)
+    } else if !reflect.DeepEqual(r, race { "Game", []string { "a", "b" } }) {
+        t.Errorf("Expected the saved race, got %+v", r)
+    } else if ok, _ := s.Load([]string { "missing" }, &r); ok {
+        t.Errorf("Expected nothing to be loaded for a missing resource")
+    } else if hookCalls != 1 {
+        t.Errorf("Expected the hook to be called only by Read, got %d calls", hookCalls)
+    }
+
+    // Failing to remove the file isn't reported as a missing resource
+    filename := filepath.Join(dir, resourceName(page) + storeExt)
+    os.Remove(filename)
+    os.MkdirAll(filepath.Join(filename, "x"), 0755)
+    err = s.Delete(page)
+    if herr, ok := err.(srv_iface.HttpError); !ok || herr.GetHttpStatus() != http.StatusText(http.StatusInternalServerError) {
+        t.Errorf("Delete unremovable: expected a 500, got %v", err)
+    }
+}
+
+func TestMemoryFileStore(t *testing.T) {
+    s, err := NewFileStore(FileStoreConfig{})
+    if err != nil {
+        t.Fatalf("Failed to create the store: %+v", err)
+    }
+
+    resource := []string { "tmpl", "page.html" }
+    if err := storeJSON(s, resource, `[1, "a"]`); err != nil {
+        t.Fatalf("Failed to store the page: %+v", err)
+    }
+    got, err := s.Read(resource)
+    if exp := []interface{} { 1.0, "a" }; err != nil || !reflect.DeepEqual(got, exp) {
+        t.Errorf("Expected %+v, got %+v (%+v)", exp, got, err)
+    } else if err := s.Delete(resource); err != nil {
+        t.Errorf("Failed to delete the page: %+v", err)
+    }
+}
@@ -17,19 +17,50 @@
 // differentiate between the two. Theoretically, POST should create a new
 // entry and PUT should update and existing one.
 //
+// The data received for a page may be validated against a JSON Schema
+// stored next to its template (see `schema.go`). The schema may also be
+// retrieved with a GET that accepts `application/schema+json`, so
+// dashboards may generate forms for editing the page's data.
+//
+// Pages may be written in either `html/template` or `text/template`,
+// depending on their extension (see `engine.go`), so stylesheets and plain
+// text files may also be generated from the stored data.
+//
+// Pages may also be previewed with arbitrary data, or exported to static
+// files (see `export.go`).
+//
+// Templates shared by every page may be placed in a partials directory
+// (see `partials.go`).
+//
+// Besides Go's builtin functions, templates may use the functions listed
+// in `funcs.go` (e.g., `{{ duration .Time }}`), and any function supplied
+// in `Config.Funcs`.
+//
 // Note that the URL reported to `DataCRUD` starts on this service's
 // prefix (thus, `DataReder.URLPath()[0] == tmpl`).
+//
+// Just like `res`, templates may also be read from a `fs.FS` (e.g., an
+// `embed.FS`), which is only searched if the template isn't found in any
+// directory, and from a theme (see `web/theme`), which is searched before
+// any directory.
+//
+// If a `Notifier` (see `web/reload`) is supplied, the template directories
+// are watched. Whenever a template changes, the cache of parsed templates
+// is invalidated and connected overlays are reloaded. The theme's files
+// aren't watched, so templates from the theme are still checked for
+// changes on every access.
 
 package tmpl
 
 import (
     "bytes"
-    "crypto/sha256"
     "encoding/json"
     "github.com/SirGFM/gfm-speedrun-overlay/common"
     "github.com/SirGFM/gfm-speedrun-overlay/logger"
+    "github.com/SirGFM/gfm-speedrun-overlay/web/reload"
     "html/template"
     "io"
+    "io/fs"
     "io/ioutil"
     srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
     "net/http"
@@ -40,11 +71,30 @@ import (
 
 const Prefix = "/tmpl"
 
+// Stable, machine-readable codes for the errors reported by this service.
+const (
+    // The data isn't valid JSON or doesn't match the page's schema
+    CodeInvalidData = "invalid-data"
+    // The page's schema couldn't be parsed
+    CodeInvalidSchema = "invalid-schema"
+    // The template couldn't be parsed or executed
+    CodeTemplateError = "template-error"
+    // Exporting pages isn't enabled
+    CodeExportDisabled = "export-disabled"
+    // No data is stored for the requested page
+    CodeDataNotFound = "data-not-found"
+)
+
 // Build a new error
 func newError(err error, res string, status int) error {
     return srv_iface.NewHttpError(err, "web"+Prefix, res, status)
 }
 
+// Build a new error, identified by one of the `Code*` constants.
+func newCodedError(err error, code, res string, status int) error {
+    return srv_iface.NewCodedHttpError(err, "web"+Prefix, code, res, status)
+}
+
 // Functions to read data from a reader, alongside its content type.
 type DataReader interface {
     // Retrieve the data type.
@@ -61,7 +111,10 @@ type DataReader interface {
 type DataCRUD interface {
     // Create a new resource
     Create(resource []string, data DataReader) error
-    // Retrieve the data associated with a given resource.
+    // Retrieve the data associated with a given resource. If the resource
+    // doesn't have any data, the error's code should end in "not-found"
+    // (e.g., a `HttpError` with a 404 status), so it may be told apart
+    // from other errors.
     Read(resource []string) (interface{}, error)
     // Update an already existing resource.
     Update(resource []string, data DataReader) error
@@ -79,22 +132,43 @@ type Mapper interface {
 
 // A template that has been parsed and cached.
 type cachedPage struct {
-    // Hash (SHA-256) of the associated page.
+    // Hash (SHA-256) of the associated page, unless it was read from
+    // the watched directories.
     hash []byte
     // The parsed page, ready to `Execute()` some data.
-    page *template.Template
+    page executor
 }
 
 // Context for the tmpl service
 type tmpl struct{
     // List of directories where resources may be located.
     entryPoints []string
+    // File system searched if the resource isn't in any directory.
+    fs fs.FS
+    // File system searched before any directory.
+    theme fs.FS
+    // Maps files into their content type.
+    mime *common.MimeRegistry
     // Cache every accessed resource, for easier and faster access.
     pages map[string]cachedPage
     // Manages all data used by the resources.
     data DataCRUD
     // Possibly maps resources into other resources
     mapper Mapper
+    // Functions available to every template.
+    funcs template.FuncMap
+    // Directory, in every location, with templates shared by every page.
+    partialsDir string
+    // Extensions of the templates executed by `text/template`.
+    textExts map[string]bool
+    // Directory where pages are exported, or empty if exporting is
+    // disabled.
+    exportDir string
+    // Whether the directories are watched, so cached pages are only
+    // parsed again after `invalidate` is called.
+    watched bool
+    // Incremented whenever the cache is invalidated.
+    generation uint64
     // Synchronize access to the context.
     rwmut sync.RWMutex
 }
@@ -134,12 +208,6 @@ func (r *request) Read(buf []byte) (int, error) {
     return r.req.Body.Read(buf)
 }
 
-// Writer used to check for errors while executing a HTML template.
-type dummyWriter struct{}
-func (*dummyWriter) Write(data []byte) (int, error) {
-    return len(data), nil
-}
-
 // Retrieve the data associated with the requested page.
 func (r *request) unsafeGetPageData() (interface{}, error) {
     data, err := r.ctx.data.Read(r.urlPath)
@@ -171,37 +239,40 @@ func (r *request) servePageData() error {
     return nil
 }
 
-// Serve the requested file, using the data associated with its path to
-// execute the template.
-func (r *request) unsafeServeFile(filePath, ctype string) error {
-    // Retrieve the data associated with the resource
-    data, err := r.unsafeGetPageData()
-    if err != nil {
-        return err
-    }
-
-    // Guaranteed to be OK,from a previous check.
-    cache := r.ctx.pages[filePath]
+// Execute the template `page` with `data`, sending the result to the
+// client. The template is executed into a buffer, so errors (alongside
+// their line numbers) may be reported instead of a partial page.
+func (r *request) render(page executor, ctype string, data interface{}) error {
+    var buf bytes.Buffer
 
-    // Check if the template would parse successfully
-    dummy := &dummyWriter{}
-    err = cache.page.Execute(dummy, data)
+    err := page.Execute(&buf, data)
     if err != nil {
-        res := "Couldn't parse and execute the template"
-        return newError(err, res, http.StatusInternalServerError)
+        res := "Couldn't execute the template: " + err.Error()
+        return newCodedError(err, CodeTemplateError, res, http.StatusInternalServerError)
     }
 
-    // Finally, return the parsed data
     r.w.Header().Set("Content-Type", ctype)
     r.w.WriteHeader(http.StatusOK)
-    cache.page.Execute(r.w, data)
+    r.w.Write(buf.Bytes())
     return nil
 }
 
-// Retrieve a file in one of the valid directories.
-func (ctx *tmpl) getFile(filePath string) (*os.File, string, error) {
+// Retrieve a file in one of the valid directories, or in the file system.
+func (ctx *tmpl) getFile(filePath string) (io.ReadCloser, string, error) {
+    if ctx.theme != nil {
+        if file, ctype, err := ctx.mime.OpenFileFS(ctx.theme, filePath); err == nil {
+            return file, ctype, nil
+        }
+    }
+
     for _, dir := range ctx.entryPoints {
-        if file, ctype, err := common.OpenFile(dir, filePath); err == nil {
+        if file, ctype, err := ctx.mime.OpenFile(dir, filePath); err == nil {
+            return file, ctype, nil
+        }
+    }
+
+    if ctx.fs != nil {
+        if file, ctype, err := ctx.mime.OpenFileFS(ctx.fs, filePath); err == nil {
             return file, ctype, nil
         }
     }
@@ -211,88 +282,157 @@ func (ctx *tmpl) getFile(filePath string) (*os.File, string, error) {
     return nil, "", newError(nil, reason, http.StatusNotFound)
 }
 
-func (r *request) get() error {
-    // Convert the URL to a local path
-    filePath := path.Join(r.urlPath[1:]...)
-    if len(filePath) == 0 {
-        reason := "No resource was specified"
-        return newError(nil, reason, http.StatusNotFound)
-    }
-
-    // If the client specifically request a JSON, return the data stored
-    // for that page.
-    if r.req.Header.Get("Accept") == "application/json" {
-        return r.servePageData()
+// Check whether `filePath` exists in the theme.
+func (ctx *tmpl) inTheme(filePath string) bool {
+    if ctx.theme == nil {
+        return false
     }
+    _, err := fs.Stat(ctx.theme, filePath)
+    return err == nil
+}
 
+// Retrieve the template associated with the requested path, possibly
+// remapping it into another template.
+func (r *request) openTemplate() (string, io.ReadCloser, string, error) {
     // Retrieve the file if it's in any of the listed directories
+    filePath := path.Join(r.urlPath[1:]...)
     file, ctype, err := r.ctx.getFile(filePath)
     if err != nil {
         // Try to remap the file and use that instead
         if r.ctx.mapper == nil {
-            return err
+            return "", nil, "", err
         }
 
         newUrl, err := r.ctx.mapper.Map(r.urlPath)
         if err != nil {
             reason := "Couldn't find the specified resource"
-            return newError(err, reason, http.StatusNotFound)
+            return "", nil, "", newError(err, reason, http.StatusNotFound)
         }
 
         filePath = path.Join(newUrl[1:]...)
         file, ctype, err = r.ctx.getFile(filePath)
         if err != nil {
-            return err
+            return "", nil, "", err
         }
     }
 
-    // Check if the file has been changed since its last use
-    content, err := ioutil.ReadAll(file)
-    file.Close()
+    return filePath, file, ctype, nil
+}
+
+func (r *request) get() error {
+    // Convert the URL to a local path
+    filePath := path.Join(r.urlPath[1:]...)
+    if len(filePath) == 0 {
+        reason := "No resource was specified"
+        return newError(nil, reason, http.StatusNotFound)
+    } else if r.ctx.isPartial(filePath) {
+        reason := "Partials can't be requested directly"
+        return newError(nil, reason, http.StatusNotFound)
+    }
+
+    // If the client specifically request a JSON, return the data stored
+    // for that page.
+    if r.req.Header.Get("Accept") == "application/json" {
+        return r.servePageData()
+    } else if acceptsSchema(r.req) {
+        return r.serveSchema()
+    }
+
+    filePath, file, ctype, err := r.openTemplate()
+    if err != nil {
+        return err
+    }
+
+    page, err := r.ctx.getPage(filePath, file)
     if err != nil {
-        res := "Couldn't read the requested file"
-        return newError(err, res, http.StatusInternalServerError)
+        return err
     }
-    hashArr := sha256.Sum256(content)
-    hash := hashArr[:]
 
-    // XXX: Don't defer unlocking the mutex since the lock may need to be
-    // upgraded to a write lock if the page must be (re)cached.
     r.ctx.rwmut.RLock()
+    defer r.ctx.rwmut.RUnlock()
 
-    cache, is_valid := r.ctx.pages[filePath]
-    if is_valid {
-        is_valid = (bytes.Compare(cache.hash, hash) == 0)
+    data, err := r.unsafeGetPageData()
+    if err != nil {
+        return err
     }
+    return r.render(page, ctype, data)
+}
 
-    // If the page isn't in the cache or has been modified, update it!
-    if !is_valid {
-        // XXX: Update the lock for writing
-        r.ctx.rwmut.RUnlock()
-        r.ctx.rwmut.Lock()
-        defer r.ctx.rwmut.Unlock()
+// Retrieve the parsed template in `filePath`, whose content is read from
+// `file` (which is closed). The template is cached until it, or any
+// partial, changes.
+func (ctx *tmpl) getPage(filePath string, file io.ReadCloser) (executor, error) {
+    page, err := ctx.loadPage(filePath, file)
+    if err != nil {
+        res := "Couldn't load the requested file: " + err.Error()
+        return nil, newCodedError(err, CodeTemplateError, res, http.StatusInternalServerError)
+    }
+    return page, nil
+}
 
-        cache.hash = hash
-        cache.page = template.New("")
-        _, err = cache.page.Parse(string(content))
-        if err != nil {
-            res := "Couldn't parse the requested file"
-            return newError(err, res, http.StatusInternalServerError)
+// Same as `getPage`, but errors aren't wrapped in a `HttpError`, so their
+// messages may be reported as is.
+//
+// If the directories are watched, cached pages are used until `invalidate`
+// clears the cache. Otherwise, or if the page or any partial may come from
+// the theme (which isn't watched), the page and every partial are read
+// (and hashed) on every access, to check whether the cached page changed.
+func (ctx *tmpl) loadPage(filePath string, file io.ReadCloser) (executor, error) {
+    trusted := ctx.watched && !ctx.inTheme(filePath) && !ctx.inTheme(ctx.partialsDir)
+
+    ctx.rwmut.RLock()
+    cache, ok := ctx.pages[filePath]
+    generation := ctx.generation
+    ctx.rwmut.RUnlock()
+    if ok && trusted {
+        file.Close()
+        return cache.page, nil
+    }
+
+    content, err := ioutil.ReadAll(file)
+    file.Close()
+    if err != nil {
+        return nil, err
+    }
+    partials, err := ctx.getPartials()
+    if err != nil {
+        return nil, err
+    }
+
+    var hash []byte
+    if !trusted {
+        hash = hashPage(content, partials)
+        if ok && bytes.Equal(cache.hash, hash) {
+            return cache.page, nil
         }
+    }
+
+    cache.hash = hash
+    cache.page, err = ctx.parse(filePath, content, partials)
+    if err != nil {
+        return nil, err
+    }
 
-        r.ctx.pages[filePath] = cache
-        return r.unsafeServeFile(filePath, ctype)
-    } else {
-        defer r.ctx.rwmut.RUnlock()
-        return r.unsafeServeFile(filePath, ctype)
+    ctx.rwmut.Lock()
+    // Don't cache a page that may have changed while it was being parsed
+    if generation == ctx.generation {
+        ctx.pages[filePath] = cache
     }
+    ctx.rwmut.Unlock()
+
+    return cache.page, nil
 }
 
 func (r *request) create() error {
+    err := r.validateBody()
+    if err != nil {
+        return err
+    }
+
     r.ctx.rwmut.Lock()
     defer r.ctx.rwmut.Unlock()
 
-    err := r.ctx.data.Create(r.urlPath, r)
+    err = r.ctx.data.Create(r.urlPath, r)
     if err != nil {
         reason := "Failed to create (POST) the data"
         return newError(err, reason, http.StatusInternalServerError)
@@ -303,10 +443,15 @@ func (r *request) create() error {
 }
 
 func (r *request) update() error {
+    err := r.validateBody()
+    if err != nil {
+        return err
+    }
+
     r.ctx.rwmut.Lock()
     defer r.ctx.rwmut.Unlock()
 
-    err := r.ctx.data.Update(r.urlPath, r)
+    err = r.ctx.data.Update(r.urlPath, r)
     if err != nil {
         reason := "Failed to update (PUT) the data"
         return newError(err, reason, http.StatusInternalServerError)
@@ -342,6 +487,11 @@ func (ctx *tmpl) Handle(w http.ResponseWriter, req *http.Request, urlPath []stri
     case http.MethodGet:
         return r.get()
     case http.MethodPost:
+        if len(urlPath) >= 2 && urlPath[1] == previewPath {
+            return r.preview()
+        } else if len(urlPath) >= 2 && urlPath[1] == exportPath {
+            return r.export()
+        }
         return r.create()
     case http.MethodPut:
         return r.update()
@@ -352,6 +502,31 @@ func (ctx *tmpl) Handle(w http.ResponseWriter, req *http.Request, urlPath []stri
     }
 }
 
+// Retrieve the metrics about the cached templates.
+func (ctx *tmpl) Metrics() []srv_iface.Metric {
+    ctx.rwmut.RLock()
+    pages := len(ctx.pages)
+    ctx.rwmut.RUnlock()
+
+    return []srv_iface.Metric {
+        srv_iface.Metric {
+            Name: "tmpl_cached_pages",
+            Help: "Number of parsed templates in the cache.",
+            Value: float64(pages),
+        },
+    }
+}
+
+// Remove every parsed template from the cache, so they are parsed again on
+// their next access.
+func (ctx *tmpl) invalidate([]string) {
+    ctx.rwmut.Lock()
+    defer ctx.rwmut.Unlock()
+
+    ctx.pages = make(map[string]cachedPage)
+    ctx.generation++
+}
+
 // Close resources associated with the `tmpl`
 func (ctx *tmpl) Close() {
     ctx.rwmut.Lock()
@@ -363,31 +538,112 @@ func (ctx *tmpl) Close() {
     ctx.data.Close()
 }
 
-// Register a `tmpl` handler in the `Server`.
-func GetHandle(srv srv_iface.Server, dirs []string, data DataCRUD, mapper Mapper) error {
+// Configure the `tmpl` server.
+type Config struct {
+    // List of directories where templates may be located.
+    Dirs []string
+    // File system (e.g., an `embed.FS`) searched if the template isn't
+    // in any directory. If set, the `tmpl/` directory in the current
+    // working directory becomes optional.
+    FS fs.FS
+    // File system searched before every directory (e.g., a
+    // `theme.Manager`).
+    Theme fs.FS
+    // Manages all data used by the templates.
+    Data DataCRUD
+    // Possibly maps resources into other resources. May be nil.
+    Mapper Mapper
+    // Content type for each extension (e.g., ".txt": "text/plain"),
+    // overriding the default ones.
+    MimeTypes map[string]string
+    // Extra functions available to every template, besides the ones
+    // retrieved by `Funcs()`. Functions with the same name as a default one
+    // replace it.
+    Funcs template.FuncMap
+    // Directory, inside every location where templates may be found, with
+    // templates shared by every page. Defaults to `DefaultPartialsDir`.
+    Partials string
+    // Extensions (e.g., ".txt") of the templates executed by
+    // `text/template`, instead of `html/template`. Defaults to
+    // `DefaultTextExtensions`.
+    TextExtensions []string
+    // Directory where every page is rendered by a POST `/tmpl/_export`.
+    // If empty, exporting pages is disabled.
+    ExportDir string
+    // Watches the template directories, notifying the clients whenever any
+    // template changes. If nil, the directories aren't watched.
+    Notifier reload.Notifier
+}
+
+// Register a `tmpl` handler in the `Server`. Every function in `funcs` is
+// available to the templates, just like `Config.Funcs`.
+func GetHandle(srv srv_iface.Server, dirs []string, data DataCRUD, mapper Mapper, funcs ...template.FuncMap) error {
+    cfg := Config {
+        Dirs: dirs,
+        Data: data,
+        Mapper: mapper,
+        Funcs: make(template.FuncMap),
+    }
+    for _, fm := range funcs {
+        for name, fn := range fm {
+            cfg.Funcs[name] = fn
+        }
+    }
+
+    return GetHandleFromConfig(srv, cfg)
+}
+
+// Register a `tmpl` handler in the `Server`. The resource is configured
+// based on the supplied `cfg`.
+func GetHandleFromConfig(srv srv_iface.Server, cfg Config) error {
+    ctx, err := newTmpl(cfg)
+    if err != nil {
+        return err
+    }
+
+    srv.AddHandler(ctx)
+    return nil
+}
+
+// Create a new `tmpl`, configured by `cfg`.
+func newTmpl(cfg Config) (*tmpl, error) {
     var ctx tmpl
 
     cwd, err := os.Getwd()
     if err != nil {
         reason := "Failed to the the current working directory"
-        return newError(err, reason, http.StatusInternalServerError)
+        return nil, newError(err, reason, http.StatusInternalServerError)
     }
 
     // Get a list with possible resources
     ctx.entryPoints, err = common.ListToAbsolutePath(nil, path.Join(cwd, "tmpl"))
-    if err != nil {
+    if err != nil && cfg.FS == nil {
         reason := "Failed to create the list of resource directories"
-        return newError(err, reason, http.StatusInternalServerError)
+        return nil, newError(err, reason, http.StatusInternalServerError)
     }
-    ctx.entryPoints, err = common.ListToAbsolutePath(ctx.entryPoints, dirs...)
+    ctx.entryPoints, err = common.ListToAbsolutePath(ctx.entryPoints, cfg.Dirs...)
     if err != nil {
         reason := "Failed to create the list of resource directories"
-        return newError(err, reason, http.StatusInternalServerError)
+        return nil, newError(err, reason, http.StatusInternalServerError)
     }
-    ctx.data = data
-    ctx.mapper = mapper
+    ctx.fs = cfg.FS
+    ctx.theme = cfg.Theme
+    ctx.mime = common.NewMimeRegistry(cfg.MimeTypes)
+    ctx.data = cfg.Data
+    ctx.mapper = cfg.Mapper
     ctx.pages = make(map[string]cachedPage)
+    ctx.partialsDir = cleanPartialsDir(cfg.Partials)
+    ctx.exportDir = cfg.ExportDir
+    ctx.textExts = textExtensions(cfg.TextExtensions)
+    ctx.funcs = Funcs()
+    for name, fn := range cfg.Funcs {
+        ctx.funcs[name] = fn
+    }
 
-    srv.AddHandler(&ctx)
-    return nil
+    if cfg.Notifier != nil {
+        ctx.watched = true
+        cfg.Notifier.Watch(ctx.entryPoints, ctx.invalidate)
+    }
+
+    return &ctx, nil
 }
@@ -0,0 +1,146 @@
+package tmpl
+
+import (
+    "bytes"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    srv_iface "github.com/SirGFM/gfm-speedrun-overlay/web/server/common"
+    "strings"
+    "testing"
+    "testing/fstest"
+)
+
+// Records the callback for the watched directories, without ever calling
+// it.
+type testNotifier struct {
+    onChange func([]string)
+}
+
+func (n *testNotifier) Watch(dirs []string, onChange func(changed []string)) {
+    n.onChange = onChange
+}
+
+func (*testNotifier) Notify(changed []string) {}
+
+// Create a `tmpl` configured by `cfg`. If no `fs.FS` is supplied, an
+// empty one is used, so the `tmpl/` directory is optional.
+func newTestTmpl(t *testing.T, cfg Config) *tmpl {
+    if cfg.FS == nil {
+        cfg.FS = fstest.MapFS{}
+    }
+
+    ctx, err := newTmpl(cfg)
+    if err != nil {
+        t.Fatalf("Failed to create the handler: %+v", err)
+    }
+    return ctx
+}
+
+// Write the files in `files` to the directory `dir`, creating every
+// missing directory.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+    for name, content := range files {
+        name = filepath.Join(dir, filepath.FromSlash(name))
+        os.MkdirAll(filepath.Dir(name), 0755)
+        err := os.WriteFile(name, []byte(content), 0644)
+        if err != nil {
+            t.Fatalf("Failed to write '%s': %+v", name, err)
+        }
+    }
+}
+
+// Send a request to `ctx`, retrieving its response.
+func serve(ctx *tmpl, method, target string, body io.Reader, hdr ...string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, target, body)
+    for i := 0; i + 1 < len(hdr); i += 2 {
+        req.Header.Set(hdr[i], hdr[i + 1])
+    }
+    w := httptest.NewRecorder()
+
+    urlPath := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+    err := ctx.Handle(w, req, urlPath)
+    if herr, ok := err.(srv_iface.HttpError); ok {
+        srv_iface.ReplyHttpError(herr, w, req)
+    } else if err != nil {
+        w.WriteHeader(http.StatusInternalServerError)
+    }
+    return w
+}
+
+// Load and execute the page `filePath` with `data`.
+func execPage(ctx *tmpl, filePath string, data interface{}) (string, error) {
+    file, _, err := ctx.getFile(filePath)
+    if err != nil {
+        return "", err
+    }
+
+    page, err := ctx.loadPage(filePath, file)
+    if err != nil {
+        return "", err
+    }
+
+    var buf bytes.Buffer
+    err = page.Execute(&buf, data)
+    return buf.String(), err
+}
+
+// Execute `filePath`, expecting its output to be `exp`.
+func expectPage(t *testing.T, ctx *tmpl, desc, filePath string, exp string) {
+    t.Helper()
+
+    got, err := execPage(ctx, filePath, nil)
+    if err != nil {
+        t.Errorf("%s: failed to execute '%s': %+v", desc, filePath, err)
+    } else if got != exp {
+        t.Errorf("%s: expected '%s' to be '%s', got '%s'", desc, filePath, exp, got)
+    }
+}
+
+func TestPageCache(t *testing.T) {
+    dir := t.TempDir()
+    themeDir := t.TempDir()
+    writeFiles(t, dir, map[string]string {
+        "page.html": "dir v1",
+    })
+    writeFiles(t, themeDir, map[string]string {
+        "themed.html": "theme v1",
+    })
+
+    n := &testNotifier{}
+    ctx := newTestTmpl(t, Config {
+        Dirs: []string { dir },
+        Theme: os.DirFS(themeDir),
+        Notifier: n,
+    })
+    expectPage(t, ctx, "First access", "page.html", "dir v1")
+    expectPage(t, ctx, "First access", "themed.html", "theme v1")
+
+    // Pages in the watched directories are only parsed again after a
+    // notification, but the theme's pages are always checked
+    writeFiles(t, dir, map[string]string {
+        "page.html": "dir v2",
+    })
+    writeFiles(t, themeDir, map[string]string {
+        "themed.html": "theme v2",
+    })
+    expectPage(t, ctx, "Before notifying", "page.html", "dir v1")
+    expectPage(t, ctx, "Theme changed", "themed.html", "theme v2")
+
+    n.onChange(nil)
+    expectPage(t, ctx, "After notifying", "page.html", "dir v2")
+
+    // A page overridden by the theme, or a partial in the theme, is
+    // detected even without a notification
+    writeFiles(t, themeDir, map[string]string {
+        "page.html": `theme {{ template "x" }}`,
+        "partials/x.html": `{{ define "x" }}x1{{ end }}`,
+    })
+    expectPage(t, ctx, "Overridden by the theme", "page.html", "theme x1")
+    writeFiles(t, themeDir, map[string]string {
+        "partials/x.html": `{{ define "x" }}x2{{ end }}`,
+    })
+    expectPage(t, ctx, "Theme's partial changed", "page.html", "theme x2")
+}